@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// implemented by the blacklist plugin
+type blacklistPlugin interface {
+	Blacklisted() []string
+	Blacklist(username string) (bool, error)
+	Unblacklist(username string) (bool, error)
+}
+
+// blacklist finds the blacklist plugin or responds with an error.
+func (self *Server) blacklist(w http.ResponseWriter) (blacklistPlugin, bool) {
+	for _, plugin := range self.bot.Plugins() {
+		if blacklist, okay := plugin.(blacklistPlugin); okay {
+			return blacklist, true
+		}
+	}
+
+	respondError(w, http.StatusConflict, "The blacklist plugin is not loaded.")
+	return nil, false
+}
+
+// blacklistUsername validates the username or responds with an error.
+func blacklistUsername(w http.ResponseWriter, name string) (string, bool) {
+	name = strings.ToLower(name)
+
+	if !usernameRegex.MatchString(name) {
+		respondError(w, http.StatusBadRequest, "The username is invalid.")
+		return "", false
+	}
+
+	return name, true
+}
+
+func (self *Server) listBlacklist(w http.ResponseWriter, r *http.Request, params []string) {
+	blacklist, found := self.blacklist(w)
+	if !found {
+		return
+	}
+
+	names := blacklist.Blacklisted()
+	sort.Strings(names)
+
+	respond(w, http.StatusOK, names)
+}
+
+func (self *Server) addToBlacklist(w http.ResponseWriter, r *http.Request, params []string) {
+	blacklist, found := self.blacklist(w)
+	if !found {
+		return
+	}
+
+	username, valid := blacklistUsername(w, params[0])
+	if !valid {
+		return
+	}
+
+	if username == strings.ToLower(self.bot.BotUsername()) || username == strings.ToLower(self.bot.OpUsername()) {
+		respondError(w, http.StatusConflict, "Neither the bot nor the operator can be blacklisted.")
+		return
+	}
+
+	added, err := blacklist.Blacklist(username)
+	if err != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{"username": username, "changed": added})
+}
+
+func (self *Server) removeFromBlacklist(w http.ResponseWriter, r *http.Request, params []string) {
+	blacklist, found := self.blacklist(w)
+	if !found {
+		return
+	}
+
+	username, valid := blacklistUsername(w, params[0])
+	if !valid {
+		return
+	}
+
+	removed, err := blacklist.Unblacklist(username)
+	if err != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	if !removed {
+		respondError(w, http.StatusNotFound, username+" is not blacklisted.")
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]bool{"deleted": true})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+)
+
+// implemented by the custom_commands plugin's workers
+type commandWorker interface {
+	Commands() map[string]string
+	SetCommand(cmd string, response string) (bool, error)
+	DeleteCommand(cmd string) (bool, error)
+	NormalizeCommand(cmd string) string
+}
+
+// inCommands runs the function inside the channel with its custom commands worker.
+// If the plugin is not enabled there, it responds with an error and returns false.
+func (self *Server) inCommands(w http.ResponseWriter, name string, fn func(commandWorker)) bool {
+	channel, found := self.channel(w, name)
+	if !found {
+		return false
+	}
+
+	enabled := false
+
+	if !inChannel(w, channel, func() {
+		worker, err := channel.WorkerByName("custom_commands")
+		if err != nil {
+			return
+		}
+
+		commands, okay := worker.(commandWorker)
+		if okay {
+			enabled = true
+			fn(commands)
+		}
+	}) {
+		return false
+	}
+
+	if !enabled {
+		respondError(w, http.StatusConflict, "The custom_commands plugin is not enabled in "+channel.Name()+".")
+	}
+
+	return enabled
+}
+
+func (self *Server) listCommands(w http.ResponseWriter, r *http.Request, params []string) {
+	var commands map[string]string
+
+	if self.inCommands(w, params[0], func(worker commandWorker) { commands = worker.Commands() }) {
+		respond(w, http.StatusOK, commands)
+	}
+}
+
+func (self *Server) setCommand(w http.ResponseWriter, r *http.Request, params []string) {
+	request := struct {
+		Response string `json:"response"`
+	}{}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	if len(request.Response) == 0 {
+		respondError(w, http.StatusBadRequest, "The response must not be empty.")
+		return
+	}
+
+	var cmd string
+	var created bool
+	var err error
+
+	if !self.inCommands(w, params[0], func(worker commandWorker) {
+		cmd = worker.NormalizeCommand(params[1])
+		if len(cmd) > 0 {
+			created, err = worker.SetCommand(cmd, request.Response)
+		}
+	}) {
+		return
+	}
+
+	switch {
+	case len(cmd) == 0:
+		respondError(w, http.StatusBadRequest, "The command name is invalid or reserved.")
+	case err != nil:
+		respondStorageFailure(w)
+	case created:
+		respond(w, http.StatusCreated, map[string]string{"command": cmd, "response": request.Response})
+	default:
+		respond(w, http.StatusOK, map[string]string{"command": cmd, "response": request.Response})
+	}
+}
+
+func (self *Server) deleteCommand(w http.ResponseWriter, r *http.Request, params []string) {
+	var deleted bool
+	var err error
+
+	if !self.inCommands(w, params[0], func(worker commandWorker) {
+		deleted, err = worker.DeleteCommand(worker.NormalizeCommand(params[1]))
+	}) {
+		return
+	}
+
+	if err != nil {
+		respondStorageFailure(w)
+	} else if !deleted {
+		respondError(w, http.StatusNotFound, "There is no such command.")
+	} else {
+		respond(w, http.StatusOK, map[string]bool{"deleted": true})
+	}
+}
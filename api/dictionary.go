@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+// changes through the API are recorded in the dictionary history like this
+var apiAuthor = bot.DictionaryAuthor{Source: "api"}
+
+type dictionaryRequest struct {
+	Value string `json:"value"`
+}
+
+// dictionaryEntries collects the values of the keys
+func dictionaryEntries(keys []string, get func(string) string) map[string]string {
+	entries := make(map[string]string, len(keys))
+
+	for _, key := range keys {
+		entries[key] = get(key)
+	}
+
+	return entries
+}
+
+func (self *Server) listDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	dict := self.bot.Dictionary()
+
+	respond(w, http.StatusOK, dictionaryEntries(dict.Keys(), dict.Get))
+}
+
+func (self *Server) setDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	request := dictionaryRequest{}
+
+	if decode(w, r, &request) {
+		respondSet(w, params[0], request.Value, self.bot.Dictionary().SetBy)
+	}
+}
+
+func (self *Server) deleteDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	respondDelete(w, params[0], self.bot.Dictionary().DeleteBy)
+}
+
+func (self *Server) listChannelDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	dict := channel.Dictionary()
+
+	respond(w, http.StatusOK, dictionaryEntries(dict.Keys(), dict.Get))
+}
+
+func (self *Server) setChannelDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	request := dictionaryRequest{}
+
+	if decode(w, r, &request) {
+		respondSet(w, params[1], request.Value, channel.Dictionary().SetBy)
+	}
+}
+
+func (self *Server) deleteChannelDictionary(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	respondDelete(w, params[1], channel.Dictionary().DeleteBy)
+}
+
+func respondSet(w http.ResponseWriter, key string, value string, set func(string, string, bot.DictionaryAuthor) error) {
+	key = strings.ToLower(key)
+
+	if len(value) == 0 {
+		respondError(w, http.StatusBadRequest, "The value must not be empty.")
+		return
+	}
+
+	if set(key, value, apiAuthor) != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"key": key, "value": value})
+}
+
+func respondDelete(w http.ResponseWriter, key string, remove func(string, bot.DictionaryAuthor) (bool, error)) {
+	deleted, err := remove(strings.ToLower(key), apiAuthor)
+
+	if err != nil {
+		respondStorageFailure(w)
+	} else if !deleted {
+		respondError(w, http.StatusNotFound, "There is no such entry.")
+	} else {
+		respond(w, http.StatusOK, map[string]bool{"deleted": true})
+	}
+}
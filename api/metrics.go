@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+// metrics serves the bot's metrics in the Prometheus text format. The format is
+// simple enough to not pull in the whole client library for it.
+func (self *Server) metrics(w http.ResponseWriter, r *http.Request, params []string) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	e := exposition{out}
+	b := self.bot
+	metrics := b.Metrics()
+
+	// the bot itself
+
+	e.header("kabukibot_start_time_seconds", "gauge", "When the bot was started.")
+	e.sample("kabukibot_start_time_seconds", nil, float64(metrics.Started().Unix()))
+
+	e.header("kabukibot_twitch_reconnects_total", "counter", "How often the connection to Twitch was lost and the bot had to be restarted.")
+	e.sample("kabukibot_twitch_reconnects_total", nil, float64(metrics.Reconnects()))
+
+	connected := 1.0
+	select {
+	case <-b.Alive():
+		connected = 0
+	default:
+	}
+
+	e.header("kabukibot_twitch_connected", "gauge", "Whether the bot is connected to Twitch.")
+	e.sample("kabukibot_twitch_connected", nil, connected)
+
+	// the Twitch connection
+
+	e.header("kabukibot_twitch_queue_length", "gauge", "Outgoing messages waiting to be sent.")
+	e.sample("kabukibot_twitch_queue_length", nil, float64(b.QueueLen()))
+
+	e.header("kabukibot_twitch_messages_received_total", "counter", "IRC messages received from Twitch.")
+	e.sample("kabukibot_twitch_messages_received_total", nil, float64(b.MessagesReceived()))
+
+	e.header("kabukibot_twitch_messages_sent_total", "counter", "IRC messages sent to Twitch.")
+	e.sample("kabukibot_twitch_messages_sent_total", nil, float64(b.MessagesSent()))
+
+	e.header("kabukibot_twitch_messages_dropped_total", "counter", "Outgoing messages dropped because the queue was full.")
+	e.sample("kabukibot_twitch_messages_dropped_total", nil, float64(b.MessagesDropped()))
+
+	// channels
+
+	stats := b.ChannelStats()
+	channels := make([]string, 0, len(stats))
+
+	for channel := range stats {
+		channels = append(channels, channel)
+	}
+
+	sort.Strings(channels)
+
+	e.header("kabukibot_channel_messages_received_total", "counter", "Messages handed to the channel.")
+	for _, channel := range channels {
+		e.sample("kabukibot_channel_messages_received_total", []string{"channel", channel}, float64(stats[channel].Received))
+	}
+
+	e.header("kabukibot_channel_messages_dropped_total", "counter", "Chat lines dropped because the channel was lagging.")
+	for _, channel := range channels {
+		e.sample("kabukibot_channel_messages_dropped_total", []string{"channel", channel}, float64(stats[channel].Dropped))
+	}
+
+	e.header("kabukibot_channel_queue_length", "gauge", "Messages waiting to be handled by the channel.")
+	for _, channel := range channels {
+		e.sample("kabukibot_channel_queue_length", []string{"channel", channel}, float64(stats[channel].Queued))
+	}
+
+	sent := metrics.MessagesSent()
+
+	e.header("kabukibot_channel_messages_sent_total", "counter", "Messages sent to the channel.")
+	for _, channel := range sortedKeys(sent) {
+		e.sample("kabukibot_channel_messages_sent_total", []string{"channel", channel}, float64(sent[channel]))
+	}
+
+	notSent := metrics.MessagesNotSent()
+
+	e.header("kabukibot_channel_messages_not_sent_total", "counter", "Messages to the channel that could not be sent, e.g. because the queue was full.")
+	for _, channel := range sortedKeys(notSent) {
+		e.sample("kabukibot_channel_messages_not_sent_total", []string{"channel", channel}, float64(notSent[channel]))
+	}
+
+	moderation := metrics.ModerationActions()
+	moderated := make([]string, 0, len(moderation))
+
+	for channel := range moderation {
+		moderated = append(moderated, channel)
+	}
+
+	sort.Strings(moderated)
+
+	e.header("kabukibot_moderation_actions_total", "counter", "Bans and timeouts issued by the bot.")
+	for _, channel := range moderated {
+		for _, action := range sortedKeys(moderation[channel]) {
+			e.sample("kabukibot_moderation_actions_total", []string{"channel", channel, "action", action}, float64(moderation[channel][action]))
+		}
+	}
+
+	// plugins
+
+	commands := metrics.CommandsExecuted()
+
+	e.header("kabukibot_commands_total", "counter", "Commands the plugins have responded to.")
+	for _, plugin := range sortedKeys(commands) {
+		e.sample("kabukibot_commands_total", []string{"plugin", plugin}, float64(commands[plugin]))
+	}
+
+	durations := metrics.HandlerDurations()
+	plugins := make([]string, 0, len(durations))
+
+	for plugin := range durations {
+		plugins = append(plugins, plugin)
+	}
+
+	sort.Strings(plugins)
+
+	e.header("kabukibot_plugin_handler_duration_seconds", "histogram", "How long the plugins take to handle incoming messages.")
+	for _, plugin := range plugins {
+		e.histogram("kabukibot_plugin_handler_duration_seconds", []string{"plugin", plugin}, durations[plugin])
+	}
+
+	// the database
+
+	db := b.Database().Stats()
+
+	e.header("kabukibot_database_queries_total", "counter", "Database queries and transactions.")
+	e.sample("kabukibot_database_queries_total", nil, float64(db.Queries))
+
+	e.header("kabukibot_database_retries_total", "counter", "Queries retried because of transient errors.")
+	e.sample("kabukibot_database_retries_total", nil, float64(db.Retries))
+
+	e.header("kabukibot_database_errors_total", "counter", "Queries that failed, even after retrying.")
+	e.sample("kabukibot_database_errors_total", nil, float64(db.Errors))
+
+	// the Go runtime
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	e.header("go_info", "gauge", "Information about the Go environment.")
+	e.sample("go_info", []string{"version", runtime.Version()}, 1)
+
+	e.header("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	e.sample("go_goroutines", nil, float64(runtime.NumGoroutine()))
+
+	e.header("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	e.sample("go_memstats_alloc_bytes", nil, float64(mem.Alloc))
+
+	e.header("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.")
+	e.sample("go_memstats_heap_inuse_bytes", nil, float64(mem.HeapInuse))
+
+	e.header("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from the system.")
+	e.sample("go_memstats_sys_bytes", nil, float64(mem.Sys))
+
+	e.header("go_memstats_mallocs_total", "counter", "Total number of mallocs.")
+	e.sample("go_memstats_mallocs_total", nil, float64(mem.Mallocs))
+
+	e.header("go_memstats_frees_total", "counter", "Total number of frees.")
+	e.sample("go_memstats_frees_total", nil, float64(mem.Frees))
+
+	e.header("go_gc_runs_total", "counter", "Number of completed garbage collections.")
+	e.sample("go_gc_runs_total", nil, float64(mem.NumGC))
+
+	e.header("go_gc_pause_seconds_total", "counter", "Total time the garbage collector stopped the world.")
+	e.sample("go_gc_pause_seconds_total", nil, float64(mem.PauseTotalNs)/1e9)
+}
+
+func sortedKeys(counters map[string]uint64) []string {
+	keys := make([]string, 0, len(counters))
+
+	for key := range counters {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// exposition writes the Prometheus text format; labels are given as name/value pairs.
+type exposition struct {
+	out *bufio.Writer
+}
+
+func (self exposition) header(name string, kind string, help string) {
+	fmt.Fprintf(self.out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (self exposition) sample(name string, labels []string, value float64) {
+	self.out.WriteString(name)
+
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+
+		for idx := 0; idx+1 < len(labels); idx += 2 {
+			pairs = append(pairs, labels[idx]+`="`+labelEscaper.Replace(labels[idx+1])+`"`)
+		}
+
+		self.out.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	self.out.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+func (self exposition) histogram(name string, labels []string, histogram bot.Histogram) {
+	for idx, bound := range histogram.Buckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		self.sample(name+"_bucket", append(labels, "le", le), float64(histogram.Counts[idx]))
+	}
+
+	self.sample(name+"_bucket", append(labels, "le", "+Inf"), float64(histogram.Count))
+	self.sample(name+"_sum", labels, histogram.Sum)
+	self.sample(name+"_count", labels, float64(histogram.Count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
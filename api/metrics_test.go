@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func TestExposition(t *testing.T) {
+	buffer := bytes.Buffer{}
+	out := bufio.NewWriter(&buffer)
+	e := exposition{out}
+
+	e.header("test_total", "counter", "Just a test.")
+	e.sample("test_total", nil, 3)
+	e.sample("test_total", []string{"channel", `#a"b\c` + "\nd", "action", "ban"}, 0.5)
+
+	e.header("test_seconds", "histogram", "How long it took.")
+	e.histogram("test_seconds", []string{"plugin", "ping"}, bot.Histogram{
+		Buckets: []float64{0.001, 0.5},
+		Counts:  []uint64{1, 2},
+		Count:   3,
+		Sum:     1.25,
+	})
+
+	out.Flush()
+
+	expected := strings.Join([]string{
+		"# HELP test_total Just a test.",
+		"# TYPE test_total counter",
+		"test_total 3",
+		`test_total{channel="#a\"b\\c\nd",action="ban"} 0.5`,
+		"# HELP test_seconds How long it took.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{plugin="ping",le="0.001"} 1`,
+		`test_seconds_bucket{plugin="ping",le="0.5"} 2`,
+		`test_seconds_bucket{plugin="ping",le="+Inf"} 3`,
+		`test_seconds_sum{plugin="ping"} 1.25`,
+		`test_seconds_count{plugin="ping"} 3`,
+	}, "\n") + "\n"
+
+	if buffer.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, buffer.String())
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	api := startServer(t)
+	defer api.stop()
+
+	api.expect("GET", "/metrics", "", http.StatusOK, "# TYPE kabukibot_twitch_reconnects_total counter\nkabukibot_twitch_reconnects_total 0\n")
+	api.expect("GET", "/metrics", "", http.StatusOK, `kabukibot_channel_queue_length{channel="#chan"} 0`)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// a handler receives the values of the pattern's placeholders in order
+type handler func(w http.ResponseWriter, r *http.Request, params []string)
+
+type route struct {
+	method   string
+	segments []string // "*" matches any single segment
+	handler  handler
+}
+
+// router matches request paths segment by segment, like "/channels/*/plugins". As
+// the path is matched unescaped, channels can be given as "%23foo" or just "foo".
+type router struct {
+	routes []route
+}
+
+func newRouter() *router {
+	return &router{routes: make([]route, 0)}
+}
+
+func (self *router) handle(method string, pattern string, h handler) {
+	self.routes = append(self.routes, route{method, splitPath(pattern), h})
+}
+
+func (self *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	pathFound := false
+
+	for _, route := range self.routes {
+		params, matched := route.match(segments)
+		if !matched {
+			continue
+		}
+
+		pathFound = true
+
+		if route.method == r.Method {
+			route.handler(w, r, params)
+			return
+		}
+	}
+
+	if pathFound {
+		respondError(w, http.StatusMethodNotAllowed, "This method is not supported here.")
+	} else {
+		respondError(w, http.StatusNotFound, "There is nothing here.")
+	}
+}
+
+func (self route) match(segments []string) ([]string, bool) {
+	if len(segments) != len(self.segments) {
+		return nil, false
+	}
+
+	params := make([]string, 0)
+
+	for idx, segment := range self.segments {
+		if segment == "*" {
+			params = append(params, segments[idx])
+		} else if segment != segments[idx] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
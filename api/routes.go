@@ -0,0 +1,408 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func (self *Server) routes() {
+	self.router.handle("GET", "/channels", self.listChannels)
+	self.router.handle("POST", "/channels", self.joinChannel)
+	self.router.handle("DELETE", "/channels/*", self.partChannel)
+
+	self.router.handle("GET", "/channels/*/plugins", self.listPlugins)
+	self.router.handle("PUT", "/channels/*/plugins/*", self.enablePlugin)
+	self.router.handle("DELETE", "/channels/*/plugins/*", self.disablePlugin)
+
+	self.router.handle("GET", "/channels/*/acl", self.listACL)
+	self.router.handle("POST", "/channels/*/acl", self.changeACL)
+
+	self.router.handle("GET", "/channels/*/settings", self.listSettings)
+	self.router.handle("PUT", "/channels/*/settings/*/*", self.changeSetting)
+
+	self.router.handle("GET", "/channels/*/commands", self.listCommands)
+	self.router.handle("PUT", "/channels/*/commands/*", self.setCommand)
+	self.router.handle("DELETE", "/channels/*/commands/*", self.deleteCommand)
+
+	self.router.handle("GET", "/channels/*/dictionary", self.listChannelDictionary)
+	self.router.handle("PUT", "/channels/*/dictionary/*", self.setChannelDictionary)
+	self.router.handle("DELETE", "/channels/*/dictionary/*", self.deleteChannelDictionary)
+
+	self.router.handle("GET", "/dictionary", self.listDictionary)
+	self.router.handle("PUT", "/dictionary/*", self.setDictionary)
+	self.router.handle("DELETE", "/dictionary/*", self.deleteDictionary)
+
+	self.router.handle("GET", "/blacklist", self.listBlacklist)
+	self.router.handle("PUT", "/blacklist/*", self.addToBlacklist)
+	self.router.handle("DELETE", "/blacklist/*", self.removeFromBlacklist)
+
+	self.router.handle("GET", "/metrics", self.metrics)
+}
+
+var channelNameRegex = regexp.MustCompile(`^#[a-z0-9_]{1,25}$`)
+var usernameRegex = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+// channelName accepts channel names with and without the leading '#'.
+func channelName(name string) string {
+	name = strings.ToLower(name)
+
+	if !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+
+	return name
+}
+
+// channel finds a joined channel or responds with an error.
+func (self *Server) channel(w http.ResponseWriter, name string) (bot.Channel, bool) {
+	name = channelName(name)
+
+	channel, err := self.bot.Channel(name)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "The bot is not in "+name+".")
+		return nil, false
+	}
+
+	return channel, true
+}
+
+// inChannel runs the function inside the channel's goroutine, because plugin
+// workers are not safe to use from anywhere else.
+func inChannel(w http.ResponseWriter, channel bot.Channel, fn func()) bool {
+	err := channel.Run(fn)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (self *Server) plugin(name string) (bot.Plugin, bool) {
+	for _, plugin := range self.bot.Plugins() {
+		if plugin.Name() != "" && plugin.Name() == name {
+			return plugin, true
+		}
+	}
+
+	return nil, false
+}
+
+type channelInfo struct {
+	Name     string `json:"name"`
+	Queued   int    `json:"queued"`
+	Received uint64 `json:"received"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func (self *Server) listChannels(w http.ResponseWriter, r *http.Request, params []string) {
+	list := make([]channelInfo, 0)
+
+	for name, stats := range self.bot.ChannelStats() {
+		list = append(list, channelInfo{name, stats.Queued, stats.Received, stats.Dropped})
+	}
+
+	sort.Sort(byChannelName(list))
+
+	respond(w, http.StatusOK, list)
+}
+
+type byChannelName []channelInfo
+
+func (self byChannelName) Len() int           { return len(self) }
+func (self byChannelName) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+func (self byChannelName) Less(i, j int) bool { return self[i].Name < self[j].Name }
+
+func (self *Server) joinChannel(w http.ResponseWriter, r *http.Request, params []string) {
+	request := struct {
+		Name string `json:"name"`
+	}{}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	name := channelName(request.Name)
+	if !channelNameRegex.MatchString(name) {
+		respondError(w, http.StatusBadRequest, "The channel name is invalid.")
+		return
+	}
+
+	if self.bot.Joined(name) {
+		respond(w, http.StatusOK, map[string]interface{}{"name": name, "joined": false})
+		return
+	}
+
+	<-self.bot.Join(name)
+
+	respond(w, http.StatusCreated, map[string]interface{}{"name": name, "joined": true})
+}
+
+func (self *Server) partChannel(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	if channel.Name() == "#"+strings.ToLower(self.bot.BotUsername()) {
+		respondError(w, http.StatusConflict, "The bot never leaves its own channel.")
+		return
+	}
+
+	// the channel is left as soon as Twitch confirms it
+	<-self.bot.Part(channel.Name())
+
+	respond(w, http.StatusAccepted, map[string]interface{}{"name": channel.Name(), "left": true})
+}
+
+type pluginState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (self *Server) listPlugins(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	enabled := make(map[string]bool)
+
+	if !inChannel(w, channel, func() {
+		for _, plugin := range channel.Plugins() {
+			enabled[plugin.Name()] = true
+		}
+	}) {
+		return
+	}
+
+	list := make([]pluginState, 0)
+
+	for _, plugin := range self.bot.Plugins() {
+		if plugin.Name() != "" {
+			list = append(list, pluginState{plugin.Name(), enabled[plugin.Name()]})
+		}
+	}
+
+	respond(w, http.StatusOK, list)
+}
+
+func (self *Server) enablePlugin(w http.ResponseWriter, r *http.Request, params []string) {
+	self.togglePlugin(w, params, true)
+}
+
+func (self *Server) disablePlugin(w http.ResponseWriter, r *http.Request, params []string) {
+	self.togglePlugin(w, params, false)
+}
+
+func (self *Server) togglePlugin(w http.ResponseWriter, params []string, enable bool) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	if _, exists := self.plugin(params[1]); !exists {
+		respondError(w, http.StatusNotFound, "There is no plugin named "+params[1]+".")
+		return
+	}
+
+	var changed bool
+	var err error
+
+	if !inChannel(w, channel, func() {
+		if enable {
+			changed, err = channel.EnablePlugin(params[1])
+		} else {
+			changed, err = channel.DisablePlugin(params[1])
+		}
+	}) {
+		return
+	}
+
+	if err != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{"name": params[1], "enabled": enable, "changed": changed})
+}
+
+type aclEntry struct {
+	Allowed []string `json:"allowed"`
+	Denied  []string `json:"denied"`
+}
+
+// permissions collects the permissions of all enabled plugins; it must run inside
+// the channel.
+func permissions(channel bot.Channel) []string {
+	result := make([]string, 0)
+
+	for _, worker := range channel.Workers() {
+		result = append(result, worker.Permissions()...)
+	}
+
+	return result
+}
+
+func (self *Server) listACL(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	var perms []string
+
+	if !inChannel(w, channel, func() { perms = permissions(channel) }) {
+		return
+	}
+
+	acl := channel.ACL()
+	entries := make(map[string]aclEntry)
+	groups := make(map[string][]string)
+
+	for _, perm := range perms {
+		entries[perm] = aclEntry{acl.AllowedUsers(perm), acl.DeniedUsers(perm)}
+	}
+
+	for _, group := range acl.Groups() {
+		groups[group] = acl.GroupMembers(group)
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{"permissions": entries, "groups": groups})
+}
+
+func (self *Server) changeACL(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	request := struct {
+		Action     string `json:"action"` // "allow", "deny" or "revoke"
+		Permission string `json:"permission"`
+		User       string `json:"user"` // a username or group
+	}{}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	var perms []string
+
+	if !inChannel(w, channel, func() { perms = permissions(channel) }) {
+		return
+	}
+
+	known := false
+
+	for _, perm := range perms {
+		if perm == request.Permission {
+			known = true
+			break
+		}
+	}
+
+	if !known {
+		respondError(w, http.StatusBadRequest, "The permission is not known in this channel.")
+		return
+	}
+
+	acl := channel.ACL()
+	user := strings.ToLower(request.User)
+
+	if !usernameRegex.MatchString(user) && !bot.IsCustomGroup(user) && !isBuiltinGroup(user) {
+		respondError(w, http.StatusBadRequest, "The user must be a username or group.")
+		return
+	}
+
+	var changed bool
+	var err error
+
+	switch request.Action {
+	case "allow":
+		changed, err = acl.Allow(user, request.Permission)
+	case "deny":
+		changed, err = acl.Deny(user, request.Permission)
+	case "revoke":
+		changed, err = acl.Revoke(user, request.Permission)
+	default:
+		respondError(w, http.StatusBadRequest, "The action must be allow, deny or revoke.")
+		return
+	}
+
+	if err != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{"changed": changed})
+}
+
+func isBuiltinGroup(name string) bool {
+	for _, group := range bot.ACLGroups() {
+		if group == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *Server) listSettings(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	settings := channel.Settings()
+	result := make(map[string]map[string]string)
+
+	for _, plugin := range settings.Plugins() {
+		result[plugin] = make(map[string]string)
+
+		for _, setting := range settings.Declared(plugin) {
+			result[plugin][setting.Key] = settings.Get(plugin, setting.Key)
+		}
+	}
+
+	respond(w, http.StatusOK, result)
+}
+
+func (self *Server) changeSetting(w http.ResponseWriter, r *http.Request, params []string) {
+	channel, found := self.channel(w, params[0])
+	if !found {
+		return
+	}
+
+	request := struct {
+		Value string `json:"value"`
+	}{}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	settings := channel.Settings()
+
+	value, err := settings.Check(params[1], params[2], request.Value)
+	if err == bot.ErrUnknownSetting {
+		respondError(w, http.StatusNotFound, "There is no such setting.")
+		return
+	}
+
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if settings.Set(params[1], params[2], value) != nil {
+		respondStorageFailure(w)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"value": value})
+}
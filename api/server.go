@@ -0,0 +1,108 @@
+// Package api serves an HTTP interface to manage the bot, so that dashboards do not
+// have to go through chat commands. All requests must carry the configured token as
+// "Authorization: Bearer <token>"; all responses are JSON, except for /metrics,
+// which is meant to be scraped by Prometheus.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+type Server struct {
+	bot      *bot.Kabukibot
+	log      bot.Logger
+	token    string
+	router   *router
+	listener net.Listener
+}
+
+func NewServer(kabukibot *bot.Kabukibot, token string) *Server {
+	server := &Server{
+		bot:    kabukibot,
+		log:    kabukibot.Logger().Subsystem("api"),
+		token:  token,
+		router: newRouter(),
+	}
+
+	server.routes()
+
+	return server
+}
+
+// Start listens on the address and serves requests in the background.
+func (self *Server) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	self.listener = listener
+	self.log.Info("Serving the API @ %s...", address)
+
+	go http.Serve(listener, self)
+
+	return nil
+}
+
+// Stop closes the listener; requests in progress are not waited for.
+func (self *Server) Stop() error {
+	if self.listener == nil {
+		return nil
+	}
+
+	return self.listener.Close()
+}
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !self.authorized(r) {
+		respondError(w, http.StatusUnauthorized, "A valid token is required.")
+		return
+	}
+
+	self.router.ServeHTTP(w, r)
+}
+
+func (self *Server) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	given := strings.TrimPrefix(header, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(given), []byte(self.token)) == 1
+}
+
+func respond(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(data)
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	respond(w, status, map[string]string{"error": message})
+}
+
+func respondStorageFailure(w http.ResponseWriter) {
+	respondError(w, http.StatusServiceUnavailable, "The change could not be saved, please try again later.")
+}
+
+// decode reads the JSON request body into dest and responds with an error if that
+// does not work.
+func decode(w http.ResponseWriter, r *http.Request, dest interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(dest)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "The request body is not valid JSON: "+err.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin/blacklist"
+	"github.com/sgt-kabukiman/kabukibot/plugin/custom_commands"
+	"github.com/sgt-kabukiman/kabukibot/test"
+)
+
+const testToken = "0123456789abcdef"
+
+// a plugin with a setting that can be given an invalid value
+type limitPlugin struct{}
+
+func (self limitPlugin) Name() string                                      { return "limit" }
+func (self limitPlugin) Setup(*bot.Kabukibot)                              {}
+func (self limitPlugin) CreateWorker(channel bot.Channel) bot.PluginWorker { return limitWorker{} }
+
+func (self limitPlugin) Settings() []bot.Setting {
+	return []bot.Setting{{Key: "max", Type: bot.IntSetting, Default: "3"}}
+}
+
+type limitWorker struct{}
+
+func (self limitWorker) Enable()               {}
+func (self limitWorker) Disable()              {}
+func (self limitWorker) Part()                 {}
+func (self limitWorker) Shutdown()             {}
+func (self limitWorker) Permissions() []string { return []string{} }
+
+type apiTest struct {
+	t      *testing.T
+	bot    *bot.Kabukibot
+	server *Server
+}
+
+func startServer(t *testing.T, plugins ...bot.Plugin) *apiTest {
+	config := &bot.Configuration{CommandPrefix: "k_", Operator: "op"}
+	config.Account.Username = "bot"
+
+	db, err := bot.OpenDatabase("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Could not open the database: %s", err)
+	}
+
+	kabukibot, err := test.StartBot(config, db, plugins...)
+	if err != nil {
+		t.Fatalf("Could not start the bot: %s", err)
+	}
+
+	<-kabukibot.Join("#chan")
+
+	return &apiTest{t, kabukibot, NewServer(kabukibot, testToken)}
+}
+
+func (self *apiTest) stop() {
+	self.bot.Shutdown()
+	self.bot.Database().Close()
+}
+
+func (self *apiTest) send(method string, path string, body string, authorization string) (int, string) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	if len(authorization) > 0 {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	self.server.ServeHTTP(w, r)
+
+	return w.Code, w.Body.String()
+}
+
+// expect sends an authorized request and checks the status and that the response
+// contains the given text.
+func (self *apiTest) expect(method string, path string, body string, status int, contains string) {
+	code, response := self.send(method, path, body, "Bearer "+testToken)
+
+	if code != status {
+		self.t.Errorf("%s %s: expected status %d, got %d (%s).", method, path, status, code, strings.TrimSpace(response))
+	}
+
+	if !strings.Contains(response, contains) {
+		self.t.Errorf("%s %s: expected the response to contain '%s', got '%s'.", method, path, contains, strings.TrimSpace(response))
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	api := startServer(t)
+	defer api.stop()
+
+	for _, authorization := range []string{"", "Bearer", "Bearer wrong", testToken, "Basic " + testToken, "bearer " + testToken} {
+		code, _ := api.send("GET", "/channels", "", authorization)
+		if code != http.StatusUnauthorized {
+			t.Errorf("Expected '%s' to be rejected, got status %d.", authorization, code)
+		}
+	}
+
+	// the token is checked before anything else
+	code, _ := api.send("GET", "/nothing/here", "", "")
+	if code != http.StatusUnauthorized {
+		t.Errorf("Expected unknown paths to require the token, got status %d.", code)
+	}
+
+	api.expect("GET", "/channels", "", http.StatusOK, `"name":"#chan"`)
+}
+
+func TestRouting(t *testing.T) {
+	api := startServer(t)
+	defer api.stop()
+
+	api.expect("GET", "/nothing/here", "", http.StatusNotFound, "error")
+	api.expect("PATCH", "/channels", "", http.StatusMethodNotAllowed, "error")
+	api.expect("POST", "/channels", "{", http.StatusBadRequest, "not valid JSON")
+}
+
+func TestChannels(t *testing.T) {
+	api := startServer(t)
+	defer api.stop()
+
+	api.expect("GET", "/channels", "", http.StatusOK, `"name":"#bot"`)
+
+	api.expect("POST", "/channels", `{"name": "Other"}`, http.StatusCreated, `"joined":true`)
+	api.expect("POST", "/channels", `{"name": "#other"}`, http.StatusOK, `"joined":false`)
+	api.expect("POST", "/channels", `{"name": "no way"}`, http.StatusBadRequest, "invalid")
+
+	api.expect("DELETE", "/channels/bot", "", http.StatusConflict, "never leaves")
+	api.expect("DELETE", "/channels/nowhere", "", http.StatusNotFound, "not in #nowhere")
+	api.expect("DELETE", "/channels/%23other", "", http.StatusAccepted, `"left":true`)
+}
+
+func TestPlugins(t *testing.T) {
+	api := startServer(t, custom_commands.NewPlugin())
+	defer api.stop()
+
+	api.expect("GET", "/channels/chan/plugins", "", http.StatusOK, `{"name":"custom_commands","enabled":false}`)
+	api.expect("GET", "/channels/nowhere/plugins", "", http.StatusNotFound, "error")
+
+	api.expect("PUT", "/channels/chan/plugins/custom_commands", "", http.StatusOK, `"changed":true`)
+	api.expect("PUT", "/channels/chan/plugins/custom_commands", "", http.StatusOK, `"changed":false`)
+	api.expect("GET", "/channels/chan/plugins", "", http.StatusOK, `{"name":"custom_commands","enabled":true}`)
+	api.expect("PUT", "/channels/chan/plugins/nope", "", http.StatusNotFound, "no plugin named nope")
+
+	api.expect("DELETE", "/channels/chan/plugins/custom_commands", "", http.StatusOK, `"enabled":false,`)
+}
+
+func TestACL(t *testing.T) {
+	api := startServer(t, custom_commands.NewPlugin())
+	defer api.stop()
+
+	api.expect("PUT", "/channels/chan/plugins/custom_commands", "", http.StatusOK, "")
+
+	api.expect("POST", "/channels/chan/acl", `{"action": "allow", "permission": "custom_commands.list", "user": "Bob"}`, http.StatusOK, `"changed":true`)
+	api.expect("POST", "/channels/chan/acl", `{"action": "allow", "permission": "custom_commands.list", "user": "bob"}`, http.StatusOK, `"changed":false`)
+	api.expect("POST", "/channels/chan/acl", `{"action": "deny", "permission": "custom_commands.configure", "user": "$mods"}`, http.StatusOK, `"changed":true`)
+	api.expect("GET", "/channels/chan/acl", "", http.StatusOK, `"custom_commands.list":{"allowed":["bob"]`)
+
+	api.expect("POST", "/channels/chan/acl", `{"action": "allow", "permission": "nope", "user": "bob"}`, http.StatusBadRequest, "not known")
+	api.expect("POST", "/channels/chan/acl", `{"action": "allow", "permission": "custom_commands.list", "user": "no one"}`, http.StatusBadRequest, "username or group")
+	api.expect("POST", "/channels/chan/acl", `{"action": "grant", "permission": "custom_commands.list", "user": "bob"}`, http.StatusBadRequest, "allow, deny or revoke")
+	api.expect("GET", "/channels/nowhere/acl", "", http.StatusNotFound, "error")
+}
+
+func TestSettings(t *testing.T) {
+	api := startServer(t, limitPlugin{})
+	defer api.stop()
+
+	api.expect("GET", "/channels/chan/settings", "", http.StatusOK, `"limit":{"max":"3"}`)
+	api.expect("PUT", "/channels/chan/settings/limit/max", `{"value": "05"}`, http.StatusOK, `"value":"5"`)
+	api.expect("GET", "/channels/chan/settings", "", http.StatusOK, `"limit":{"max":"5"}`)
+
+	api.expect("PUT", "/channels/chan/settings/limit/max", `{"value": "many"}`, http.StatusBadRequest, "whole number")
+	api.expect("PUT", "/channels/chan/settings/limit/min", `{"value": "1"}`, http.StatusNotFound, "no such setting")
+	api.expect("PUT", "/channels/nowhere/settings/limit/max", `{"value": "1"}`, http.StatusNotFound, "error")
+}
+
+func TestCommands(t *testing.T) {
+	api := startServer(t, custom_commands.NewPlugin())
+	defer api.stop()
+
+	api.expect("GET", "/channels/chan/commands", "", http.StatusConflict, "not enabled")
+	api.expect("PUT", "/channels/chan/plugins/custom_commands", "", http.StatusOK, "")
+
+	api.expect("PUT", "/channels/chan/commands/!Hello", `{"response": "hi"}`, http.StatusCreated, `"command":"hello"`)
+	api.expect("PUT", "/channels/chan/commands/hello", `{"response": "hey"}`, http.StatusOK, `"response":"hey"`)
+	api.expect("GET", "/channels/chan/commands", "", http.StatusOK, `"hello":"hey"`)
+
+	api.expect("PUT", "/channels/chan/commands/hello", `{"response": ""}`, http.StatusBadRequest, "must not be empty")
+	api.expect("PUT", "/channels/chan/commands/cc_set", `{"response": "hi"}`, http.StatusBadRequest, "invalid or reserved")
+
+	api.expect("DELETE", "/channels/chan/commands/hello", "", http.StatusOK, `"deleted":true`)
+	api.expect("DELETE", "/channels/chan/commands/hello", "", http.StatusNotFound, "no such command")
+	api.expect("GET", "/channels/nowhere/commands", "", http.StatusNotFound, "error")
+}
+
+func TestDictionary(t *testing.T) {
+	api := startServer(t)
+	defer api.stop()
+
+	api.expect("PUT", "/dictionary/Greeting", `{"value": "hello"}`, http.StatusOK, `"key":"greeting"`)
+	api.expect("PUT", "/channels/chan/dictionary/greeting", `{"value": "hi"}`, http.StatusOK, `"value":"hi"`)
+	api.expect("GET", "/dictionary", "", http.StatusOK, `"greeting":"hello"`)
+	api.expect("GET", "/channels/chan/dictionary", "", http.StatusOK, `"greeting":"hi"`)
+
+	api.expect("PUT", "/dictionary/greeting", `{"value": ""}`, http.StatusBadRequest, "must not be empty")
+	api.expect("PUT", "/channels/nowhere/dictionary/greeting", `{"value": "hi"}`, http.StatusNotFound, "error")
+
+	api.expect("DELETE", "/channels/chan/dictionary/greeting", "", http.StatusOK, `"deleted":true`)
+	api.expect("DELETE", "/channels/chan/dictionary/greeting", "", http.StatusNotFound, "no such entry")
+	api.expect("DELETE", "/dictionary/greeting", "", http.StatusOK, `"deleted":true`)
+	api.expect("DELETE", "/dictionary/greeting", "", http.StatusNotFound, "no such entry")
+}
+
+func TestBlacklist(t *testing.T) {
+	api := startServer(t)
+	api.expect("GET", "/blacklist", "", http.StatusConflict, "not loaded")
+	api.stop()
+
+	api = startServer(t, blacklist.NewPlugin())
+	defer api.stop()
+
+	api.expect("PUT", "/blacklist/Troll", "", http.StatusOK, `"changed":true`)
+	api.expect("PUT", "/blacklist/troll", "", http.StatusOK, `"changed":false`)
+	api.expect("GET", "/blacklist", "", http.StatusOK, `["troll"]`)
+
+	api.expect("PUT", "/blacklist/op", "", http.StatusConflict, "operator")
+	api.expect("PUT", "/blacklist/bot", "", http.StatusConflict, "operator")
+	api.expect("PUT", "/blacklist/no%20one", "", http.StatusBadRequest, "invalid")
+
+	api.expect("DELETE", "/blacklist/troll", "", http.StatusOK, `"deleted":true`)
+	api.expect("DELETE", "/blacklist/troll", "", http.StatusNotFound, "not blacklisted")
+}
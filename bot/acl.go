@@ -1,9 +1,12 @@
 package bot
 
 import (
-	"log"
+	"database/sql"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/twitch"
 )
 import "strings"
@@ -17,38 +20,93 @@ const (
 	ACL_TWITCH_ADMINS = "$admins"
 )
 
+// custom groups are defined by the broadcaster and always start with an @
+var customGroupRegex = regexp.MustCompile(`^@[a-z0-9_]{1,25}$`)
+
 type usernameList []string
 type permissionMap map[string]usernameList
+type groupMap map[string]usernameList
+
+// how often expired temporary grants are removed
+const aclSweepInterval = time.Minute
+
+type aclKey struct {
+	permission string
+	ident      string
+}
 
+// The ACL is mostly used from the channel's goroutine, but expired grants are
+// removed in the background, so all access is guarded by the mutex.
 type ACL struct {
 	channel     string
 	operator    string
 	broadcaster string
 	log         Logger
-	db          *sqlx.DB
+	db          Database
+	users       *UserDirectory
+	mutex       sync.RWMutex
 	permissions permissionMap
+	denials     permissionMap
+	expiries    map[aclKey]time.Time
+	groups      groupMap
+	userIDs     map[string]int // user idents that are bound to a Twitch user ID
 }
 
-func NewACL(channel string, operator string, log Logger, db *sqlx.DB) *ACL {
-	return &ACL{channel, strings.ToLower(operator), strings.ToLower(strings.TrimPrefix(operator, "#")), log, db, make(permissionMap)}
+func NewACL(channel string, operator string, log Logger, db Database, users *UserDirectory) *ACL {
+	return &ACL{
+		channel:     channel,
+		operator:    strings.ToLower(operator),
+		broadcaster: strings.ToLower(strings.TrimPrefix(operator, "#")),
+		log:         log,
+		db:          db,
+		users:       users,
+		permissions: make(permissionMap),
+		denials:     make(permissionMap),
+		expiries:    make(map[aclKey]time.Time),
+		groups:      make(groupMap),
+		userIDs:     make(map[string]int),
+	}
 }
 
 func ACLGroups() []string {
 	return []string{ACL_ALL, ACL_MODERATORS, ACL_SUBSCRIBERS, ACL_TURBO_USERS, ACL_TWITCH_STAFF, ACL_TWITCH_ADMINS}
 }
 
+func IsCustomGroup(name string) bool {
+	return customGroupRegex.MatchString(name)
+}
+
 func (self *ACL) AllowedUsers(permission string) usernameList {
-	userList, ok := self.permissions[permission]
-	if !ok {
-		userList = make(usernameList, 0)
-	}
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return copyIdents(self.permissions[permission])
+}
+
+func (self *ACL) DeniedUsers(permission string) usernameList {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
 
-	return userList
+	return copyIdents(self.denials[permission])
+}
+
+// Expiry returns when a temporary grant ends; ok is false for permanent grants.
+func (self *ACL) Expiry(userIdent string, permission string) (expires time.Time, ok bool) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	expires, ok = self.expiries[aclKey{permission, strings.ToLower(userIdent)}]
+
+	return
 }
 
 func (self *ACL) IsUsername(name string) bool {
 	name = strings.ToLower(name)
 
+	if strings.HasPrefix(name, "@") {
+		return false
+	}
+
 	for _, group := range ACLGroups() {
 		if group == name {
 			return false
@@ -58,40 +116,46 @@ func (self *ACL) IsUsername(name string) bool {
 	return true
 }
 
+// IsAllowed checks whether the user has been granted the permission. Entries for
+// the user themselves take precedence over group entries, and at each of both
+// levels, denials take precedence over grants. So "$subs, but not kevin" is
+// possible, as is "nobody in $mods but kevin". Entries for wildcard patterns like
+// "custom_commands.*" apply just like entries for the permission itself.
 func (self *ACL) IsAllowed(user twitch.User, permission string) bool {
-	name := strings.ToLower(user.Name)
+	name := user.Login
 
 	// the bot operator and channel owner are always allowed to use the available commands
 	if name == self.operator || name == self.broadcaster {
 		return true
 	}
 
-	allowed := self.AllowedUsers(permission)
-	if len(allowed) == 0 {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	denied := make(usernameList, 0)
+	allowed := make(usernameList, 0)
+
+	for _, pattern := range permissionPatterns(permission) {
+		denied = append(denied, self.denials[pattern]...)
+		allowed = append(allowed, self.permissions[pattern]...)
+	}
+
+	if self.containsUser(denied, user) {
 		return false
 	}
 
-	for _, ident := range allowed {
-		allowed := false
-
-		switch ident {
-		case ACL_ALL:
-			allowed = true
-		case ACL_MODERATORS:
-			allowed = (user.Type == twitch.Moderator) || (user.Type == twitch.GlobalModerator)
-		case ACL_SUBSCRIBERS:
-			allowed = user.Subscriber
-		case ACL_TURBO_USERS:
-			allowed = user.Turbo
-		case ACL_TWITCH_STAFF:
-			allowed = user.Type == twitch.TwitchStaff
-		case ACL_TWITCH_ADMINS:
-			allowed = user.Type == twitch.TwitchAdmin
-		default:
-			allowed = name == ident
+	if self.containsUser(allowed, user) {
+		return true
+	}
+
+	for _, ident := range denied {
+		if self.isInGroup(user, ident) {
+			return false
 		}
+	}
 
-		if allowed {
+	for _, ident := range allowed {
+		if self.isInGroup(user, ident) {
 			return true
 		}
 	}
@@ -99,58 +163,219 @@ func (self *ACL) IsAllowed(user twitch.User, permission string) bool {
 	return false
 }
 
-func (self *ACL) Allow(userIdent string, permission string) bool {
+func (self *ACL) isInGroup(user twitch.User, ident string) bool {
+	switch ident {
+	case ACL_ALL:
+		return true
+	case ACL_MODERATORS:
+		return (user.Type == twitch.Moderator) || (user.Type == twitch.GlobalModerator)
+	case ACL_SUBSCRIBERS:
+		return user.Subscriber
+	case ACL_TURBO_USERS:
+		return user.Turbo
+	case ACL_TWITCH_STAFF:
+		return user.Type == twitch.TwitchStaff
+	case ACL_TWITCH_ADMINS:
+		return user.Type == twitch.TwitchAdmin
+	}
+
+	return IsCustomGroup(ident) && self.containsUser(self.groups[ident], user)
+}
+
+// Allow grants the permission permanently, replacing a denial or a temporary grant
+// for the same user ident.
+func (self *ACL) Allow(userIdent string, permission string) (bool, error) {
+	return self.AllowUntil(userIdent, permission, time.Time{})
+}
+
+// AllowUntil grants the permission until the given time; a zero time grants it
+// permanently. Expired grants are removed by Sweep.
+func (self *ACL) AllowUntil(userIdent string, permission string, until time.Time) (bool, error) {
 	userIdent = strings.ToLower(userIdent)
 
 	// allowing something for the owner is pointless
 	if self.broadcaster == userIdent {
-		return false
+		return false, nil
 	}
 
-	// create skeleton structure for permissions
-	_, ok := self.permissions[permission]
-	if !ok {
-		self.permissions[permission] = make(usernameList, 0)
-	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 
-	// check if the permission is already set
-	exists := false
+	key := aclKey{permission, userIdent}
+	expires, temporary := self.expiries[key]
 
-	for _, ident := range self.permissions[permission] {
-		if ident == userIdent {
-			exists = true
-			break
-		}
+	if containsIdent(self.permissions[permission], userIdent) && expires.Equal(until) && temporary == !until.IsZero() {
+		return false, nil
 	}
 
-	if exists {
-		return false
+	self.bindIdent(userIdent)
+
+	err := self.storeEntry(userIdent, permission, false, until)
+	if err != nil {
+		return false, self.failed(err, "Could not allow %s for %s in %s", permission, userIdent, self.channel)
 	}
 
-	self.permissions[permission] = append(self.permissions[permission], userIdent)
+	removeIdent(self.denials, permission, userIdent)
 
-	_, err := self.db.Exec("INSERT INTO acl (channel, permission, user_ident) VALUES (?,?,?)", self.channel, permission, userIdent)
-	if err != nil {
-		log.Fatal("Could not add ACL entry to the database: " + err.Error())
+	if !containsIdent(self.permissions[permission], userIdent) {
+		self.permissions[permission] = append(self.permissions[permission], userIdent)
+	}
+
+	if until.IsZero() {
+		delete(self.expiries, key)
+	} else {
+		self.expiries[key] = until
 	}
 
 	self.log.Debug("Allowed %s for %s in %s.", permission, userIdent, self.channel)
 
-	return true
+	return true, nil
 }
 
-func (self *ACL) Deny(userIdent string, permission string) bool {
+// Deny explicitly denies the permission, replacing a grant for the same user ident.
+func (self *ACL) Deny(userIdent string, permission string) (bool, error) {
 	userIdent = strings.ToLower(userIdent)
 
-	userList, ok := self.permissions[permission]
-	if !ok {
-		return false
+	// the owner can always do everything
+	if self.broadcaster == userIdent {
+		return false, nil
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if containsIdent(self.denials[permission], userIdent) {
+		return false, nil
+	}
+
+	self.bindIdent(userIdent)
+
+	err := self.storeEntry(userIdent, permission, true, time.Time{})
+	if err != nil {
+		return false, self.failed(err, "Could not deny %s for %s in %s", permission, userIdent, self.channel)
+	}
+
+	removeIdent(self.permissions, permission, userIdent)
+	delete(self.expiries, aclKey{permission, userIdent})
+
+	self.denials[permission] = append(self.denials[permission], userIdent)
+
+	self.log.Debug("Denied %s for %s in %s.", permission, userIdent, self.channel)
+
+	return true, nil
+}
+
+// Revoke removes any grant or denial of the permission for the user ident.
+func (self *ACL) Revoke(userIdent string, permission string) (bool, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.revoke(strings.ToLower(userIdent), permission)
+}
+
+func (self *ACL) revoke(userIdent string, permission string) (bool, error) {
+	if !containsIdent(self.permissions[permission], userIdent) && !containsIdent(self.denials[permission], userIdent) {
+		return false, nil
+	}
+
+	_, err := self.db.Exec("DELETE FROM acl WHERE channel = ? AND permission = ? AND user_ident = ?", self.channel, permission, userIdent)
+	if err != nil {
+		return false, self.failed(err, "Could not revoke %s for %s in %s", permission, userIdent, self.channel)
+	}
+
+	removeIdent(self.permissions, permission, userIdent)
+	removeIdent(self.denials, permission, userIdent)
+	delete(self.expiries, aclKey{permission, userIdent})
+
+	self.log.Debug("Revoked %s for %s in %s.", permission, userIdent, self.channel)
+
+	return true, nil
+}
+
+// ExpiredGrant describes a temporary grant that has been removed by Sweep.
+type ExpiredGrant struct {
+	Permission string
+	UserIdent  string
+}
+
+// Sweep removes all temporary grants that have expired by now.
+func (self *ACL) Sweep(now time.Time) []ExpiredGrant {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	expired := make([]ExpiredGrant, 0)
+
+	for key, expires := range self.expiries {
+		if expires.After(now) {
+			continue
+		}
+
+		// if this fails, the next sweep will try again
+		revoked, _ := self.revoke(key.ident, key.permission)
+		if revoked {
+			expired = append(expired, ExpiredGrant{key.permission, key.ident})
+		}
+	}
+
+	return expired
+}
+
+func (self *ACL) storeEntry(userIdent string, permission string, negated bool, expires time.Time) error {
+	expiresAt := expiryColumn(expires)
+
+	return self.db.Transaction(func(tx Queryer) error {
+		_, err := tx.Exec("DELETE FROM acl WHERE channel = ? AND permission = ? AND user_ident = ?", self.channel, permission, userIdent)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO acl (channel, permission, user_ident, user_id, negated, expires_at) VALUES (?,?,?,?,?,?)", self.channel, permission, userIdent, self.userID(userIdent), negated, expiresAt)
+
+		return err
+	})
+}
+
+// expiryColumn turns the expiry of a grant into a column value; permanent entries
+// have none.
+func expiryColumn(expires time.Time) sql.NullInt64 {
+	if expires.IsZero() {
+		return sql.NullInt64{}
 	}
 
+	return sql.NullInt64{Int64: expires.Unix(), Valid: true}
+}
+
+// failed logs an error that happened while changing the ACL and returns it.
+func (self *ACL) failed(err error, format string, args ...interface{}) error {
+	self.log.Error(format+": %s", append(args, err.Error())...)
+	return err
+}
+
+func containsIdent(list usernameList, ident string) bool {
+	for _, i := range list {
+		if i == ident {
+			return true
+		}
+	}
+
+	return false
+}
+
+func copyIdents(list usernameList) usernameList {
+	result := make(usernameList, len(list))
+	copy(result, list)
+
+	return result
+}
+
+// removeIdent removes the ident from the permission's list, removing the list
+// alltogether if this was the last ident
+func removeIdent(perms map[string]usernameList, permission string, ident string) bool {
+	list := perms[permission]
 	idx := -1
 
-	for i, ident := range userList {
-		if ident == userIdent {
+	for i, item := range list {
+		if item == ident {
 			idx = i
 			break
 		}
@@ -160,78 +385,231 @@ func (self *ACL) Deny(userIdent string, permission string) bool {
 		return false
 	}
 
-	// remove element or kill list alltogether if this was the last user
-	if len(userList) > 1 {
-		self.permissions[permission] = append(userList[:idx], userList[(idx+1):]...)
+	if len(list) > 1 {
+		perms[permission] = append(list[:idx], list[(idx+1):]...)
 	} else {
-		delete(self.permissions, permission)
+		delete(perms, permission)
 	}
 
-	_, err := self.db.Exec("DELETE FROM acl WHERE channel = ? AND permission = ? AND user_ident = ?", self.channel, permission, userIdent)
+	return true
+}
+
+func (self *ACL) DeletePermission(permission string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	_, allowed := self.permissions[permission]
+	_, denied := self.denials[permission]
+
+	if !allowed && !denied {
+		return nil
+	}
+
+	_, err := self.db.Exec("DELETE FROM acl WHERE channel = ? AND permission = ?", self.channel, permission)
 	if err != nil {
-		log.Fatal("Could not delete ACL entry from the database: " + err.Error())
+		return self.failed(err, "Could not remove all %s permissions in %s", permission, self.channel)
 	}
 
-	self.log.Debug("Denied %s for %s in %s.", permission, userIdent, self.channel)
+	delete(self.permissions, permission)
+	delete(self.denials, permission)
 
-	return true
+	for key := range self.expiries {
+		if key.permission == permission {
+			delete(self.expiries, key)
+		}
+	}
+
+	self.log.Debug("Removed all %s permissions for %s.", permission, self.channel)
+
+	return nil
 }
 
-func (self *ACL) DeletePermission(permission string) {
-	_, ok := self.permissions[permission]
-	if !ok {
-		return
+// Groups returns the names of all custom groups in this channel.
+func (self *ACL) Groups() []string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	result := make([]string, 0, len(self.groups))
+
+	for group := range self.groups {
+		result = append(result, group)
 	}
 
-	delete(self.permissions, permission)
+	sort.Strings(result)
 
-	_, err := self.db.Exec("DELETE FROM acl WHERE channel = ? AND permission = ?", self.channel, permission)
+	return result
+}
+
+func (self *ACL) GroupMembers(group string) usernameList {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return copyIdents(self.groups[strings.ToLower(group)])
+}
+
+func (self *ACL) IsGroupMember(group string, username string) bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return containsIdent(self.groups[strings.ToLower(group)], strings.ToLower(username))
+}
+
+// AddToGroup adds a user to a custom group, creating the group if needed.
+func (self *ACL) AddToGroup(group string, username string) (bool, error) {
+	group = strings.ToLower(group)
+	username = strings.ToLower(username)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if !IsCustomGroup(group) || containsIdent(self.groups[group], username) {
+		return false, nil
+	}
+
+	self.bindIdent(username)
+
+	_, err := self.db.Exec("INSERT INTO acl_group (channel, groupname, username, user_id) VALUES (?,?,?,?)", self.channel, group, username, self.userID(username))
 	if err != nil {
-		log.Fatal("Could not delete ACL entries from the database: " + err.Error())
+		return false, self.failed(err, "Could not add %s to %s in %s", username, group, self.channel)
 	}
 
-	self.log.Debug("Removed all %s permissions for %s.", permission, self.channel)
+	self.groups[group] = append(self.groups[group], username)
+
+	self.log.Debug("Added %s to %s in %s.", username, group, self.channel)
+
+	return true, nil
 }
 
-func (self *ACL) loadData() {
-	rows, err := self.db.Query("SELECT permission, user_ident FROM acl WHERE channel = ? ORDER BY permission", self.channel)
+// RemoveFromGroup removes a user from a custom group; the group ceases to exist
+// when its last member is removed.
+func (self *ACL) RemoveFromGroup(group string, username string) (bool, error) {
+	group = strings.ToLower(group)
+	username = strings.ToLower(username)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if !containsIdent(self.groups[group], username) {
+		return false, nil
+	}
+
+	_, err := self.db.Exec("DELETE FROM acl_group WHERE channel = ? AND groupname = ? AND username = ?", self.channel, group, username)
+	if err != nil {
+		return false, self.failed(err, "Could not remove %s from %s in %s", username, group, self.channel)
+	}
+
+	removeIdent(self.groups, group, username)
+
+	self.log.Debug("Removed %s from %s in %s.", username, group, self.channel)
+
+	return true, nil
+}
+
+// DeleteGroup removes a custom group and all permissions granted to it.
+func (self *ACL) DeleteGroup(group string) (bool, error) {
+	group = strings.ToLower(group)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	_, ok := self.groups[group]
+	if !ok {
+		return false, nil
+	}
+
+	err := self.db.Transaction(func(tx Queryer) error {
+		_, err := tx.Exec("DELETE FROM acl_group WHERE channel = ? AND groupname = ?", self.channel, group)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM acl WHERE channel = ? AND user_ident = ?", self.channel, group)
+
+		return err
+	})
 	if err != nil {
-		self.log.Fatal("Could not query ACL data: %s", err.Error())
+		return false, self.failed(err, "Could not delete group %s in %s", group, self.channel)
 	}
-	defer rows.Close()
 
-	lastPerm := ""
-	rowCount := 0
+	delete(self.groups, group)
 
-	var newUserList usernameList
+	for _, perms := range []permissionMap{self.permissions, self.denials} {
+		for permission := range perms {
+			removeIdent(perms, permission, group)
+			delete(self.expiries, aclKey{permission, group})
+		}
+	}
+
+	self.log.Debug("Deleted group %s in %s.", group, self.channel)
+
+	return true, nil
+}
+
+type aclRow struct {
+	Permission string
+	UserIdent  string        `db:"user_ident"`
+	UserID     sql.NullInt64 `db:"user_id"`
+	Negated    bool
+	ExpiresAt  sql.NullInt64 `db:"expires_at"`
+}
+
+func (self *ACL) loadData() {
+	list := make([]aclRow, 0)
+
+	err := self.db.Select(&list, "SELECT permission, user_ident, user_id, negated, expires_at FROM acl WHERE channel = ? ORDER BY permission", self.channel)
+	if err != nil {
+		self.log.Error("Could not query ACL data for %s: %s", self.channel, err.Error())
+		return
+	}
 
-	for rows.Next() {
-		var permission, userIdent string
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 
-		if err := rows.Scan(&permission, &userIdent); err != nil {
-			log.Fatal(err)
+	for _, row := range list {
+		target := self.permissions
+		if row.Negated {
+			target = self.denials
 		}
 
-		if permission != lastPerm {
-			if lastPerm != "" {
-				self.permissions[lastPerm] = newUserList
-			}
+		target[row.Permission] = append(target[row.Permission], row.UserIdent)
 
-			newUserList = make(usernameList, 0)
-			lastPerm = permission
+		if row.UserID.Valid {
+			self.userIDs[row.UserIdent] = int(row.UserID.Int64)
 		}
 
-		newUserList = append(newUserList, userIdent)
-		rowCount = rowCount + 1
+		if row.ExpiresAt.Valid && !row.Negated {
+			self.expiries[aclKey{row.Permission, row.UserIdent}] = time.Unix(row.ExpiresAt.Int64, 0)
+		}
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+	self.log.Debug("Loaded %d ACL entries for %s.", len(list), self.channel)
+
+	self.loadGroups()
+	self.bindKnownUsers()
+}
+
+type aclGroupRow struct {
+	Groupname string
+	Username  string
+	UserID    sql.NullInt64 `db:"user_id"`
+}
+
+func (self *ACL) loadGroups() {
+	list := make([]aclGroupRow, 0)
+
+	err := self.db.Select(&list, "SELECT groupname, username, user_id FROM acl_group WHERE channel = ? ORDER BY groupname, username", self.channel)
+	if err != nil {
+		self.log.Error("Could not query ACL groups for %s: %s", self.channel, err.Error())
+		return
 	}
 
-	if lastPerm != "" {
-		self.permissions[lastPerm] = newUserList
+	for _, row := range list {
+		self.groups[row.Groupname] = append(self.groups[row.Groupname], row.Username)
+
+		if row.UserID.Valid {
+			self.userIDs[row.Username] = int(row.UserID.Int64)
+		}
 	}
 
-	self.log.Debug("Loaded %d ACL entries for %s.", rowCount, self.channel)
+	self.log.Debug("Loaded %d ACL groups for %s.", len(self.groups), self.channel)
 }
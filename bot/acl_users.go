@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// User entries in the ACL are stored by name, but bound to the user's ID as soon as
+// it is known. A bound entry only matches the user with that ID, even if somebody
+// else takes over the name, and follows the user when they rename.
+
+func (self *ACL) matchesUser(ident string, user twitch.User) bool {
+	if id, bound := self.userIDs[ident]; bound && user.ID > 0 {
+		return id == user.ID
+	}
+
+	return ident == user.Login
+}
+
+func (self *ACL) containsUser(list usernameList, user twitch.User) bool {
+	for _, ident := range list {
+		if self.matchesUser(ident, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bindIdent binds a username to the user's ID, if the user has been seen before.
+func (self *ACL) bindIdent(ident string) {
+	if _, bound := self.userIDs[ident]; bound || !self.IsUsername(ident) {
+		return
+	}
+
+	if id, known := self.users.IDByName(ident); known {
+		self.userIDs[ident] = id
+	}
+}
+
+func (self *ACL) userID(ident string) sql.NullInt64 {
+	id, bound := self.userIDs[ident]
+
+	return sql.NullInt64{Int64: int64(id), Valid: bound}
+}
+
+// bindKnownUsers migrates entries that have been created by name only (or before
+// IDs were tracked at all) for users that are already known.
+func (self *ACL) bindKnownUsers() {
+	for _, ident := range self.userIdents() {
+		if _, bound := self.userIDs[ident]; bound {
+			continue
+		}
+
+		if id, known := self.users.IDByName(ident); known {
+			self.bindUser(id, ident)
+		}
+	}
+}
+
+// userIdents returns all usernames that appear anywhere in the ACL.
+func (self *ACL) userIdents() []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+
+	for _, lists := range []map[string]usernameList{self.permissions, self.denials, self.groups} {
+		for _, list := range lists {
+			for _, ident := range list {
+				if !seen[ident] && self.IsUsername(ident) {
+					seen[ident] = true
+					result = append(result, ident)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// BindUser is called whenever the user directory learns a user's current name. It
+// binds entries created for that name and moves entries of the user's old name over
+// to the new one.
+func (self *ACL) BindUser(id int, login string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.bindUser(id, login)
+}
+
+func (self *ACL) bindUser(id int, login string) {
+	// entries for the new name that are bound to somebody else are left alone;
+	// they belong to whoever had the name before
+	if other, bound := self.userIDs[login]; bound && other != id {
+		return
+	}
+
+	if _, bound := self.userIDs[login]; !bound && containsIdent(self.userIdents(), login) {
+		err := self.db.Transaction(func(tx Queryer) error {
+			_, err := tx.Exec("UPDATE acl SET user_id = ? WHERE channel = ? AND user_ident = ?", id, self.channel, login)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec("UPDATE acl_group SET user_id = ? WHERE channel = ? AND username = ?", id, self.channel, login)
+
+			return err
+		})
+
+		// the entries stay unbound and are bound again when the user is seen next time
+		if err != nil {
+			self.failed(err, "Could not bind ACL entries for %s in %s to user %d", login, self.channel, id)
+			return
+		}
+
+		self.userIDs[login] = id
+
+		self.log.Debug("Bound ACL entries for %s in %s to user %d.", login, self.channel, id)
+	}
+
+	for ident, bound := range self.userIDs {
+		if bound == id && ident != login {
+			self.renameIdent(ident, login, id)
+		}
+	}
+}
+
+// an aclMove is a grant or denial that moves to a user's new name; it is dropped
+// instead if the new name already has its own entry for the permission
+type aclMove struct {
+	perms      permissionMap
+	permission string
+	negated    bool
+	expires    time.Time
+	keep       bool
+}
+
+// renameIdent moves all entries from one username to another; if the new name
+// already has its own entry for a permission or group, that one wins. Nothing is
+// changed in memory unless all entries could be moved in the database.
+func (self *ACL) renameIdent(from string, to string, id int) {
+	moves := make([]aclMove, 0)
+	taken := make(map[string]bool)
+
+	for idx, perms := range []permissionMap{self.permissions, self.denials} {
+		for permission, list := range perms {
+			if !containsIdent(list, from) {
+				continue
+			}
+
+			moves = append(moves, aclMove{
+				perms:      perms,
+				permission: permission,
+				negated:    idx == 1,
+				expires:    self.expiries[aclKey{permission, from}],
+				keep:       !taken[permission] && !containsIdent(self.permissions[permission], to) && !containsIdent(self.denials[permission], to),
+			})
+
+			taken[permission] = true
+		}
+	}
+
+	groups := make([]string, 0)
+
+	for group, members := range self.groups {
+		if containsIdent(members, from) {
+			groups = append(groups, group)
+		}
+	}
+
+	err := self.db.Transaction(func(tx Queryer) error {
+		for _, move := range moves {
+			_, err := tx.Exec("DELETE FROM acl WHERE channel = ? AND permission = ? AND user_ident = ?", self.channel, move.permission, from)
+			if err != nil {
+				return err
+			}
+
+			if move.keep {
+				_, err = tx.Exec("INSERT INTO acl (channel, permission, user_ident, user_id, negated, expires_at) VALUES (?,?,?,?,?,?)", self.channel, move.permission, to, id, move.negated, expiryColumn(move.expires))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, group := range groups {
+			_, err := tx.Exec("DELETE FROM acl_group WHERE channel = ? AND groupname = ? AND username = ?", self.channel, group, from)
+			if err != nil {
+				return err
+			}
+
+			if !containsIdent(self.groups[group], to) {
+				_, err = tx.Exec("INSERT INTO acl_group (channel, groupname, username, user_id) VALUES (?,?,?,?)", self.channel, group, to, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	// the old name stays bound, so the entries are moved again with the next rename
+	if err != nil {
+		self.failed(err, "Could not move ACL entries in %s from %s to %s", self.channel, from, to)
+		return
+	}
+
+	delete(self.userIDs, from)
+	self.userIDs[to] = id
+
+	for _, move := range moves {
+		removeIdent(move.perms, move.permission, from)
+		delete(self.expiries, aclKey{move.permission, from})
+
+		if !move.keep {
+			continue
+		}
+
+		move.perms[move.permission] = append(move.perms[move.permission], to)
+
+		if !move.expires.IsZero() {
+			self.expiries[aclKey{move.permission, to}] = move.expires
+		}
+	}
+
+	for _, group := range groups {
+		removeIdent(self.groups, group, from)
+
+		if !containsIdent(self.groups[group], to) {
+			self.groups[group] = append(self.groups[group], to)
+		}
+	}
+
+	self.log.Info("Moved ACL entries in %s from %s to %s.", self.channel, from, to)
+}
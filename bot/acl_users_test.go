@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+func TestACLOnlyRenamesStoredEntries(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	users := NewUserDirectory(db, testLogger(), NewEventBus())
+	users.Start()
+	defer users.Stop()
+
+	<-users.Observe(twitch.User{Login: "bob", ID: 42})
+
+	acl := NewACL("#chan", "op", testLogger(), db, users)
+	acl.loadData()
+
+	acl.Allow("bob", "test.use")
+	acl.AllowUntil("bob", "test.temporary", time.Now().Add(time.Hour))
+
+	// without the table, nothing can be moved and everything has to stay as it was
+	exec(t, db, "ALTER TABLE acl RENAME TO acl_gone")
+	acl.BindUser(42, "robert")
+	exec(t, db, "ALTER TABLE acl_gone RENAME TO acl")
+
+	if allowed := acl.AllowedUsers("test.use"); len(allowed) != 1 || allowed[0] != "bob" {
+		t.Fatalf("Expected bob to keep the permission after a failed rename, got %v.", allowed)
+	}
+
+	acl.BindUser(42, "robert")
+
+	// the entries must have been stored under the new name
+	reloaded := NewACL("#chan", "op", testLogger(), db, users)
+	reloaded.loadData()
+
+	for _, check := range []*ACL{acl, reloaded} {
+		for _, permission := range []string{"test.use", "test.temporary"} {
+			if allowed := check.AllowedUsers(permission); len(allowed) != 1 || allowed[0] != "robert" {
+				t.Errorf("Expected %s to be moved to robert, got %v.", permission, allowed)
+			}
+		}
+
+		if _, temporary := check.Expiry("robert", "test.temporary"); !temporary {
+			t.Error("Expected the temporary grant to keep its expiry.")
+		}
+
+		if !check.IsAllowed(twitch.User{Login: "robert", ID: 42}, "test.use") {
+			t.Error("Expected robert to be allowed.")
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package bot
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// capabilities that can be delegated to bot admins
+const (
+	AdminAll        = "*"
+	AdminBlacklist  = "blacklist"
+	AdminContent    = "content"
+	AdminDictionary = "dictionary"
+	AdminEcho       = "echo"
+	AdminJoin       = "join"
+)
+
+func AdminCapabilities() []string {
+	return []string{AdminBlacklist, AdminContent, AdminDictionary, AdminEcho, AdminJoin}
+}
+
+func IsAdminCapability(capability string) bool {
+	if capability == AdminAll {
+		return true
+	}
+
+	for _, c := range AdminCapabilities() {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+type adminEntry struct {
+	Username   string
+	UserID     int
+	Capability string
+}
+
+// Admins are users that the operator has delegated some of their global powers to,
+// like editing the dictionary. An admin with the "*" capability can do everything
+// the operator can, except for managing the admins. Like the blacklist, admins are
+// bound to their user ID as soon as it is known.
+type Admins struct {
+	db      Database
+	log     Logger
+	users   *UserDirectory
+	mutex   sync.RWMutex
+	entries []adminEntry
+}
+
+type adminRow struct {
+	Username   string
+	UserID     sql.NullInt64 `db:"user_id"`
+	Capability string
+}
+
+func NewAdmins(db Database, log Logger, users *UserDirectory) *Admins {
+	return &Admins{
+		db:      db,
+		log:     log,
+		users:   users,
+		entries: make([]adminEntry, 0),
+	}
+}
+
+func (self *Admins) load() error {
+	list := make([]adminRow, 0)
+
+	err := self.db.Select(&list, "SELECT username, user_id, capability FROM bot_admin ORDER BY username, capability")
+	if err != nil {
+		return err
+	}
+
+	entries := make([]adminEntry, 0, len(list))
+
+	for _, row := range list {
+		entry := adminEntry{row.Username, int(row.UserID.Int64), row.Capability}
+
+		// migrate entries from before user IDs were tracked
+		if !row.UserID.Valid {
+			if id, known := self.users.IDByName(row.Username); known {
+				entry.UserID = id
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = self.db.Transaction(func(tx Queryer) error {
+		for idx, row := range list {
+			if row.UserID.Valid || entries[idx].UserID == 0 {
+				continue
+			}
+
+			_, err := tx.Exec("UPDATE bot_admin SET user_id = ? WHERE username = ? AND capability = ?", entries[idx].UserID, row.Username, row.Capability)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	// the admins still work by name and are bound again after the next restart
+	if err != nil {
+		self.log.Error("Could not bind admins to their user IDs: %s", err.Error())
+
+		for idx, row := range list {
+			entries[idx].UserID = int(row.UserID.Int64)
+		}
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.entries = entries
+
+	self.log.Debug("Loaded %d admin capabilities.", len(list))
+
+	return nil
+}
+
+// Has checks whether the user has been granted the capability (or all of them).
+func (self *Admins) Has(user twitch.User, capability string) bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	for _, e := range self.entries {
+		if e.Capability != capability && e.Capability != AdminAll {
+			continue
+		}
+
+		if e.UserID > 0 && user.ID > 0 {
+			if e.UserID == user.ID {
+				return true
+			}
+		} else if e.Username == user.Login {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *Admins) Grant(username string, capability string) (bool, error) {
+	username = strings.ToLower(username)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, e := range self.entries {
+		if e.Username == username && e.Capability == capability {
+			return false, nil
+		}
+	}
+
+	entry := adminEntry{Username: username, Capability: capability}
+	userID := sql.NullInt64{}
+
+	if id, known := self.users.IDByName(username); known {
+		entry.UserID = id
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	_, err := self.db.Exec("INSERT INTO bot_admin (username, user_id, capability) VALUES (?, ?, ?)", username, userID, capability)
+	if err != nil {
+		self.log.Error("Could not grant %s to %s: %s", capability, username, err.Error())
+		return false, err
+	}
+
+	self.entries = append(self.entries, entry)
+	self.log.Info("Granted %s to %s.", capability, username)
+
+	return true, nil
+}
+
+// Revoke takes a capability away from the user; an empty capability removes all of
+// them.
+func (self *Admins) Revoke(username string, capability string) (bool, error) {
+	username = strings.ToLower(username)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	kept := make([]adminEntry, 0, len(self.entries))
+
+	for _, e := range self.entries {
+		if e.Username != username || (capability != "" && e.Capability != capability) {
+			kept = append(kept, e)
+		}
+	}
+
+	if len(kept) == len(self.entries) {
+		return false, nil
+	}
+
+	var err error
+
+	if capability == "" {
+		_, err = self.db.Exec("DELETE FROM bot_admin WHERE username = ?", username)
+	} else {
+		_, err = self.db.Exec("DELETE FROM bot_admin WHERE username = ? AND capability = ?", username, capability)
+	}
+
+	if err != nil {
+		self.log.Error("Could not revoke admin capabilities from %s: %s", username, err.Error())
+		return false, err
+	}
+
+	self.entries = kept
+	self.log.Info("Revoked admin capabilities from %s.", username)
+
+	return true, nil
+}
+
+// List returns the capabilities of all admins, by username.
+func (self *Admins) List() map[string][]string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	result := make(map[string][]string)
+
+	for _, e := range self.entries {
+		result[e.Username] = append(result[e.Username], e.Capability)
+	}
+
+	for _, caps := range result {
+		sort.Strings(caps)
+	}
+
+	return result
+}
+
+// onUserIdentified binds entries to the user's ID and follows renames. If the new
+// name already has an entry for a capability, the renamed user's entry is merged
+// into it, unless it belongs to somebody else; then the old name is kept, as the
+// entry matches by ID anyway.
+func (self *Admins) onUserIdentified(event Event) {
+	identified := event.(UserIdentifiedEvent)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	owners := make(map[string]int) // owners of the new name's entries, by capability
+	pending := false
+
+	for _, e := range self.entries {
+		switch {
+		case e.Username == identified.Login:
+			owners[e.Capability] = e.UserID
+			pending = pending || e.UserID == 0
+
+		case e.UserID == identified.ID:
+			pending = true
+		}
+	}
+
+	if !pending {
+		return
+	}
+
+	updated := make([]adminEntry, 0, len(self.entries))
+
+	err := self.db.Transaction(func(tx Queryer) error {
+		updated = updated[:0]
+
+		for _, e := range self.entries {
+			var err error
+
+			switch owner, taken := owners[e.Capability]; {
+			case e.UserID == 0 && e.Username == identified.Login:
+				_, err = tx.Exec("UPDATE bot_admin SET user_id = ? WHERE username = ? AND capability = ?", identified.ID, e.Username, e.Capability)
+				e.UserID = identified.ID
+
+			case e.UserID != identified.ID || e.Username == identified.Login:
+				// not affected
+
+			case !taken:
+				_, err = tx.Exec("UPDATE bot_admin SET username = ? WHERE username = ? AND capability = ?", identified.Login, e.Username, e.Capability)
+				e.Username = identified.Login
+
+			case owner == 0 || owner == identified.ID:
+				_, err = tx.Exec("DELETE FROM bot_admin WHERE username = ? AND capability = ?", e.Username, e.Capability)
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			updated = append(updated, e)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		self.log.Error("Could not update the admin entries of %s (%d): %s", identified.Login, identified.ID, err.Error())
+		return
+	}
+
+	self.entries = updated
+}
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A BackgroundTask calls a function periodically in its own goroutine until it is
+// stopped or its context is done, and then calls the flush function one last time.
+// Plugin workers use this to e.g. regularly write their state to the database
+// without having to manage goroutines and stop signals themselves.
+type BackgroundTask struct {
+	interval time.Duration
+	tick     func()
+	flush    func()
+	mutex    sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewBackgroundTask creates a stopped task; tick and flush may be nil.
+func NewBackgroundTask(interval time.Duration, tick func(), flush func()) *BackgroundTask {
+	return &BackgroundTask{
+		interval: interval,
+		tick:     tick,
+		flush:    flush,
+	}
+}
+
+// Start launches the task, usually with the channel's context. A task that is
+// already running is stopped (and flushed) first.
+func (self *BackgroundTask) Start(ctx context.Context) {
+	self.Stop()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	ctx, self.cancel = context.WithCancel(ctx)
+	self.done = make(chan struct{})
+
+	go self.run(ctx, self.done)
+}
+
+// Stop ends the task and waits for the final flush. It is safe to call Stop on a
+// task that is not running.
+func (self *BackgroundTask) Stop() {
+	self.mutex.Lock()
+	cancel, done := self.cancel, self.done
+	self.cancel, self.done = nil, nil
+	self.mutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+func (self *BackgroundTask) Running() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.cancel != nil
+}
+
+func (self *BackgroundTask) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(self.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if self.tick != nil {
+				self.tick()
+			}
+
+		case <-ctx.Done():
+			if self.flush != nil {
+				self.flush()
+			}
+
+			return
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package bot
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/twitch"
 )
 
@@ -11,26 +15,44 @@ import (
 type Channel interface {
 	Name() string
 	Alive() <-chan struct{}
+	Context() context.Context
 	Plugins() []Plugin
 	Workers() []PluginWorker
 	WorkerByName(string) (PluginWorker, error)
 	ACL() *ACL
-	EnablePlugin(string) bool
-	DisablePlugin(string) bool
+	Dictionary() *ChannelDictionary
+	Settings() *ChannelSettings
+	EnablePlugin(string) (bool, error)
+	DisablePlugin(string) (bool, error)
 	Sender() Sender
+	Events() *EventBus
+	Tasks() *TaskPool
+	Stats() ChannelStats
+	Run(func()) error
 }
 
 type channelWorker struct {
 	channel        string
-	inputChannel   chan twitch.IncomingMessage // should be buffered to sth. like 100msg
-	leaveSignal    chan struct{}               // to be sent (= closed) when we LEAVE the channel on purpose
-	shutdownSignal chan struct{}               // to be sent when we just shutdown the bot
-	alive          chan struct{}               // is sent by the worker when the goroutine is ending
-	database       *sqlx.DB
+	inbox          *inbox
+	leaveSignal    chan struct{} // to be sent (= closed) when we LEAVE the channel on purpose
+	shutdownSignal chan struct{} // to be sent when we just shutdown the bot
+	alive          chan struct{} // is sent by the worker when the goroutine is ending
+	calls          chan func()   // functions to run in the worker goroutine
+	metrics        *Metrics
+	database       Database
 	log            Logger
 	acl            *ACL
+	dictionary     *ChannelDictionary
+	settings       *ChannelSettings
 	workers        []pluginWorkerStruct
 	sender         *channelSender
+	events         *EventBus
+	botEvents      *EventBus
+	tasks          *TaskPool
+	aclSweeper     *BackgroundTask
+	userWatch      *Subscription
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
 type pluginRow struct {
@@ -39,23 +61,44 @@ type pluginRow struct {
 
 func newChannelWorker(channel string, bot *Kabukibot) *channelWorker {
 	workers := make([]pluginWorkerStruct, 0)
+	ctx, cancel := context.WithCancel(bot.ctx)
+	log := bot.Logger().Subsystem("channel").With(Fields{"channel": channel})
 
 	cw := &channelWorker{
 		channel:        channel,
-		inputChannel:   make(chan twitch.IncomingMessage, 10),
+		inbox:          newInbox(),
 		leaveSignal:    make(chan struct{}),
 		shutdownSignal: make(chan struct{}),
 		alive:          make(chan struct{}),
+		calls:          make(chan func()),
+		metrics:        bot.metrics,
 		database:       bot.Database(),
-		log:            bot.Logger(),
-		acl:            NewACL(channel, bot.OpUsername(), bot.Logger(), bot.Database()),
+		log:            log,
+		acl:            NewACL(channel, bot.OpUsername(), log.Subsystem("acl"), bot.Database(), bot.Users()),
+		dictionary:     bot.Dictionary().Channel(channel),
+		settings:       NewChannelSettings(channel, bot.settings, bot.Database(), log),
 		workers:        nil,
-		sender:         newChannelSender(bot.twitch, channel),
+		sender:         newChannelSender(bot.twitch, channel, bot.metrics),
+		events:         NewEventBus(),
+		botEvents:      bot.Events(),
+		tasks:          newTaskPool(ctx, channel, log),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 
 	// find out what plugins have been enabled for the channel
 	list := make([]pluginRow, 0)
-	bot.Database().Select(&list, "SELECT plugin FROM plugin WHERE channel = ?", channel)
+
+	err := bot.Database().Select(&list, "SELECT plugin FROM plugin WHERE channel = ?", channel)
+	if err != nil {
+		log.Error("Could not query the enabled plugins for %s: %s", channel, err.Error())
+	}
+
+	// the workers might need their settings right away
+	err = cw.settings.load()
+	if err != nil {
+		log.Error("Could not query the settings for %s: %s", channel, err.Error())
+	}
 
 	for _, plugin := range bot.Plugins() {
 		name := plugin.Name()
@@ -76,6 +119,7 @@ func newChannelWorker(channel string, bot *Kabukibot) *channelWorker {
 	}
 
 	cw.workers = workers
+	cw.aclSweeper = NewBackgroundTask(aclSweepInterval, cw.sweepACL, nil)
 
 	return cw
 }
@@ -84,6 +128,11 @@ func (self *channelWorker) Name() string {
 	return self.channel
 }
 
+// Context is done when the bot leaves the channel or shuts down.
+func (self *channelWorker) Context() context.Context {
+	return self.ctx
+}
+
 func (self *channelWorker) Plugins() []Plugin {
 	result := make([]Plugin, 0)
 
@@ -122,42 +171,81 @@ func (self *channelWorker) Sender() Sender {
 	return self.sender
 }
 
+// Events returns the channel's event bus. Events published by the channel itself
+// are published on the global bus as well.
+func (self *channelWorker) Events() *EventBus {
+	return self.events
+}
+
+func (self *channelWorker) Tasks() *TaskPool {
+	return self.tasks
+}
+
 func (self *channelWorker) ACL() *ACL {
 	return self.acl
 }
 
-func (self *channelWorker) EnablePlugin(name string) bool {
+// Dictionary gives access to the channel's dictionary entries, with the global
+// entries as fallback.
+func (self *channelWorker) Dictionary() *ChannelDictionary {
+	return self.dictionary
+}
+
+// Settings gives access to the plugins' settings for this channel.
+func (self *channelWorker) Settings() *ChannelSettings {
+	return self.settings
+}
+
+func (self *channelWorker) EnablePlugin(name string) (bool, error) {
 	worker := self.findWorker(name)
 
 	if worker == nil || worker.Enabled {
-		return false
+		return false, nil
+	}
+
+	_, err := self.database.Exec("INSERT INTO plugin (channel, plugin) VALUES (?, ?)", self.channel, name)
+	if err != nil {
+		self.log.Error("Could not enable plugin %s in %s: %s", name, self.channel, err.Error())
+		return false, err
 	}
 
 	worker.Enabled = true
-	worker.Worker.Enable()
+	worker.Panics = 0
+	self.safely(worker, "enabling", worker.Worker.Enable)
 
-	self.database.Exec("INSERT INTO plugin (channel, plugin) VALUES (?, ?)", self.channel, name)
+	self.publish(PluginEnabledEvent{self.channel, name})
 
-	return true
+	return true, nil
 }
 
-func (self *channelWorker) DisablePlugin(name string) bool {
+func (self *channelWorker) DisablePlugin(name string) (bool, error) {
 	worker := self.findWorker(name)
 
 	if worker == nil || !worker.Enabled {
-		return false
+		return false, nil
+	}
+
+	_, err := self.database.Exec("DELETE FROM plugin WHERE channel = ? AND plugin = ?", self.channel, name)
+	if err != nil {
+		self.log.Error("Could not disable plugin %s in %s: %s", name, self.channel, err.Error())
+		return false, err
 	}
 
 	worker.Enabled = false
-	worker.Worker.Disable()
+	self.safely(worker, "disabling", worker.Worker.Disable)
 
-	self.database.Exec("DELETE FROM plugin WHERE channel = ? AND plugin = ?", self.channel, name)
+	self.publish(PluginDisabledEvent{self.channel, name, false})
 
-	return true
+	return true, nil
+}
+
+// Deliver queues a message for the worker; this never blocks.
+func (self *channelWorker) Deliver(msg twitch.IncomingMessage) {
+	self.inbox.Push(msg)
 }
 
-func (self *channelWorker) Input() chan<- twitch.IncomingMessage {
-	return self.inputChannel
+func (self *channelWorker) Stats() ChannelStats {
+	return self.inbox.Stats()
 }
 
 func (self *channelWorker) Alive() <-chan struct{} {
@@ -176,82 +264,65 @@ func (self *channelWorker) Shutdown() <-chan struct{} {
 	return self.alive
 }
 
+// Run executes the function in the channel's goroutine, where it can safely use
+// the plugin workers, and waits for it. It fails if the channel has been left. It
+// must not be called from inside the channel, e.g. by a plugin worker.
+func (self *channelWorker) Run(fn func()) error {
+	done := make(chan struct{})
+	call := func() {
+		defer close(done)
+		fn()
+	}
+
+	select {
+	case self.calls <- call:
+		<-done
+		return nil
+
+	case <-self.alive:
+		return errors.New("The channel has been left.")
+	}
+}
+
 func (self *channelWorker) Work() {
-	// remember, defers are executed in reverse order
-	defer close(self.inputChannel)
 	defer close(self.alive)
 
 	// initialize ACL
 	self.acl.loadData()
+	self.aclSweeper.Start(self.ctx)
+	self.userWatch = self.botEvents.Subscribe(EventUserIdentified, self.onUserIdentified)
+
+	self.tasks.start()
 
 	// enable workers
-	for _, worker := range self.workers {
+	for idx := range self.workers {
+		worker := &self.workers[idx]
+
 		if worker.Enabled {
-			worker.Worker.Enable()
+			self.safely(worker, "enabling", worker.Worker.Enable)
 		}
 	}
 
 	// endless worker loop
 	for {
 		select {
-		case newMsg := <-self.inputChannel:
+		case <-self.inbox.Ready():
+			newMsg, okay := self.inbox.Pop()
+			if !okay {
+				continue
+			}
+
 			// we just left the channel
-			_, okay := newMsg.(twitch.PartMessage)
+			_, okay = newMsg.(twitch.PartMessage)
 			if okay {
 				self.partWorkers()
 				return
 			}
 
-			// determine the plugins to hand this message to
-			switch msg := newMsg.(type) {
-			case TextMessage:
-				for _, worker := range self.workers {
-					if !worker.Enabled {
-						continue
-					}
-
-					asserted, okay := worker.Worker.(textMessageWorker)
-					if okay {
-						asserted.HandleTextMessage(&msg, self.sender.newResponder(&msg))
-					}
-				}
-
-			case twitch.RoomStateMessage:
-				for _, worker := range self.workers {
-					if !worker.Enabled {
-						continue
-					}
-
-					asserted, okay := worker.Worker.(roomStateMessageWorker)
-					if okay {
-						asserted.HandleRoomStateMessage(&msg, self.sender)
-					}
-				}
-
-			case twitch.ClearChatMessage:
-				for _, worker := range self.workers {
-					if !worker.Enabled {
-						continue
-					}
-
-					asserted, okay := worker.Worker.(clearChatMessageWorker)
-					if okay {
-						asserted.HandleClearChatMessage(&msg, self.sender)
-					}
-				}
-
-			case twitch.SubscriberNotificationMessage:
-				for _, worker := range self.workers {
-					if !worker.Enabled {
-						continue
-					}
-
-					asserted, okay := worker.Worker.(subNotificationMessageWorker)
-					if okay {
-						asserted.HandleSubscriberNotificationMessage(&msg, self.sender)
-					}
-				}
-			}
+			self.handleMessage(newMsg)
+
+		case call := <-self.calls:
+			call()
 
 		case <-self.leaveSignal:
 			self.partWorkers()
@@ -264,15 +335,169 @@ func (self *channelWorker) Work() {
 	}
 }
 
+func (self *channelWorker) handleMessage(newMsg twitch.IncomingMessage) {
+	// all plugins must see the same text message, so they can mark it as processed
+	text, okay := newMsg.(TextMessage)
+	if okay {
+		newMsg = &text
+
+		// permissions and the like must already know about a new or renamed user
+		if text.identified != nil {
+			<-text.identified
+		}
+	}
+
+	clear, okay := newMsg.(twitch.ClearChatMessage)
+	if okay && clear.User != "" {
+		self.publish(UserTimedOutEvent{self.channel, clear.User, clear.Duration})
+	}
+
+	for idx := range self.workers {
+		worker := &self.workers[idx]
+
+		if worker.Enabled {
+			self.dispatch(worker, newMsg)
+		}
+	}
+}
+
+// dispatch hands the message to a single plugin worker, if it is interested in this
+// kind of message. A panicking handler is counted against the plugin and the plugin
+// is disabled in this channel when it keeps crashing.
+func (self *channelWorker) dispatch(worker *pluginWorkerStruct, newMsg twitch.IncomingMessage) {
+	label := pluginLabel(worker.Plugin)
+	started := time.Now()
+	handled := false
+
+	okay := self.safely(worker, "handling a message", func() {
+		switch msg := newMsg.(type) {
+		case *TextMessage:
+			asserted, okay := worker.Worker.(textMessageWorker)
+			if okay {
+				handled = true
+				asserted.HandleTextMessage(msg, self.sender.newResponder(msg, label))
+			}
+
+		case twitch.RoomStateMessage:
+			asserted, okay := worker.Worker.(roomStateMessageWorker)
+			if okay {
+				handled = true
+				asserted.HandleRoomStateMessage(&msg, self.sender)
+			}
+
+		case twitch.ClearChatMessage:
+			asserted, okay := worker.Worker.(clearChatMessageWorker)
+			if okay {
+				handled = true
+				asserted.HandleClearChatMessage(&msg, self.sender)
+			}
+
+		case twitch.SubscriberNotificationMessage:
+			asserted, okay := worker.Worker.(subNotificationMessageWorker)
+			if okay {
+				handled = true
+				asserted.HandleSubscriberNotificationMessage(&msg, self.sender)
+			}
+		}
+	})
+
+	// only count plugins that were interested in the message
+	if handled {
+		self.metrics.handled(label, time.Since(started))
+	}
+
+	if okay {
+		return
+	}
+
+	worker.Panics++
+
+	// plugins without a name are always enabled and cannot be turned off
+	name := worker.Plugin.Name()
+	if worker.Panics < maxPluginPanics || name == "" {
+		return
+	}
+
+	self.log.With(Fields{"plugin": name}).Warning("Disabling plugin %s in %s after %d panics.", name, self.channel, worker.Panics)
+
+	worker.Enabled = false
+	self.safely(worker, "disabling", worker.Worker.Disable)
+
+	// a crashing plugin is turned off even if that cannot be stored; it would just
+	// be enabled again after a restart
+	_, err := self.database.Exec("DELETE FROM plugin WHERE channel = ? AND plugin = ?", self.channel, name)
+	if err != nil {
+		self.log.Error("Could not disable plugin %s in %s: %s", name, self.channel, err.Error())
+	}
+
+	self.publish(PluginDisabledEvent{self.channel, name, true})
+
+	self.sender.SendText(fmt.Sprintf(
+		"@%s, the plugin %s crashed repeatedly and has been disabled in this channel.",
+		strings.TrimPrefix(self.channel, "#"), name,
+	))
+}
+
+// safely runs one of the worker's methods and recovers from any panic inside of it,
+// so that a single broken plugin cannot take down the whole bot. It returns false if
+// the plugin panicked.
+func (self *channelWorker) safely(worker *pluginWorkerStruct, action string, fn func()) (okay bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			label := pluginLabel(worker.Plugin)
+			self.log.With(Fields{"plugin": label}).Error("Plugin %s panicked in %s while %s: %v\n%s", label, self.channel, action, err, debug.Stack())
+			okay = false
+		}
+	}()
+
+	fn()
+
+	return true
+}
+
 func (self *channelWorker) partWorkers() {
-	for _, worker := range self.workers {
-		worker.Worker.Part()
+	self.stopTasks()
+
+	for idx := range self.workers {
+		worker := &self.workers[idx]
+		self.safely(worker, "parting", worker.Worker.Part)
 	}
 }
 
 func (self *channelWorker) shutdownWorkers() {
-	for _, worker := range self.workers {
-		worker.Worker.Shutdown()
+	self.stopTasks()
+
+	for idx := range self.workers {
+		worker := &self.workers[idx]
+		self.safely(worker, "shutting down", worker.Worker.Shutdown)
+	}
+}
+
+// publish sends an event to both the channel's and the global bus.
+func (self *channelWorker) publish(event Event) {
+	self.events.Publish(event)
+	self.botEvents.Publish(event)
+}
+
+// stopTasks cancels the channel's context and hence all pending tasks, before the
+// plugin workers get shut down.
+func (self *channelWorker) stopTasks() {
+	self.cancel()
+	self.tasks.stop()
+	self.aclSweeper.Stop()
+	self.userWatch.Unsubscribe()
+}
+
+// onUserIdentified is called from the user directory's goroutine.
+func (self *channelWorker) onUserIdentified(event Event) {
+	identified := event.(UserIdentifiedEvent)
+	self.acl.BindUser(identified.ID, identified.Login)
+}
+
+func (self *channelWorker) sweepACL() {
+	for _, grant := range self.acl.Sweep(time.Now()) {
+		self.log.Info("Permission %s for %s in %s has expired.", grant.Permission, grant.UserIdent, self.channel)
+		self.publish(PermissionExpiredEvent{self.channel, grant.Permission, grant.UserIdent})
 	}
 }
 
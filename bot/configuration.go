@@ -2,7 +2,11 @@ package bot
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -15,15 +19,108 @@ type Configuration struct {
 		Password string
 	}
 	Database struct {
-		DSN string `yaml:"DSN"`
+		Driver string // "mysql" (default) or "sqlite3"
+		DSN    string `yaml:"DSN"`
 	}
 	IRC struct {
 		Host string
 		Port int
 	}
+	Log LogConfiguration
+	API struct {
+		Listen string // address of the HTTP admin API, like "127.0.0.1:8080"; disabled if empty
+		Token  string // the bearer token clients have to send
+	} `yaml:"api"`
 	Plugins map[string]interface{}
+
+	filename string
+}
+
+type LogConfiguration struct {
+	Level    string            // "debug", "info" (default), "warning" or "error"
+	Levels   map[string]string // per subsystem, e.g. "twitch" or "plugin.log"
+	Format   string            // "text" (default) or "json"
+	File     string            // log into this file instead of stdout
+	MaxSize  int               `yaml:"maxSize"`  // in MB before the file is rotated, 0 to never rotate
+	MaxFiles int               `yaml:"maxFiles"` // how many rotated files to keep
+}
+
+// Options creates the logger options, opening the log file if one is configured.
+func (self LogConfiguration) Options() (LogOptions, error) {
+	options := LogOptions{
+		Level:  LogLevelInfo,
+		Levels: make(map[string]int),
+		JSON:   self.Format == "json",
+	}
+
+	var err error
+
+	if self.Level != "" {
+		options.Level, err = ParseLogLevel(self.Level)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	for subsystem, name := range self.Levels {
+		options.Levels[subsystem], err = ParseLogLevel(name)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	if self.File != "" {
+		options.Output, err = OpenRotatingFile(self.File, int64(self.MaxSize)*1024*1024, self.MaxFiles)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
 }
 
+func (self LogConfiguration) validate() error {
+	if self.Format != "" && self.Format != "text" && self.Format != "json" {
+		return errors.New("The log format must be either text or json.")
+	}
+
+	if self.MaxSize < 0 || self.MaxFiles < 0 {
+		return errors.New("The log file size and number of files cannot be negative.")
+	}
+
+	if self.Level != "" {
+		if _, err := ParseLogLevel(self.Level); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range self.Levels {
+		if _, err := ParseLogLevel(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A PluginConfig is a plugin's typed settings struct.
+type PluginConfig interface {
+	// Validate checks the values; unknown keys have been rejected already.
+	Validate() error
+}
+
+// the settings of all plugins, so that the whole configuration can be checked
+// when it is loaded
+var pluginConfigs = make(map[string]func() PluginConfig)
+
+// RegisterPluginConfig makes a plugin's settings known under the given key below
+// "plugins". The function must return a pointer to a new struct with the defaults.
+func RegisterPluginConfig(key string, defaults func() PluginConfig) {
+	pluginConfigs[key] = defaults
+}
+
+// LoadConfiguration reads the configuration file, rejecting unknown keys, and then
+// applies the overrides from the environment.
 func LoadConfiguration(filename string) (*Configuration, error) {
 	content, err := ioutil.ReadFile(filename)
 
@@ -31,23 +128,105 @@ func LoadConfiguration(filename string) (*Configuration, error) {
 		return nil, err
 	}
 
-	config := Configuration{}
+	config := Configuration{filename: filename}
+	raw := make(map[interface{}]interface{})
 
-	if yaml.Unmarshal(content, &config) != nil {
+	if yaml.Unmarshal(content, &config) != nil || yaml.Unmarshal(content, &raw) != nil {
 		return &config, errors.New("Could not load configuration file '" + filename + "'.")
 	}
 
-	if len(config.Operator) == 0 {
-		return &config, errors.New("You must configure an operator.")
+	unknown := unknownKeys(raw, reflect.TypeOf(config), "")
+	if len(unknown) > 0 {
+		return &config, fmt.Errorf("Unknown keys in configuration file '%s': %s", filename, strings.Join(unknown, ", "))
 	}
 
-	return &config, nil
+	err = applyEnvironment(reflect.ValueOf(&config), envPrefix)
+	if err != nil {
+		return &config, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return &config, err
+	}
+
+	return &config, config.validatePlugins()
 }
 
-func (self *Configuration) PluginConfig(plugin string, dest interface{}) error {
+// Filename is the file the configuration has been loaded from, if any.
+func (self *Configuration) Filename() string {
+	return self.filename
+}
+
+// Validate checks the settings the bot cannot run without. Plugins validate their
+// own configuration.
+func (self *Configuration) Validate() error {
+	if len(self.Operator) == 0 {
+		return errors.New("You must configure an operator.")
+	}
+
+	if len(self.Account.Username) == 0 {
+		return errors.New("You must configure the account to log-in as.")
+	}
+
+	if len(self.IRC.Host) == 0 || self.IRC.Port <= 0 {
+		return errors.New("You must configure the IRC host and port.")
+	}
+
+	if len(self.API.Listen) > 0 && len(self.API.Token) < 16 {
+		return errors.New("The API token must be at least 16 characters long.")
+	}
+
+	return self.Log.validate()
+}
+
+// restartRequired lists the settings that differ from the other configuration, but
+// cannot be changed while the bot is running.
+func (self *Configuration) restartRequired(other *Configuration) []string {
+	changed := make([]string, 0)
+
+	if self.Account != other.Account {
+		changed = append(changed, "account")
+	}
+
+	if self.Operator != other.Operator {
+		changed = append(changed, "operator")
+	}
+
+	if self.CommandPrefix != other.CommandPrefix {
+		changed = append(changed, "commandPrefix")
+	}
+
+	if self.Database != other.Database {
+		changed = append(changed, "database")
+	}
+
+	if self.IRC != other.IRC {
+		changed = append(changed, "irc")
+	}
+
+	if !reflect.DeepEqual(self.Log, other.Log) {
+		changed = append(changed, "log")
+	}
+
+	if self.API != other.API {
+		changed = append(changed, "api")
+	}
+
+	return changed
+}
+
+// PluginConfig decodes the plugin's settings into dest, which should hold the
+// defaults, applies the environment overrides and validates the result.
+func (self *Configuration) PluginConfig(plugin string, dest PluginConfig) error {
 	data, exists := self.Plugins[plugin]
 
 	if exists {
+		unknown := unknownKeys(data, reflect.TypeOf(dest), "plugins."+plugin)
+		if len(unknown) > 0 {
+			return fmt.Errorf("Unknown keys: %s", strings.Join(unknown, ", "))
+		}
+
 		// very lazy hack because i could not figure out how to nicely type assert
 		// the existing structure (which seems to be an endless map[string]interface{}
 		// monster) to the concrete dest struct
@@ -59,5 +238,37 @@ func (self *Configuration) PluginConfig(plugin string, dest interface{}) error {
 		}
 	}
 
+	err := applyEnvironment(reflect.ValueOf(dest), envPrefix+"_PLUGINS_"+envName(plugin))
+	if err != nil {
+		return err
+	}
+
+	return dest.Validate()
+}
+
+// validatePlugins checks that every configured plugin exists and that all plugins
+// accept their settings.
+func (self *Configuration) validatePlugins() error {
+	for name := range self.Plugins {
+		if _, exists := pluginConfigs[name]; !exists {
+			return fmt.Errorf("There is no configurable plugin named 'plugins.%s'.", name)
+		}
+	}
+
+	names := make([]string, 0, len(pluginConfigs))
+
+	for name := range pluginConfigs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := self.PluginConfig(name, pluginConfigs[name]())
+		if err != nil {
+			return fmt.Errorf("Invalid '%s' plugin configuration: %s", name, err.Error())
+		}
+	}
+
 	return nil
 }
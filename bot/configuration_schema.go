@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// environment variables overriding configuration values are named after their path,
+// e.g. KABUKIBOT_ACCOUNT_PASSWORD or KABUKIBOT_PLUGINS_LOG_DIRECTORY
+const envPrefix = "KABUKIBOT"
+
+// yamlKey returns the key a struct field is read from, or "" if it is never read.
+func yamlKey(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+	switch tag {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(field.Name)
+	default:
+		return tag
+	}
+}
+
+// unknownKeys walks the raw decoded YAML data alongside the type it is decoded into
+// and returns the paths of all keys the type has no field for.
+func unknownKeys(data interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	unknown := make([]string, 0)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		raw, okay := data.(map[interface{}]interface{})
+		if !okay {
+			break
+		}
+
+		fields := make(map[string]reflect.Type)
+
+		for i := 0; i < t.NumField(); i++ {
+			key := yamlKey(t.Field(i))
+			if key != "" {
+				fields[key] = t.Field(i).Type
+			}
+		}
+
+		for key, value := range raw {
+			name := fmt.Sprintf("%v", key)
+
+			fieldType, exists := fields[name]
+			if !exists {
+				unknown = append(unknown, joinPath(path, name))
+				continue
+			}
+
+			unknown = append(unknown, unknownKeys(value, fieldType, joinPath(path, name))...)
+		}
+
+	case reflect.Map:
+		raw, okay := data.(map[interface{}]interface{})
+		if !okay {
+			break
+		}
+
+		for key, value := range raw {
+			unknown = append(unknown, unknownKeys(value, t.Elem(), joinPath(path, fmt.Sprintf("%v", key)))...)
+		}
+
+	case reflect.Slice:
+		raw, okay := data.([]interface{})
+		if !okay {
+			break
+		}
+
+		for idx, value := range raw {
+			unknown = append(unknown, unknownKeys(value, t.Elem(), fmt.Sprintf("%s[%d]", path, idx))...)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// applyEnvironment overrides string, number and boolean fields of the given struct
+// with the environment variables named after them. Maps and lists cannot be set.
+func applyEnvironment(value reflect.Value, prefix string) error {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		key := yamlKey(value.Type().Field(i))
+		if key == "" {
+			continue
+		}
+
+		name := prefix + "_" + envName(key)
+		field := value.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			err := applyEnvironment(field, name)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		env, exists := os.LookupEnv(name)
+		if !exists {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(env)
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			parsed, err := strconv.ParseInt(env, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number.", name)
+			}
+
+			field.SetInt(parsed)
+
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(env)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false.", name)
+			}
+
+			field.SetBool(parsed)
+
+		default:
+			return fmt.Errorf("%s cannot be set via the environment.", name)
+		}
+	}
+
+	return nil
+}
+
+// envName turns a key like "commandPrefix" into "COMMAND_PREFIX".
+func envName(key string) string {
+	runes := []rune(key)
+	name := make([]rune, 0, len(runes))
+
+	for idx, r := range runes {
+		if idx > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[idx-1]) {
+			name = append(name, '_')
+		}
+
+		name = append(name, unicode.ToUpper(r))
+	}
+
+	return string(name)
+}
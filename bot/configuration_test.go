@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func validConfiguration() Configuration {
+	config := Configuration{Operator: "op"}
+	config.Account.Username = "bot"
+	config.IRC.Host = "irc.twitch.tv"
+	config.IRC.Port = 6667
+
+	return config
+}
+
+func TestConfigurationRequiresAnAPITokenWhenListening(t *testing.T) {
+	config := validConfiguration()
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the API to be optional, got '%s'.", err)
+	}
+
+	config.API.Listen = "127.0.0.1:8080"
+
+	for _, token := range []string{"", strings.Repeat("x", 15)} {
+		config.API.Token = token
+
+		if config.Validate() == nil {
+			t.Errorf("Expected the %d characters long token to be rejected.", len(token))
+		}
+	}
+
+	config.API.Token = strings.Repeat("x", 16)
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a 16 characters long token to be accepted, got '%s'.", err)
+	}
+}
+
+const minimalConfiguration = `
+account: { username: bot, password: secret }
+operator: op
+irc: { host: irc.twitch.tv, port: 6667 }
+`
+
+type testPluginConfig struct {
+	Limit int
+	Names []string
+}
+
+func (self *testPluginConfig) Validate() error {
+	if self.Limit < 0 {
+		return errors.New("The limit must not be negative.")
+	}
+
+	return nil
+}
+
+// loadTestConfiguration loads the minimal configuration plus the given YAML, with
+// a configurable "test" plugin.
+func loadTestConfiguration(t *testing.T, yaml string) (*Configuration, error) {
+	file, err := ioutil.TempFile("", "kabukibot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+
+	file.WriteString(minimalConfiguration + yaml)
+	file.Close()
+
+	RegisterPluginConfig("test", func() PluginConfig { return &testPluginConfig{Limit: 10} })
+	defer delete(pluginConfigs, "test")
+
+	return LoadConfiguration(file.Name())
+}
+
+func expectConfigurationError(t *testing.T, yaml string, message string) {
+	_, err := loadTestConfiguration(t, yaml)
+
+	if err == nil {
+		t.Errorf("Expected %q to be rejected.", yaml)
+	} else if !strings.Contains(err.Error(), message) {
+		t.Errorf("Expected the error for %q to mention '%s', got '%s'.", yaml, message, err)
+	}
+}
+
+func setEnv(values map[string]string) func() {
+	for name, value := range values {
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name := range values {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestConfigurationRejectsUnknownKeys(t *testing.T) {
+	expectConfigurationError(t, "foo: 1\nbar: 2", "bar, foo")
+	expectConfigurationError(t, "database: { driver: sqlite3, dsn: x.db }", "database.dsn")
+	expectConfigurationError(t, "log: { levels: { twitch: debug }, rotate: true }", "log.rotate")
+	expectConfigurationError(t, "plugins: { nope: { limit: 1 } }", "no configurable plugin named 'plugins.nope'")
+	expectConfigurationError(t, "plugins: { test: { limit: 1, names: [a], limits: 2 } }", "plugins.test.limits")
+}
+
+func TestConfigurationRejectsWrongTypes(t *testing.T) {
+	expectConfigurationError(t, "irc: { port: many }", "Could not load configuration file")
+	expectConfigurationError(t, "account: [bot]", "Could not load configuration file")
+	expectConfigurationError(t, "plugins: { test: { limit: many } }", "Invalid 'test' plugin configuration")
+}
+
+func TestConfigurationLetsPluginsRejectTheirSettings(t *testing.T) {
+	expectConfigurationError(t, "plugins: { test: { limit: -1 } }", "Invalid 'test' plugin configuration: The limit must not be negative.")
+
+	// the defaults are validated as well
+	defer setEnv(map[string]string{"KABUKIBOT_PLUGINS_TEST_LIMIT": "-1"})()
+
+	expectConfigurationError(t, "", "The limit must not be negative.")
+}
+
+func TestConfigurationCanBeOverriddenByTheEnvironment(t *testing.T) {
+	defer setEnv(map[string]string{
+		"KABUKIBOT_COMMAND_PREFIX":     "x_",
+		"KABUKIBOT_ACCOUNT_PASSWORD":   "oauth:other",
+		"KABUKIBOT_DATABASE_DSN":       "bot.db",
+		"KABUKIBOT_IRC_PORT":           "6697",
+		"KABUKIBOT_LOG_MAX_SIZE":       "5",
+		"KABUKIBOT_PLUGINS_TEST_LIMIT": "3",
+	})()
+
+	config, err := loadTestConfiguration(t, "commandPrefix: k_\nplugins: { test: { limit: 1 } }")
+	if err != nil {
+		t.Fatalf("Could not load the configuration: %s", err)
+	}
+
+	if config.CommandPrefix != "x_" || config.Account.Password != "oauth:other" || config.Database.DSN != "bot.db" || config.IRC.Port != 6697 || config.Log.MaxSize != 5 {
+		t.Errorf("Not all values have been overridden: %+v", config)
+	}
+
+	plugin := &testPluginConfig{}
+
+	err = config.PluginConfig("test", plugin)
+	if err != nil || plugin.Limit != 3 {
+		t.Errorf("Expected the plugin's limit to be 3, got %d (%v).", plugin.Limit, err)
+	}
+}
+
+func TestConfigurationRejectsInvalidEnvironment(t *testing.T) {
+	restore := setEnv(map[string]string{"KABUKIBOT_IRC_PORT": "many"})
+	expectConfigurationError(t, "", "KABUKIBOT_IRC_PORT must be a number.")
+	restore()
+
+	restore = setEnv(map[string]string{"KABUKIBOT_LOG_LEVELS": "debug"})
+	expectConfigurationError(t, "", "KABUKIBOT_LOG_LEVELS cannot be set via the environment.")
+	restore()
+}
+
+func TestEnvironmentNamesSplitCamelCase(t *testing.T) {
+	names := map[string]string{
+		"irc":           "IRC",
+		"DSN":           "DSN",
+		"commandPrefix": "COMMAND_PREFIX",
+		"maxFiles":      "MAX_FILES",
+		"apiURL":        "API_URL",
+	}
+
+	for key, expected := range names {
+		if name := envName(key); name != expected {
+			t.Errorf("Expected %s to become %s, got %s.", key, expected, name)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package bot
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3" // needs cgo
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Queryer is implemented by the database as well as by its transactions.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// Database is the storage backend used by the bot and its plugins. Queries use "?"
+// as placeholders and must stick to SQL that all dialects understand; anything that
+// differs between the backends is provided by the Dialect.
+//
+// Errors that happen before a statement had any effect (deadlocks, lock timeouts,
+// locked SQLite files) are retried a few times before they are returned to the
+// caller. Lost connections are only retried for reads: a write or a commit may have
+// reached the database already and must not be run twice.
+type Database interface {
+	Queryer
+
+	// Transaction runs fn inside a transaction, which is committed if fn returns nil
+	// and rolled back otherwise. fn must only use the given Queryer and can be called
+	// more than once when the transaction has been rolled back by a deadlock.
+	Transaction(fn func(Queryer) error) error
+
+	Dialect() Dialect
+	Stats() DatabaseStats
+	Close() error
+}
+
+type DatabaseStats struct {
+	Queries uint64 // number of queries and transactions
+	Retries uint64 // number of retries because of transient errors
+	Errors  uint64 // number of failed queries and transactions, after retrying
+}
+
+type Dialect interface {
+	// Name returns the name of the database/sql driver.
+	Name() string
+
+	// Tables lists all tables in the database.
+	Tables(db Database) ([]string, error)
+
+	// Columns lists the columns of a table; it is empty if the table does not exist.
+	Columns(db Database, table string) ([]string, error)
+
+	// TableOptions is appended to CREATE TABLE statements.
+	TableOptions() string
+
+	// Upsert builds a statement that inserts a row or updates the columns of an
+	// existing one with the same keys. The arguments are the keys, then the columns.
+	Upsert(table string, keys []string, columns []string) string
+
+	// IsTransient tells whether a statement failed before it had any effect, so that
+	// it can safely be run again.
+	IsTransient(err error) bool
+
+	// IsConnectionError tells whether the connection broke; the statement may or may
+	// not have been executed.
+	IsConnectionError(err error) bool
+}
+
+// how often queries are attempted before giving up; there is no delay between the
+// attempts, as the database has already waited for the lock before failing and the
+// caller is usually a channel that should not be held up any longer
+const dbAttempts = 3
+
+type sqlDatabase struct {
+	db      *sqlx.DB
+	dialect Dialect
+	queries uint64
+	retries uint64
+	errors  uint64
+}
+
+// OpenDatabase connects to a MySQL ("mysql") or SQLite ("sqlite3") database. An empty
+// driver means MySQL.
+func OpenDatabase(driver string, dsn string) (Database, error) {
+	var dialect Dialect
+
+	switch driver {
+	case "", "mysql":
+		dialect = mysqlDialect{}
+
+	case "sqlite3", "sqlite":
+		dialect = sqliteDialect{}
+
+	default:
+		return nil, errors.New("Unknown database driver '" + driver + "'.")
+	}
+
+	db, err := sqlx.Connect(dialect.Name(), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite does not handle concurrent writers well, and every connection to an
+	// in-memory database would see its own, empty database.
+	if dialect.Name() == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+
+	return &sqlDatabase{db: db, dialect: dialect}, nil
+}
+
+func (self *sqlDatabase) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	err = self.retry(false, func() error {
+		result, err = self.db.Exec(query, args...)
+		return err
+	})
+
+	return result, err
+}
+
+func (self *sqlDatabase) Get(dest interface{}, query string, args ...interface{}) error {
+	return self.retry(true, func() error {
+		return self.db.Get(dest, query, args...)
+	})
+}
+
+func (self *sqlDatabase) Select(dest interface{}, query string, args ...interface{}) error {
+	return self.retry(true, func() error {
+		return self.db.Select(dest, query, args...)
+	})
+}
+
+func (self *sqlDatabase) Transaction(fn func(Queryer) error) error {
+	return self.retry(false, func() error {
+		tx, err := self.db.Beginx()
+		if err != nil {
+			return err
+		}
+
+		err = fn(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// txDatabase makes a transaction look like a database, so that code written for a
+// Database (like migrations) can run inside of a transaction. Nested transactions
+// simply become part of the outer one.
+type txDatabase struct {
+	Queryer
+	db Database
+}
+
+func (self *txDatabase) Transaction(fn func(Queryer) error) error {
+	return fn(self)
+}
+
+func (self *txDatabase) Dialect() Dialect {
+	return self.db.Dialect()
+}
+
+func (self *txDatabase) Stats() DatabaseStats {
+	return self.db.Stats()
+}
+
+func (self *txDatabase) Close() error {
+	return errors.New("A transaction cannot be closed.")
+}
+
+// retry runs fn again as long as it fails in a way that is safe to repeat; reads
+// can also be repeated after the connection broke.
+func (self *sqlDatabase) retry(read bool, fn func() error) error {
+	atomic.AddUint64(&self.queries, 1)
+
+	err := fn()
+
+	for attempt := 1; attempt < dbAttempts && err != nil && self.repeatable(err, read); attempt++ {
+		atomic.AddUint64(&self.retries, 1)
+		err = fn()
+	}
+
+	// not finding a row is an answer, not an error
+	if err != nil && err != sql.ErrNoRows {
+		atomic.AddUint64(&self.errors, 1)
+	}
+
+	return err
+}
+
+func (self *sqlDatabase) repeatable(err error, read bool) bool {
+	return self.dialect.IsTransient(err) || (read && self.dialect.IsConnectionError(err))
+}
+
+func (self *sqlDatabase) Dialect() Dialect {
+	return self.dialect
+}
+
+func (self *sqlDatabase) Stats() DatabaseStats {
+	return DatabaseStats{
+		Queries: atomic.LoadUint64(&self.queries),
+		Retries: atomic.LoadUint64(&self.retries),
+		Errors:  atomic.LoadUint64(&self.errors),
+	}
+}
+
+func (self *sqlDatabase) Close() error {
+	return self.db.Close()
+}
+
+// isConnectionError covers errors that all drivers have in common.
+func isConnectionError(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+
+	_, isNetError := err.(net.Error)
+
+	return isNetError
+}
+
+func insertStatement(table string, keys []string, columns []string) string {
+	all := append(append([]string{}, keys...), columns...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ")
+
+	return "INSERT INTO " + table + " (" + strings.Join(all, ", ") + ") VALUES (" + placeholders + ")"
+}
+
+type mysqlDialect struct{}
+
+func (self mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (self mysqlDialect) Tables(db Database) ([]string, error) {
+	tables := make([]string, 0)
+	err := db.Select(&tables, "SHOW TABLES")
+
+	return tables, err
+}
+
+func (self mysqlDialect) Columns(db Database, table string) ([]string, error) {
+	columns := make([]string, 0)
+	err := db.Select(&columns, "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position", table)
+
+	return columns, err
+}
+
+func (self mysqlDialect) TableOptions() string {
+	return "ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+}
+
+func (self mysqlDialect) Upsert(table string, keys []string, columns []string) string {
+	updates := make([]string, len(columns))
+
+	for idx, column := range columns {
+		updates[idx] = column + " = VALUES(" + column + ")"
+	}
+
+	return insertStatement(table, keys, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+func (self mysqlDialect) IsTransient(err error) bool {
+	if myErr, ok := err.(*mysql.MySQLError); ok {
+		switch myErr.Number {
+		case 1205, 1213: // lock wait timeout, deadlock
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self mysqlDialect) IsConnectionError(err error) bool {
+	return isConnectionError(err) || err == mysql.ErrInvalidConn
+}
+
+type sqliteDialect struct{}
+
+func (self sqliteDialect) Name() string {
+	return "sqlite3"
+}
+
+func (self sqliteDialect) Tables(db Database) ([]string, error) {
+	tables := make([]string, 0)
+	err := db.Select(&tables, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+
+	return tables, err
+}
+
+func (self sqliteDialect) Columns(db Database, table string) ([]string, error) {
+	columns := make([]string, 0)
+	err := db.Select(&columns, "SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+
+	return columns, err
+}
+
+func (self sqliteDialect) TableOptions() string {
+	return ""
+}
+
+func (self sqliteDialect) Upsert(table string, keys []string, columns []string) string {
+	updates := make([]string, len(columns))
+
+	for idx, column := range columns {
+		updates[idx] = column + " = excluded." + column
+	}
+
+	return insertStatement(table, keys, columns) + " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+func (self sqliteDialect) IsTransient(err error) bool {
+	msg := err.Error()
+
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+func (self sqliteDialect) IsConnectionError(err error) bool {
+	return isConnectionError(err)
+}
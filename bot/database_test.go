@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func attempts(db *sqlDatabase, read bool, failure error) int {
+	count := 0
+
+	db.retry(read, func() error {
+		count++
+		return failure
+	})
+
+	return count
+}
+
+func TestDatabaseOnlyRepeatsWhatIsSafeToRepeat(t *testing.T) {
+	db := &sqlDatabase{dialect: mysqlDialect{}}
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+
+	tests := []struct {
+		read     bool
+		failure  error
+		expected int
+	}{
+		{false, deadlock, dbAttempts},
+		{true, deadlock, dbAttempts},
+		{true, driver.ErrBadConn, dbAttempts},
+		{true, mysql.ErrInvalidConn, dbAttempts},
+		{false, driver.ErrBadConn, 1},
+		{false, mysql.ErrInvalidConn, 1},
+		{true, errors.New("syntax error"), 1},
+		{false, nil, 1},
+	}
+
+	for _, test := range tests {
+		if count := attempts(db, test.read, test.failure); count != test.expected {
+			t.Errorf("Expected %v (read: %v) to be attempted %d times, got %d.", test.failure, test.read, test.expected, count)
+		}
+	}
+
+	db = &sqlDatabase{dialect: sqliteDialect{}}
+
+	if count := attempts(db, false, errors.New("database is locked")); count != dbAttempts {
+		t.Errorf("Expected writes to a locked SQLite database to be retried, got %d attempts.", count)
+	}
+
+	if count := attempts(db, false, driver.ErrBadConn); count != 1 {
+		t.Errorf("Expected failed SQLite writes not to be repeated, got %d attempts.", count)
+	}
+}
@@ -1,129 +1,342 @@
 package bot
 
 import (
+	"sort"
+	"strings"
 	"sync"
-
-	"github.com/jmoiron/sqlx"
 )
 
 type dict map[string]string
 
+// the scope of global dictionary entries
+const globalScope = ""
+
 // The Dictionary is a glorified string/string map that's kept in sync with a database table.
+// Besides the global entries, every channel can have its own entries, which take
+// precedence over global entries with the same key in that channel (see
+// ChannelDictionary). The methods on the Dictionary itself only deal with global
+// entries. Keys are case-insensitive and always stored in lowercase.
 type Dictionary struct {
-	db    *sqlx.DB
-	log   Logger
-	data  dict
-	mutex sync.RWMutex
+	db      Database
+	log     Logger
+	events  *EventBus
+	data    map[string]dict // by channel
+	mutex   sync.RWMutex    // guards the data
+	writing sync.Mutex      // held while changes are stored, so they happen in order
+}
+
+func NewDictionary(db Database, log Logger, events *EventBus) *Dictionary {
+	return &Dictionary{
+		db:     db,
+		log:    log,
+		events: events,
+		data:   map[string]dict{globalScope: make(dict)},
+	}
 }
 
-func NewDictionary(db *sqlx.DB, log Logger) *Dictionary {
-	return &Dictionary{db, log, make(dict), sync.RWMutex{}}
+// Channel returns a view on the dictionary that falls back to the global entries.
+func (self *Dictionary) Channel(channel string) *ChannelDictionary {
+	return &ChannelDictionary{self, channel}
 }
 
 func (self *Dictionary) Keys() []string {
+	return self.keys(globalScope)
+}
+
+// Add only sets the value if the key does not exist yet.
+func (self *Dictionary) Add(key string, value string) error {
+	return self.add(globalScope, key, value, DictionaryAuthor{})
+}
+
+// Set stores the value. If it cannot be written to the database, the previous value
+// is kept and the error is returned.
+func (self *Dictionary) Set(key string, value string) error {
+	return self.set(globalScope, key, value, DictionaryAuthor{})
+}
+
+// SetBy works like Set, but attributes the change in the revision history.
+func (self *Dictionary) SetBy(key string, value string, author DictionaryAuthor) error {
+	return self.set(globalScope, key, value, author)
+}
+
+func (self *Dictionary) Get(key string) string {
+	value, _ := self.get(globalScope, key)
+	return value
+}
+
+func (self *Dictionary) Has(key string) bool {
+	_, exists := self.get(globalScope, key)
+	return exists
+}
+
+func (self *Dictionary) Delete(key string) *Dictionary {
+	self.delete(globalScope, key, DictionaryAuthor{})
+	return self
+}
+
+func (self *Dictionary) DeleteBy(key string, author DictionaryAuthor) (bool, error) {
+	return self.delete(globalScope, key, author)
+}
+
+// Scopes returns the channels that have their own entries.
+func (self *Dictionary) Scopes() []string {
 	self.mutex.RLock()
 	defer self.mutex.RUnlock()
 
-	list := make([]string, len(self.data))
-	idx := 0
+	list := make([]string, 0, len(self.data))
 
-	for key, _ := range self.data {
-		list[idx] = key
-		idx = idx + 1
+	for channel, entries := range self.data {
+		if channel != globalScope && len(entries) > 0 {
+			list = append(list, channel)
+		}
 	}
 
+	sort.Strings(list)
+
 	return list
 }
 
-func (self *Dictionary) Add(key string, value string) {
-	self.mutex.Lock()
-	defer self.mutex.Unlock()
+func (self *Dictionary) keys(scope string) []string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
 
-	_, exists := self.data[key]
-	if !exists {
-		self.data[key] = value
+	list := make([]string, 0, len(self.data[scope]))
 
-		_, err := self.db.Exec("INSERT INTO dictionary (keyname, value) VALUES (?, ?)", key, value)
-		if err != nil {
-			self.log.Fatal("Could not add dictionary entry '" + key + "' to the database: " + err.Error())
-		}
+	for key := range self.data[scope] {
+		list = append(list, key)
+	}
+
+	return list
+}
+
+func (self *Dictionary) get(scope string, key string) (string, bool) {
+	key = normalizeKey(key)
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	value, exists := self.data[scope][key]
+
+	return value, exists
+}
+
+func (self *Dictionary) add(scope string, key string, value string, author DictionaryAuthor) error {
+	key = normalizeKey(key)
 
-		self.log.Debug("Added dictionary entry '%s' as '%s'.", key, value)
+	self.writing.Lock()
+
+	if _, exists := self.get(scope, key); exists {
+		self.writing.Unlock()
+		return nil
 	}
+
+	return self.change(scope, key, nil, &value, author)
 }
 
-func (self *Dictionary) Set(key string, value string) {
-	self.mutex.Lock()
+func (self *Dictionary) set(scope string, key string, value string, author DictionaryAuthor) error {
+	key = normalizeKey(key)
+
+	self.writing.Lock()
 
-	_, exists := self.data[key]
+	old, exists := self.get(scope, key)
 	if !exists {
-		self.mutex.Unlock()
-		self.Add(key, value)
-		return
+		return self.change(scope, key, nil, &value, author)
 	}
 
-	self.data[key] = value
+	return self.change(scope, key, &old, &value, author)
+}
 
-	_, err := self.db.Exec("UPDATE dictionary SET value = ? WHERE keyname = ?", value, key)
-	if err != nil {
-		self.log.Fatal("Could not update dictionary entry '" + key + "' in the database: " + err.Error())
+func (self *Dictionary) delete(scope string, key string, author DictionaryAuthor) (bool, error) {
+	key = normalizeKey(key)
+
+	self.writing.Lock()
+
+	old, exists := self.get(scope, key)
+	if !exists {
+		self.writing.Unlock()
+		return false, nil
 	}
 
-	self.log.Debug("Updated dictionary entry '%s' with '%s'.", key, value)
-	self.mutex.Unlock()
+	err := self.change(scope, key, &old, nil, author)
+
+	return err == nil, err
 }
 
-func (self *Dictionary) Get(key string) string {
-	self.mutex.RLock()
-	defer self.mutex.RUnlock()
+// change must be called with the write lock being held and releases it. The change
+// is stored first and only then applied to the entries, so that readers never have
+// to wait for the database.
+func (self *Dictionary) change(scope string, key string, old *string, new *string, author DictionaryAuthor) error {
+	err := self.db.Transaction(func(tx Queryer) error {
+		return self.write(tx, scope, key, old, new, author)
+	})
 
-	value, exists := self.data[key]
-	if exists {
-		return value
+	if err != nil {
+		self.writing.Unlock()
+
+		action := "update"
+		if old == nil {
+			action = "add"
+		} else if new == nil {
+			action = "remove"
+		}
+
+		self.log.Error("Could not %s dictionary entry '%s'%s: %s", action, key, scopeSuffix(scope), err.Error())
+		return err
 	}
 
-	return ""
+	self.finish(scope, key, old, new)
+
+	return nil
 }
 
-func (self *Dictionary) Has(key string) bool {
-	self.mutex.RLock()
-	defer self.mutex.RUnlock()
+// finish applies a stored change, releases the write lock and publishes the change.
+func (self *Dictionary) finish(scope string, key string, old *string, new *string) {
+	event := self.apply(scope, key, old, new)
+	self.writing.Unlock()
 
-	_, exists := self.data[key]
-	return exists
+	self.events.Publish(event)
 }
 
-func (self *Dictionary) Delete(key string) *Dictionary {
-	_, exists := self.data[key]
-	if exists {
-		delete(self.data, key)
+// write stores the new value of the entry, which is created if there is no old
+// value and deleted if there is no new one.
+func (self *Dictionary) write(tx Queryer, scope string, key string, old *string, new *string, author DictionaryAuthor) error {
+	var err error
+
+	switch {
+	case old == nil:
+		_, err = tx.Exec("INSERT INTO dictionary (channel, keyname, value) VALUES (?, ?, ?)", scope, key, *new)
+	case new == nil:
+		_, err = tx.Exec("DELETE FROM dictionary WHERE channel = ? AND keyname = ?", scope, key)
+	default:
+		_, err = tx.Exec("UPDATE dictionary SET value = ? WHERE channel = ? AND keyname = ?", *new, scope, key)
+	}
 
-		_, err := self.db.Exec("DELETE FROM dictionary WHERE keyname = ?", key)
-		if err != nil {
-			self.log.Fatal("Could not remove dictionary entry '" + key + "' from the database: " + err.Error())
-		}
+	if err != nil {
+		return err
+	}
+
+	return self.record(tx, scope, key, old, new, author)
+}
 
-		self.log.Debug("Deleted dictionary entry '%s'.", key)
+// apply updates the entries after the change has been stored.
+func (self *Dictionary) apply(scope string, key string, old *string, new *string) DictionaryKeyUpdatedEvent {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if new == nil {
+		delete(self.data[scope], key)
+		self.log.Debug("Deleted dictionary entry '%s'%s.", key, scopeSuffix(scope))
+
+		return DictionaryKeyUpdatedEvent{Channel: scope, Key: key, Deleted: true}
 	}
 
-	return self
+	if self.data[scope] == nil {
+		self.data[scope] = make(dict)
+	}
+
+	self.data[scope][key] = *new
+
+	if old == nil {
+		self.log.Debug("Added dictionary entry '%s' as '%s'%s.", key, *new, scopeSuffix(scope))
+	} else {
+		self.log.Debug("Updated dictionary entry '%s' with '%s'%s.", key, *new, scopeSuffix(scope))
+	}
+
+	return DictionaryKeyUpdatedEvent{Channel: scope, Key: key, Value: *new}
+}
+
+func scopeSuffix(scope string) string {
+	if scope == globalScope {
+		return ""
+	}
+
+	return " in " + scope
 }
 
 type dictRow struct {
+	Channel string
 	Keyname string
 	Value   string
 }
 
-func (self *Dictionary) load() {
+func (self *Dictionary) load() error {
 	self.mutex.Lock()
 	defer self.mutex.Unlock()
 
 	list := make([]dictRow, 0)
-	self.db.Select(&list, "SELECT * FROM dictionary ORDER BY keyname")
+
+	err := self.db.Select(&list, "SELECT channel, keyname, value FROM dictionary ORDER BY keyname")
+	if err != nil {
+		return err
+	}
 
 	for _, item := range list {
-		self.data[item.Keyname] = item.Value
+		if self.data[item.Channel] == nil {
+			self.data[item.Channel] = make(dict)
+		}
+
+		self.data[item.Channel][normalizeKey(item.Keyname)] = item.Value
 	}
 
 	self.log.Debug("Loaded %d dictionary entries.", len(list))
+
+	return nil
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// The ChannelDictionary gives access to a channel's own dictionary entries, falling
+// back to the global entries when reading.
+type ChannelDictionary struct {
+	dict    *Dictionary
+	channel string
+}
+
+func (self *ChannelDictionary) Channel() string {
+	return self.channel
+}
+
+// Get returns the channel's own value, or the global one if there is none.
+func (self *ChannelDictionary) Get(key string) string {
+	if value, exists := self.dict.get(self.channel, key); exists {
+		return value
+	}
+
+	return self.dict.Get(key)
+}
+
+func (self *ChannelDictionary) Has(key string) bool {
+	return self.HasOwn(key) || self.dict.Has(key)
+}
+
+// HasOwn checks whether the channel overrides the key.
+func (self *ChannelDictionary) HasOwn(key string) bool {
+	_, exists := self.dict.get(self.channel, key)
+	return exists
+}
+
+// Set always writes to the channel's own entries.
+func (self *ChannelDictionary) Set(key string, value string) error {
+	return self.dict.set(self.channel, key, value, DictionaryAuthor{})
+}
+
+func (self *ChannelDictionary) SetBy(key string, value string, author DictionaryAuthor) error {
+	return self.dict.set(self.channel, key, value, author)
+}
+
+// Delete removes the channel's own entry, so the global value (if any) shines
+// through again.
+func (self *ChannelDictionary) Delete(key string) (bool, error) {
+	return self.dict.delete(self.channel, key, DictionaryAuthor{})
+}
+
+func (self *ChannelDictionary) DeleteBy(key string, author DictionaryAuthor) (bool, error) {
+	return self.dict.delete(self.channel, key, author)
+}
+
+// Keys returns the channel's own keys only.
+func (self *ChannelDictionary) Keys() []string {
+	return self.dict.keys(self.channel)
 }
@@ -0,0 +1,89 @@
+package bot
+
+// A DictionaryDump holds the whole dictionary, so it can be maintained outside of
+// the bot (e.g. as a YAML file in a git repository).
+type DictionaryDump struct {
+	Global   map[string]string            `yaml:"global" json:"global"`
+	Channels map[string]map[string]string `yaml:"channels,omitempty" json:"channels,omitempty"`
+}
+
+// LoadDictionary creates a dictionary filled from the database, for use outside of
+// a running bot, like the command line tools.
+func LoadDictionary(db Database, log Logger) (*Dictionary, error) {
+	dict := NewDictionary(db, log, NewEventBus())
+
+	return dict, dict.load()
+}
+
+func (self *Dictionary) Export() DictionaryDump {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	dump := DictionaryDump{
+		Global:   make(map[string]string),
+		Channels: make(map[string]map[string]string),
+	}
+
+	for scope, entries := range self.data {
+		target := dump.Global
+
+		if scope != globalScope {
+			if len(entries) == 0 {
+				continue
+			}
+
+			target = make(map[string]string)
+			dump.Channels[scope] = target
+		}
+
+		for key, value := range entries {
+			target[key] = value
+		}
+	}
+
+	return dump
+}
+
+// Import writes all entries from the dump that differ from the current values. If
+// prune is set, entries missing in the dump are deleted. It returns the number of
+// updated and deleted entries. It stops at the first entry that cannot be written.
+func (self *Dictionary) Import(dump DictionaryDump, prune bool, author DictionaryAuthor) (int, int, error) {
+	scopes := map[string]map[string]string{globalScope: dump.Global}
+
+	for channel, entries := range dump.Channels {
+		scopes[channel] = entries
+	}
+
+	updated := 0
+	deleted := 0
+
+	for scope, entries := range scopes {
+		for key, value := range entries {
+			current, exists := self.get(scope, key)
+
+			if !exists || current != value {
+				if err := self.set(scope, key, value, author); err != nil {
+					return updated, deleted, err
+				}
+
+				updated++
+			}
+		}
+	}
+
+	if prune {
+		for _, scope := range append(self.Scopes(), globalScope) {
+			for _, key := range self.keys(scope) {
+				if _, exists := scopes[scope][key]; !exists {
+					if _, err := self.delete(scope, key, author); err != nil {
+						return updated, deleted, err
+					}
+
+					deleted++
+				}
+			}
+		}
+	}
+
+	return updated, deleted, nil
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DictionaryAuthor describes who changed a dictionary entry. Changes made without
+// an author (e.g. by plugins using Set) are recorded with empty values.
+type DictionaryAuthor struct {
+	User   string // the user's login, if a user triggered the change
+	Source string // the plugin that made the change
+}
+
+// A DictionaryRevision is a single change to a dictionary entry. Every key has its
+// own sequence of revisions, starting at 1.
+type DictionaryRevision struct {
+	Revision int
+	Author   DictionaryAuthor
+	Time     time.Time
+	OldValue string
+	NewValue string
+	Created  bool // there was no old value
+	Deleted  bool // there is no new value
+}
+
+type dictRevisionRow struct {
+	Revision  int
+	OldValue  sql.NullString `db:"old_value"`
+	NewValue  sql.NullString `db:"new_value"`
+	Author    string
+	Source    string
+	ChangedAt int64 `db:"changed_at"`
+}
+
+func (self dictRevisionRow) revision() DictionaryRevision {
+	return DictionaryRevision{
+		Revision: self.Revision,
+		Author:   DictionaryAuthor{self.Author, self.Source},
+		Time:     time.Unix(self.ChangedAt, 0),
+		OldValue: self.OldValue.String,
+		NewValue: self.NewValue.String,
+		Created:  !self.OldValue.Valid,
+		Deleted:  !self.NewValue.Valid,
+	}
+}
+
+// History returns the latest revisions of a global entry, newest first.
+func (self *Dictionary) History(key string, limit int) ([]DictionaryRevision, error) {
+	return self.loggedHistory(globalScope, key, limit)
+}
+
+// Revert restores a global entry to the value it had after the given revision. A
+// revision of 0 undoes the latest change instead. The applied revision is returned.
+func (self *Dictionary) Revert(key string, revision int, author DictionaryAuthor) (DictionaryRevision, bool, error) {
+	return self.revert(globalScope, key, revision, author)
+}
+
+func (self *ChannelDictionary) History(key string, limit int) ([]DictionaryRevision, error) {
+	return self.dict.loggedHistory(self.channel, key, limit)
+}
+
+func (self *ChannelDictionary) Revert(key string, revision int, author DictionaryAuthor) (DictionaryRevision, bool, error) {
+	return self.dict.revert(self.channel, key, revision, author)
+}
+
+// record must be called with the write lock being held, so that revision numbers
+// are handed out in order. A missing old or new value marks a creation or deletion.
+func (self *Dictionary) record(tx Queryer, scope string, key string, old *string, new *string, author DictionaryAuthor) error {
+	var latest int
+
+	err := tx.Get(&latest, "SELECT COALESCE(MAX(revision), 0) FROM dictionary_revision WHERE channel = ? AND keyname = ?", scope, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO dictionary_revision (channel, keyname, revision, old_value, new_value, author, source, changed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		scope, key, latest+1, old, new, author.User, author.Source, time.Now().Unix(),
+	)
+
+	return err
+}
+
+func (self *Dictionary) loggedHistory(scope string, key string, limit int) ([]DictionaryRevision, error) {
+	key = normalizeKey(key)
+
+	list, err := self.history(self.db, scope, key, limit)
+	if err != nil {
+		self.log.Error("Could not query the history of '%s'%s: %s", key, scopeSuffix(scope), err.Error())
+	}
+
+	return list, err
+}
+
+func (self *Dictionary) history(q Queryer, scope string, key string, limit int) ([]DictionaryRevision, error) {
+	rows := make([]dictRevisionRow, 0)
+
+	err := q.Select(&rows, "SELECT revision, old_value, new_value, author, source, changed_at FROM dictionary_revision WHERE channel = ? AND keyname = ? ORDER BY revision DESC LIMIT ?", scope, key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]DictionaryRevision, 0, len(rows))
+
+	for _, row := range rows {
+		list = append(list, row.revision())
+	}
+
+	return list, nil
+}
+
+// revision finds a single revision; a revision of 0 means the latest one.
+func (self *Dictionary) revision(q Queryer, scope string, key string, revision int) (DictionaryRevision, bool, error) {
+	if revision == 0 {
+		latest, err := self.history(q, scope, key, 1)
+		if err != nil || len(latest) == 0 {
+			return DictionaryRevision{}, false, err
+		}
+
+		return latest[0], true, nil
+	}
+
+	row := dictRevisionRow{}
+
+	err := q.Get(&row, "SELECT revision, old_value, new_value, author, source, changed_at FROM dictionary_revision WHERE channel = ? AND keyname = ? AND revision = ?", scope, key, revision)
+	if err == sql.ErrNoRows {
+		return DictionaryRevision{}, false, nil
+	} else if err != nil {
+		return DictionaryRevision{}, false, err
+	}
+
+	return row.revision(), true, nil
+}
+
+// revert looks up the revision and stores the restored value in one transaction,
+// while holding the write lock, so that no other change can get in between.
+func (self *Dictionary) revert(scope string, key string, revision int, author DictionaryAuthor) (DictionaryRevision, bool, error) {
+	key = normalizeKey(key)
+
+	var (
+		rev     DictionaryRevision
+		found   bool
+		changed bool
+		old     *string
+		new     *string
+	)
+
+	self.writing.Lock()
+
+	err := self.db.Transaction(func(tx Queryer) error {
+		var err error
+
+		rev, found, err = self.revision(tx, scope, key, revision)
+		if err != nil || !found {
+			return err
+		}
+
+		// undoing the latest change restores its old value, restoring a revision its new one
+		value, deleted := rev.NewValue, rev.Deleted
+		if revision == 0 {
+			value, deleted = rev.OldValue, rev.Created
+		}
+
+		current, exists := self.get(scope, key)
+		if exists {
+			old = &current
+		}
+
+		if !deleted {
+			new = &value
+		}
+
+		if (old == nil && new == nil) || (old != nil && new != nil && *old == *new) {
+			return nil
+		}
+
+		changed = true
+
+		return self.write(tx, scope, key, old, new, author)
+	})
+
+	if err != nil {
+		self.writing.Unlock()
+		self.log.Error("Could not revert dictionary entry '%s'%s: %s", key, scopeSuffix(scope), err.Error())
+
+		return rev, false, err
+	}
+
+	if !changed {
+		self.writing.Unlock()
+		return rev, found, nil
+	}
+
+	self.finish(scope, key, old, new)
+
+	return rev, true, nil
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+// a database whose transactions wait until they are released
+type slowDatabase struct {
+	Database
+	started chan struct{}
+	release chan struct{}
+}
+
+func (self *slowDatabase) Transaction(fn func(Queryer) error) error {
+	close(self.started)
+	<-self.release
+
+	return self.Database.Transaction(fn)
+}
+
+func TestDictionaryCanBeReadWhileWriting(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	slow := &slowDatabase{db, make(chan struct{}), make(chan struct{})}
+	dict := NewDictionary(slow, testLogger(), NewEventBus())
+	written := make(chan error)
+
+	go func() {
+		written <- dict.Set("greeting", "hello")
+	}()
+
+	<-slow.started
+
+	read := make(chan bool)
+
+	go func() {
+		read <- dict.Has("greeting")
+	}()
+
+	select {
+	case exists := <-read:
+		if exists {
+			t.Error("The entry must not be visible before it has been stored.")
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("Reading had to wait for the database.")
+	}
+
+	close(slow.release)
+
+	if err := <-written; err != nil {
+		t.Fatalf("Could not set the entry: %s", err)
+	}
+
+	if value := dict.Get("greeting"); value != "hello" {
+		t.Errorf("Expected 'hello', got '%s'.", value)
+	}
+}
+
+func TestDictionaryRevertReportsFailedLookups(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	dict := NewDictionary(db, testLogger(), NewEventBus())
+	author := DictionaryAuthor{User: "op", Source: "test"}
+
+	dict.SetBy("greeting", "hello", author)
+	dict.SetBy("greeting", "hi", author)
+
+	rev, found, err := dict.Revert("greeting", 0, author)
+	if err != nil || !found || rev.Revision != 2 {
+		t.Fatalf("Expected revision 2 to be undone, got %v, %v, %v.", rev, found, err)
+	}
+
+	if value := dict.Get("greeting"); value != "hello" {
+		t.Errorf("Expected 'hello', got '%s'.", value)
+	}
+
+	exec(t, db, "DROP TABLE dictionary_revision")
+
+	_, found, err = dict.Revert("greeting", 0, author)
+	if err == nil || found {
+		t.Errorf("Expected the failed lookup to be reported, got %v, %v.", found, err)
+	}
+
+	_, err = dict.History("greeting", 5)
+	if err == nil {
+		t.Error("Expected the failed history query to be reported.")
+	}
+
+	if value := dict.Get("greeting"); value != "hello" {
+		t.Errorf("Expected the entry to be unchanged, got '%s'.", value)
+	}
+}
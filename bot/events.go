@@ -0,0 +1,167 @@
+package bot
+
+import "sync"
+
+// Event is anything that can be published on an EventBus. Events are identified by
+// their name, so that plugins can exchange them without importing each other.
+type Event interface {
+	EventName() string
+}
+
+type EventHandler func(Event)
+
+// The EventBus is a simple publish/subscribe mechanism. The bot has one global bus
+// and every channel has its own. Handlers are called synchronously in the
+// publisher's goroutine, in the order they subscribed, so handlers on the global bus
+// must be safe to be called from any goroutine.
+type EventBus struct {
+	mutex    sync.RWMutex
+	handlers map[string][]subscriber
+	nextID   int
+}
+
+type subscriber struct {
+	id      int
+	handler EventHandler
+}
+
+// Subscription is returned by Subscribe and can be used to stop receiving events.
+type Subscription struct {
+	bus  *EventBus
+	name string
+	id   int
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		handlers: make(map[string][]subscriber),
+	}
+}
+
+// Subscribe calls the handler for every published event whose EventName() is the
+// given name. Only the name is compared, not the event's type.
+func (self *EventBus) Subscribe(name string, handler EventHandler) *Subscription {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.nextID++
+	self.handlers[name] = append(self.handlers[name], subscriber{self.nextID, handler})
+
+	return &Subscription{self, name, self.nextID}
+}
+
+func (self *EventBus) Publish(event Event) {
+	self.mutex.RLock()
+	subscribers := self.handlers[event.EventName()]
+	self.mutex.RUnlock()
+
+	// the slice is never modified in place, so we can safely iterate over it
+	// without holding the lock, which allows handlers to (un)subscribe
+	for _, s := range subscribers {
+		s.handler(event)
+	}
+}
+
+func (self *Subscription) Unsubscribe() {
+	bus := self.bus
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	old := bus.handlers[self.name]
+	list := make([]subscriber, 0, len(old))
+
+	for _, s := range old {
+		if s.id != self.id {
+			list = append(list, s)
+		}
+	}
+
+	bus.handlers[self.name] = list
+}
+
+// events published by the bot itself
+
+const (
+	EventSetupComplete        = "bot.setup_complete"
+	EventPluginEnabled        = "channel.plugin_enabled"
+	EventPluginDisabled       = "channel.plugin_disabled"
+	EventUserTimedOut         = "channel.user_timed_out"
+	EventPermissionExpired    = "acl.permission_expired"
+	EventUserIdentified       = "users.identified"
+	EventDictionaryKeyUpdated = "dictionary.key_updated"
+	EventWorldRecordChanged   = "speedruncom.world_record_changed"
+	EventWorldRecordCommand   = "speedruncom.world_record_command"
+)
+
+// SetupCompleteEvent is published on the global bus once all plugins have been set up.
+type SetupCompleteEvent struct{}
+
+func (self SetupCompleteEvent) EventName() string { return EventSetupComplete }
+
+type PluginEnabledEvent struct {
+	Channel string
+	Plugin  string
+}
+
+func (self PluginEnabledEvent) EventName() string { return EventPluginEnabled }
+
+type PluginDisabledEvent struct {
+	Channel string
+	Plugin  string
+	Crashed bool // true if the plugin was disabled automatically
+}
+
+func (self PluginDisabledEvent) EventName() string { return EventPluginDisabled }
+
+type UserTimedOutEvent struct {
+	Channel  string
+	User     string
+	Duration int // seconds; 0 for bans or unknown durations
+}
+
+func (self UserTimedOutEvent) EventName() string { return EventUserTimedOut }
+
+// PermissionExpiredEvent is published when a temporary grant has run out.
+type PermissionExpiredEvent struct {
+	Channel    string
+	Permission string
+	UserIdent  string
+}
+
+func (self PermissionExpiredEvent) EventName() string { return EventPermissionExpired }
+
+// UserIdentifiedEvent is published on the global bus when a user ID has been seen
+// for the first time (PreviousLogin is empty) or under a new name.
+type UserIdentifiedEvent struct {
+	ID            int
+	Login         string
+	PreviousLogin string
+}
+
+func (self UserIdentifiedEvent) EventName() string { return EventUserIdentified }
+
+type DictionaryKeyUpdatedEvent struct {
+	Channel string // empty for global entries
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+func (self DictionaryKeyUpdatedEvent) EventName() string { return EventDictionaryKeyUpdated }
+
+type WorldRecordChangedEvent struct {
+	DictKey string
+	Record  string
+}
+
+func (self WorldRecordChangedEvent) EventName() string { return EventWorldRecordChanged }
+
+// WorldRecordCommandEvent announces a chat command that should print the world
+// record stored in the given dictionary key.
+type WorldRecordCommandEvent struct {
+	Command string
+	DictKey string
+}
+
+func (self WorldRecordCommandEvent) EventName() string { return EventWorldRecordCommand }
@@ -0,0 +1,121 @@
+package bot
+
+import "testing"
+
+type testEvent struct {
+	name  string
+	value int
+}
+
+func (self testEvent) EventName() string { return self.name }
+
+// a different type that shares its name with testEvent
+type otherTestEvent struct{}
+
+func (self otherTestEvent) EventName() string { return "test" }
+
+func TestEventBusMatchesEventsByName(t *testing.T) {
+	bus := NewEventBus()
+	received := make([]string, 0)
+
+	bus.Subscribe("test", func(e Event) { received = append(received, "first") })
+	bus.Subscribe("test", func(e Event) { received = append(received, "second") })
+	bus.Subscribe("other", func(e Event) { received = append(received, "other") })
+
+	bus.Publish(testEvent{"test", 1})
+	bus.Publish(otherTestEvent{})
+	bus.Publish(testEvent{"unknown", 2})
+
+	expected := []string{"first", "second", "first", "second"}
+
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %v, got %v.", expected, received)
+	}
+
+	for idx, name := range received {
+		if name != expected[idx] {
+			t.Fatalf("Expected %v, got %v.", expected, received)
+		}
+	}
+}
+
+func TestEventBusStopsDeliveringAfterUnsubscribing(t *testing.T) {
+	bus := NewEventBus()
+	first := 0
+	second := 0
+
+	subscription := bus.Subscribe("test", func(e Event) { first++ })
+	bus.Subscribe("test", func(e Event) { second++ })
+
+	bus.Publish(testEvent{"test", 1})
+	subscription.Unsubscribe()
+	bus.Publish(testEvent{"test", 2})
+
+	if first != 1 {
+		t.Errorf("Expected the unsubscribed handler to be called once, got %d calls.", first)
+	}
+
+	if second != 2 {
+		t.Errorf("Expected the remaining handler to be called twice, got %d calls.", second)
+	}
+}
+
+func TestEventBusAllowsUnsubscribingWhilePublishing(t *testing.T) {
+	bus := NewEventBus()
+	calls := 0
+	later := 0
+
+	var mine, other *Subscription
+
+	mine = bus.Subscribe("test", func(e Event) {
+		calls++
+
+		// this must not deadlock
+		mine.Unsubscribe()
+		other.Unsubscribe()
+		bus.Subscribe("test", func(e Event) { later++ })
+	})
+
+	other = bus.Subscribe("test", func(e Event) { calls++ })
+
+	// the ongoing publish still reaches everyone who was subscribed when it started
+	bus.Publish(testEvent{"test", 1})
+
+	if calls != 2 || later != 0 {
+		t.Fatalf("Expected both original handlers to be called, got %d calls and %d for the new handler.", calls, later)
+	}
+
+	bus.Publish(testEvent{"test", 2})
+
+	if calls != 2 || later != 1 {
+		t.Fatalf("Expected only the new handler to be called, got %d calls and %d for the new handler.", calls, later)
+	}
+}
+
+func TestChannelEventsArePublishedOnTheChannelAndTheBot(t *testing.T) {
+	botEvents := NewEventBus()
+	channel := &channelWorker{events: NewEventBus(), botEvents: botEvents}
+	other := &channelWorker{events: NewEventBus(), botEvents: botEvents}
+
+	inChannel := 0
+	inOther := 0
+	global := 0
+
+	channel.events.Subscribe(EventPluginEnabled, func(e Event) { inChannel++ })
+	other.events.Subscribe(EventPluginEnabled, func(e Event) { inOther++ })
+	botEvents.Subscribe(EventPluginEnabled, func(e Event) { global++ })
+
+	channel.publish(PluginEnabledEvent{"#chan", "test"})
+
+	if inChannel != 1 {
+		t.Errorf("Expected the channel's subscriber to be called once, got %d calls.", inChannel)
+	}
+
+	if inOther != 0 {
+		t.Errorf("Expected other channels not to see the event, got %d calls.", inOther)
+	}
+
+	if global != 1 {
+		t.Errorf("Expected the bot's subscriber to be called once, got %d calls.", global)
+	}
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A Flusher keeps changes in memory and writes them to the database when asked to.
+// Flush must be safe to call from any goroutine and keep the changes around to try
+// again later if they cannot be written.
+type Flusher interface {
+	Flush() error
+}
+
+// how often pending changes are written to the database
+const flushInterval = 5 * time.Minute
+
+// The FlushScheduler periodically flushes all registered flushers, so that plugins
+// do not need to run their own sync goroutines. When the bot shuts down, everything
+// is flushed one last time.
+type FlushScheduler struct {
+	log      Logger
+	flushers map[Flusher]string
+	mutex    sync.Mutex
+	task     *BackgroundTask
+}
+
+func NewFlushScheduler(interval time.Duration, log Logger) *FlushScheduler {
+	scheduler := &FlushScheduler{
+		log:      log,
+		flushers: make(map[Flusher]string),
+	}
+
+	scheduler.task = NewBackgroundTask(interval, scheduler.FlushAll, scheduler.FlushAll)
+
+	return scheduler
+}
+
+// Register adds a flusher; the name is only used for logging. Registering the same
+// flusher twice does nothing.
+func (self *FlushScheduler) Register(name string, flusher Flusher) {
+	self.mutex.Lock()
+	self.flushers[flusher] = name
+	self.mutex.Unlock()
+}
+
+// Unregister flushes the flusher one last time and removes it. This blocks until
+// the changes have been written (or failed to be).
+func (self *FlushScheduler) Unregister(flusher Flusher) {
+	self.mutex.Lock()
+	name, exists := self.flushers[flusher]
+	delete(self.flushers, flusher)
+	self.mutex.Unlock()
+
+	if exists {
+		self.flush(name, flusher)
+	}
+}
+
+// FlushAll flushes all registered flushers, one after another.
+func (self *FlushScheduler) FlushAll() {
+	self.mutex.Lock()
+
+	flushers := make(map[Flusher]string, len(self.flushers))
+	for flusher, name := range self.flushers {
+		flushers[flusher] = name
+	}
+
+	self.mutex.Unlock()
+
+	for flusher, name := range flushers {
+		self.flush(name, flusher)
+	}
+}
+
+func (self *FlushScheduler) Start(ctx context.Context) {
+	self.task.Start(ctx)
+}
+
+// Stop ends the periodic flushing and flushes everything one last time.
+func (self *FlushScheduler) Stop() {
+	self.task.Stop()
+}
+
+func (self *FlushScheduler) flush(name string, flusher Flusher) {
+	err := flusher.Flush()
+	if err != nil {
+		self.log.Error("Could not flush %s, will try again later: %s", name, err.Error())
+	}
+}
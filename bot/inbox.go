@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// the number of queued messages after which regular chat lines start to get dropped
+const inboxSize = 100
+
+// commands and control messages are kept beyond inboxSize, but not infinitely
+const inboxHardLimit = 1000
+
+// ChannelStats describes how well a channel worker keeps up with its chat.
+type ChannelStats struct {
+	Queued    int           // messages waiting to be handled
+	Received  uint64        // messages handed to the channel so far
+	Dropped   uint64        // regular chat lines thrown away because the channel was lagging
+	Coalesced uint64        // room state updates merged into an already queued one
+	Lag       time.Duration // time the most recent message spent waiting in the queue
+	MaxLag    time.Duration
+}
+
+type inboxItem struct {
+	message  twitch.IncomingMessage
+	received time.Time
+}
+
+// The inbox sits between the bot's receiving loop and a channel worker. Pushing a
+// message never blocks, so a channel with slow plugins cannot stall the others.
+// Instead, when the queue fills up, regular chat lines are dropped (oldest first)
+// and room state updates are coalesced. Commands and control messages like PART
+// are never dropped (up to inboxHardLimit) and the order of messages is preserved.
+type inbox struct {
+	mutex sync.Mutex
+	queue []inboxItem
+	ready chan struct{}
+	stats ChannelStats
+}
+
+func newInbox() *inbox {
+	return &inbox{
+		queue: make([]inboxItem, 0, inboxSize),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Ready fires whenever there is at least one message waiting to be popped.
+func (self *inbox) Ready() <-chan struct{} {
+	return self.ready
+}
+
+func (self *inbox) Push(msg twitch.IncomingMessage) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.stats.Received++
+
+	if self.coalesce(msg) {
+		self.stats.Coalesced++
+		return
+	}
+
+	if len(self.queue) >= inboxSize && !self.evict() {
+		// there are only important messages queued
+		if isDroppable(msg) || (len(self.queue) >= inboxHardLimit && !isControlMessage(msg)) {
+			self.stats.Dropped++
+			return
+		}
+	}
+
+	self.queue = append(self.queue, inboxItem{msg, time.Now()})
+	self.notify()
+}
+
+// Pop returns the oldest queued message, if any.
+func (self *inbox) Pop() (twitch.IncomingMessage, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if len(self.queue) == 0 {
+		return nil, false
+	}
+
+	item := self.queue[0]
+	self.queue[0] = inboxItem{}
+	self.queue = self.queue[1:]
+
+	self.stats.Lag = time.Since(item.received)
+	if self.stats.Lag > self.stats.MaxLag {
+		self.stats.MaxLag = self.stats.Lag
+	}
+
+	// re-arm the signal, so the worker comes back for the rest
+	if len(self.queue) > 0 {
+		self.notify()
+	}
+
+	return item.message, true
+}
+
+func (self *inbox) Stats() ChannelStats {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	stats := self.stats
+	stats.Queued = len(self.queue)
+
+	return stats
+}
+
+func (self *inbox) notify() {
+	select {
+	case self.ready <- struct{}{}:
+	default:
+	}
+}
+
+// evict removes the oldest droppable message from the queue
+func (self *inbox) evict() bool {
+	for idx, item := range self.queue {
+		if isDroppable(item.message) {
+			self.queue = append(self.queue[:idx], self.queue[(idx+1):]...)
+			self.stats.Dropped++
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// coalesce replaces a queued room state with a newer one; only the most recent
+// state of the room is interesting anyway.
+func (self *inbox) coalesce(msg twitch.IncomingMessage) bool {
+	state, okay := msg.(twitch.RoomStateMessage)
+	if !okay || state.IsNotice {
+		return false
+	}
+
+	for idx, item := range self.queue {
+		queued, okay := item.message.(twitch.RoomStateMessage)
+		if okay && !queued.IsNotice {
+			self.queue[idx].message = state
+			return true
+		}
+	}
+
+	return false
+}
+
+// regular chat lines can be dropped when a channel lags behind, commands cannot
+func isDroppable(msg twitch.IncomingMessage) bool {
+	text, okay := msg.(TextMessage)
+
+	return okay && text.Command() == ""
+}
+
+func isControlMessage(msg twitch.IncomingMessage) bool {
+	switch msg.(type) {
+	case twitch.JoinMessage, twitch.PartMessage:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+func chatLine(text string) TextMessage {
+	return TextMessage{TextMessage: twitch.TextMessage{Channel: "#chan", Text: text}}
+}
+
+func drain(box *inbox) []twitch.IncomingMessage {
+	messages := make([]twitch.IncomingMessage, 0)
+
+	for {
+		msg, okay := box.Pop()
+		if !okay {
+			return messages
+		}
+
+		messages = append(messages, msg)
+	}
+}
+
+func TestInboxDropsTheOldestChatLinesFirst(t *testing.T) {
+	box := newInbox()
+
+	for i := 0; i < inboxSize; i++ {
+		box.Push(chatLine("hello"))
+	}
+
+	box.Push(chatLine("!cmd"))
+	box.Push(twitch.PartMessage{Channel: "#chan"})
+	box.Push(chatLine("latest"))
+
+	stats := box.Stats()
+
+	if stats.Queued != inboxSize {
+		t.Fatalf("Expected %d queued messages, got %d.", inboxSize, stats.Queued)
+	}
+
+	if stats.Dropped != 3 {
+		t.Fatalf("Expected 3 dropped messages, got %d.", stats.Dropped)
+	}
+
+	if stats.Received != inboxSize+3 {
+		t.Fatalf("Expected %d received messages, got %d.", inboxSize+3, stats.Received)
+	}
+
+	messages := drain(box)
+	tail := messages[len(messages)-3:]
+
+	if cmd, okay := tail[0].(TextMessage); !okay || cmd.Text != "!cmd" {
+		t.Fatalf("Expected the command to be kept in order, got %#v.", tail[0])
+	}
+
+	if _, okay := tail[1].(twitch.PartMessage); !okay {
+		t.Fatalf("Expected the PART to be kept in order, got %#v.", tail[1])
+	}
+
+	if line, okay := tail[2].(TextMessage); !okay || line.Text != "latest" {
+		t.Fatalf("Expected the most recent chat line to be kept, got %#v.", tail[2])
+	}
+}
+
+func TestInboxKeepsCommandsAboveTheSoftLimit(t *testing.T) {
+	box := newInbox()
+
+	for i := 0; i < inboxSize*2; i++ {
+		box.Push(chatLine("!cmd"))
+	}
+
+	box.Push(chatLine("hello"))
+
+	stats := box.Stats()
+
+	if stats.Queued != inboxSize*2 {
+		t.Fatalf("Expected all %d commands to be queued, got %d.", inboxSize*2, stats.Queued)
+	}
+
+	if stats.Dropped != 1 {
+		t.Fatalf("Expected the chat line to be dropped, got %d dropped messages.", stats.Dropped)
+	}
+
+	for _, msg := range drain(box) {
+		if isDroppable(msg) {
+			t.Fatal("Expected the chat line not to be queued behind the commands.")
+		}
+	}
+}
+
+func TestInboxEnforcesTheHardLimit(t *testing.T) {
+	box := newInbox()
+
+	for i := 0; i < inboxHardLimit; i++ {
+		box.Push(chatLine("!cmd"))
+	}
+
+	box.Push(chatLine("!one_too_many"))
+	box.Push(twitch.PartMessage{Channel: "#chan"})
+
+	stats := box.Stats()
+
+	if stats.Queued != inboxHardLimit+1 {
+		t.Fatalf("Expected %d queued messages, got %d.", inboxHardLimit+1, stats.Queued)
+	}
+
+	if stats.Dropped != 1 {
+		t.Fatalf("Expected the command beyond the hard limit to be dropped, got %d dropped messages.", stats.Dropped)
+	}
+
+	messages := drain(box)
+
+	if _, okay := messages[len(messages)-1].(twitch.PartMessage); !okay {
+		t.Fatal("Expected the PART to be queued beyond the hard limit.")
+	}
+}
+
+func TestInboxCoalescesRoomStates(t *testing.T) {
+	box := newInbox()
+
+	box.Push(twitch.RoomStateMessage{Channel: "#chan", SlowMode: twitch.Enabled})
+	box.Push(chatLine("hello"))
+	box.Push(twitch.RoomStateMessage{Channel: "#chan", IsNotice: true})
+	box.Push(twitch.RoomStateMessage{Channel: "#chan", SubsOnly: twitch.Enabled})
+
+	stats := box.Stats()
+
+	if stats.Queued != 3 {
+		t.Fatalf("Expected 3 queued messages, got %d.", stats.Queued)
+	}
+
+	if stats.Coalesced != 1 {
+		t.Fatalf("Expected 1 coalesced message, got %d.", stats.Coalesced)
+	}
+
+	messages := drain(box)
+
+	state, okay := messages[0].(twitch.RoomStateMessage)
+	if !okay || state.SubsOnly != twitch.Enabled {
+		t.Fatalf("Expected the queued room state to be replaced by the newer one, got %#v.", messages[0])
+	}
+
+	if notice, okay := messages[2].(twitch.RoomStateMessage); !okay || !notice.IsNotice {
+		t.Fatalf("Expected the notice to be kept, got %#v.", messages[2])
+	}
+}
+
+func TestInboxNeverDropsCommandsUnderLoad(t *testing.T) {
+	box := newInbox()
+
+	const pushers = 4
+	const perPusher = 500
+
+	pushed := sync.WaitGroup{}
+	pushed.Add(pushers)
+
+	for i := 0; i < pushers; i++ {
+		go func() {
+			defer pushed.Done()
+
+			for j := 0; j < perPusher; j++ {
+				if j%10 == 0 {
+					box.Push(chatLine("!cmd"))
+				} else {
+					box.Push(chatLine("hello"))
+				}
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	popped := 0
+	commands := 0
+
+	consume := func() {
+		for {
+			msg, okay := box.Pop()
+			if !okay {
+				return
+			}
+
+			popped++
+
+			if !isDroppable(msg) {
+				commands++
+			}
+		}
+	}
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-box.Ready():
+				consume()
+
+			case <-stop:
+				consume()
+				return
+			}
+		}
+	}()
+
+	pushed.Wait()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The inbox was not drained in time.")
+	}
+
+	stats := box.Stats()
+
+	if stats.Received != pushers*perPusher {
+		t.Fatalf("Expected %d received messages, got %d.", pushers*perPusher, stats.Received)
+	}
+
+	if uint64(popped)+stats.Dropped != stats.Received {
+		t.Fatalf("Expected every message to be either popped or dropped, got %d popped and %d dropped.", popped, stats.Dropped)
+	}
+
+	if commands != pushers*perPusher/10 {
+		t.Fatalf("Expected all %d commands to be popped, got %d.", pushers*perPusher/10, commands)
+	}
+}
@@ -1,13 +1,14 @@
 package bot
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/twitch"
 )
 
@@ -18,12 +19,27 @@ type Kabukibot struct {
 	plugins       []Plugin
 	logger        Logger
 	dictionary    *Dictionary
-	database      *sqlx.DB
+	users         *UserDirectory
+	admins        *Admins
+	operatorID    int // only to be used in Work()
+	events        *EventBus
+	flusher       *FlushScheduler
+	metrics       *Metrics
+	settings      map[string][]Setting // the per-channel settings of all plugins
+	database      Database
 	configuration *Configuration
+	configMutex   sync.RWMutex
+	reloadMutex   sync.Mutex
 	alive         chan struct{}
+	stopping      int32 // set atomically once the shutdown has begun
+	ctx           context.Context
+	cancel        context.CancelFunc
 }
 
-func NewKabukibot(client twitch.Client, log Logger, db *sqlx.DB, config *Configuration) (*Kabukibot, error) {
+// how long to wait for queued messages to be sent when shutting down
+const shutdownTimeout = 10 * time.Second
+
+func NewKabukibot(client twitch.Client, log Logger, db Database, config *Configuration) (*Kabukibot, error) {
 	// create the bot
 	bot := Kabukibot{}
 	bot.database = db
@@ -33,15 +49,56 @@ func NewKabukibot(client twitch.Client, log Logger, db *sqlx.DB, config *Configu
 	bot.logger = log
 	bot.twitch = client
 	bot.alive = make(chan struct{})
+	bot.events = NewEventBus()
+	bot.flusher = NewFlushScheduler(flushInterval, log.Subsystem("flush"))
+	bot.metrics = NewMetrics()
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
 
 	return &bot, nil
 }
 
 func (bot *Kabukibot) Connect() error {
+	// make sure the database is up to date
+	pending, err := NewMigrator(bot.database, bot.plugins).Pending()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("The database schema is outdated (%d pending migrations, starting with %s). Run the `migrate up` command first.", len(pending), pending[0])
+	}
+
 	// load dictionary elements
 	bot.logger.Debug("Loading dictionary...")
-	bot.dictionary = NewDictionary(bot.database, bot.logger)
-	bot.dictionary.load()
+	bot.dictionary = NewDictionary(bot.database, bot.logger.Subsystem("dictionary"), bot.events)
+
+	err = bot.dictionary.load()
+	if err != nil {
+		return fmt.Errorf("Could not load the dictionary: %s", err.Error())
+	}
+
+	err = bot.metrics.loadReconnects(bot.database)
+	if err != nil {
+		return fmt.Errorf("Could not load the counters: %s", err.Error())
+	}
+
+	bot.logger.Debug("Loading users...")
+	bot.users = NewUserDirectory(bot.database, bot.logger.Subsystem("users"), bot.events)
+
+	err = bot.users.load()
+	if err != nil {
+		return fmt.Errorf("Could not load users: %s", err.Error())
+	}
+
+	bot.users.Start()
+
+	bot.admins = NewAdmins(bot.database, bot.logger.Subsystem("admins"), bot.users)
+
+	err = bot.admins.load()
+	if err != nil {
+		return fmt.Errorf("Could not load bot admins: %s", err.Error())
+	}
+	bot.events.Subscribe(EventUserIdentified, bot.admins.onUserIdentified)
 
 	// setup plugins
 	bot.logger.Debug("Setting up plugins...")
@@ -49,32 +106,52 @@ func (bot *Kabukibot) Connect() error {
 		plugin.Setup(bot)
 	}
 
+	bot.settings = collectSettings(bot.plugins)
+
+	// now plugins can start talking to each other
+	bot.events.Publish(SetupCompleteEvent{})
+
+	bot.flusher.Start(bot.ctx)
+
 	// connect to Twitch
 	client := bot.twitch
 
-	bot.logger.Info("Connecting to Twitch chat @ %s:%d...", bot.configuration.IRC.Host, bot.configuration.IRC.Port)
-	err := client.Connect()
+	bot.logger.Info("Connecting to Twitch chat @ %s:%d...", bot.Configuration().IRC.Host, bot.Configuration().IRC.Port)
+	err = client.Connect()
 	if err != nil {
 		return err
 	}
 
 	// wait for the ready signal
-	<-client.Ready()
-	bot.logger.Info("Connection established.")
+	select {
+	case <-client.Ready():
+		bot.logger.Info("Connection established.")
+
+	case <-client.Alive():
+		return errors.New("The connection to Twitch has been closed before it was ready.")
+	}
 
 	return nil
 }
 
-func (bot *Kabukibot) Shutdown() {
+// Shutdown stops accepting commands, lets all plugins save their state, leaves all
+// channels and disconnects. An error is returned if not all queued messages could
+// be sent before disconnecting.
+func (bot *Kabukibot) Shutdown() error {
+	atomic.StoreInt32(&bot.stopping, 1)
+
 	// shutdown all channel workers
 	bot.channelMutex.Lock()
 
 	bot.logger.Info("Beginning shutdown procedure...")
 
+	channels := make([]string, 0, len(bot.workers))
+
 	wg := sync.WaitGroup{}
 	wg.Add(len(bot.workers))
 
-	for _, worker := range bot.workers {
+	for channel, worker := range bot.workers {
+		channels = append(channels, channel)
 		signal := worker.Shutdown()
 
 		go func() {
@@ -86,22 +163,73 @@ func (bot *Kabukibot) Shutdown() {
 	wg.Wait()
 	bot.channelMutex.Unlock()
 
+	// stop whatever the plugins do on their own
+	for _, plugin := range bot.plugins {
+		if stoppable, okay := plugin.(stoppablePlugin); okay {
+			stoppable.Stop()
+		}
+	}
+
+	// store the users that have been seen last
+	bot.users.Stop()
+
+	// write everything the workers did not flush themselves
+	bot.flusher.Stop()
+
+	// stop whatever might still be running in the background
+	bot.cancel()
+
 	bot.logger.Info("All channel workers have shut down.")
 
-	// disconnect from IRC;
-	// This will close the twitch client's incoming channel and hence stop .Work(),
-	// which will close self.alive eventually.
-	bot.logger.Info("Disconnecting from IRC...")
-	bot.twitch.Disconnect()
+	var err error
+
+	select {
+	case <-bot.alive:
+		bot.logger.Warning("The connection has already been lost, cannot leave the channels.")
+
+	default:
+		// the PART confirmations are not awaited, the workers are gone already
+		for _, channel := range channels {
+			bot.twitch.Send(twitch.PartMessage{channel})
+		}
+
+		err = bot.drainQueue(shutdownTimeout)
+		if err != nil {
+			bot.logger.Error("%s", err.Error())
+		}
+
+		// disconnect from IRC;
+		// This will close the twitch client's incoming channel and hence stop .Work(),
+		// which will close self.alive eventually.
+		bot.logger.Info("Disconnecting from IRC...")
+		bot.twitch.Disconnect()
+	}
 
 	<-bot.alive
 	bot.logger.Info("It's dead, Jim.")
+
+	return err
+}
+
+// drainQueue waits until all outgoing messages have been sent.
+func (bot *Kabukibot) drainQueue(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for bot.twitch.QueueLen() > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Gave up waiting for %d queued messages to be sent.", bot.twitch.QueueLen())
+		}
+
+		<-time.After(50 * time.Millisecond)
+	}
+
+	return nil
 }
 
 func (bot *Kabukibot) Work() {
 	go bot.joinInitialChannels()
 
-	prefix := bot.configuration.CommandPrefix
+	prefix := bot.Configuration().CommandPrefix
 	operator := bot.OpUsername()
 
 	for msg := range bot.twitch.Incoming() {
@@ -112,16 +240,36 @@ func (bot *Kabukibot) Work() {
 		worker, exists := bot.workers[channel]
 		bot.channelMutex.Unlock()
 
+		var identified <-chan struct{}
+
+		asserted, okay := msg.(twitch.TextMessage)
+		if okay {
+			// do not start working on new commands while shutting down
+			if atomic.LoadInt32(&bot.stopping) == 1 {
+				continue
+			}
+
+			identified = bot.users.Observe(asserted.User)
+		}
+
+		// delivering never blocks, so one lagging channel cannot hold up the others
 		if exists {
-			asserted, okay := msg.(twitch.TextMessage)
 			if okay {
-				worker.Input() <- TextMessage{asserted, prefix, operator, false}
+				worker.Deliver(TextMessage{asserted, prefix, operator, bot.resolveOperatorID(), bot.admins, false, identified})
 			} else {
-				worker.Input() <- msg
+				worker.Deliver(msg)
 			}
 		}
 	}
 
+	// the connection was lost without shutting down
+	if atomic.LoadInt32(&bot.stopping) == 0 {
+		err := bot.metrics.connectionLost(bot.database)
+		if err != nil {
+			bot.logger.Error("Could not count the lost connection: %s", err.Error())
+		}
+	}
+
 	// we're dead now
 	close(bot.alive)
 }
@@ -130,22 +278,47 @@ func (bot *Kabukibot) Alive() <-chan struct{} {
 	return bot.alive
 }
 
+// Context is done when the bot shuts down.
+func (bot *Kabukibot) Context() context.Context {
+	return bot.ctx
+}
+
+// Configuration returns the current configuration, which is replaced (not changed)
+// when it is reloaded.
 func (bot *Kabukibot) Configuration() *Configuration {
+	bot.configMutex.RLock()
+	defer bot.configMutex.RUnlock()
+
 	return bot.configuration
 }
 
-func (bot *Kabukibot) Database() *sqlx.DB {
+func (bot *Kabukibot) Database() Database {
 	return bot.database
 }
 
+// Flusher periodically writes pending changes of plugins to the database.
+func (bot *Kabukibot) Flusher() *FlushScheduler {
+	return bot.flusher
+}
+
 func (bot *Kabukibot) Logger() Logger {
 	return bot.logger
 }
 
+// PluginLogger returns a logger for the "plugin.<name>" subsystem.
+func (bot *Kabukibot) PluginLogger(name string) Logger {
+	return bot.logger.Subsystem("plugin." + name).With(Fields{"plugin": name})
+}
+
 func (bot *Kabukibot) Dictionary() *Dictionary {
 	return bot.dictionary
 }
 
+// Events returns the global event bus; see Channel.Events() for channel-scoped events.
+func (bot *Kabukibot) Events() *EventBus {
+	return bot.events
+}
+
 func (bot *Kabukibot) Channel(name string) (Channel, error) {
 	bot.channelMutex.Lock()
 	defer bot.channelMutex.Unlock()
@@ -173,6 +346,19 @@ func (bot *Kabukibot) Channels() []string {
 	return result
 }
 
+func (bot *Kabukibot) ChannelStats() map[string]ChannelStats {
+	bot.channelMutex.Lock()
+	defer bot.channelMutex.Unlock()
+
+	result := make(map[string]ChannelStats)
+
+	for cn, worker := range bot.workers {
+		result[cn] = worker.Stats()
+	}
+
+	return result
+}
+
 func (bot *Kabukibot) AddPlugin(plugin Plugin) {
 	bot.plugins = append(bot.plugins, plugin)
 }
@@ -208,7 +394,7 @@ func (bot *Kabukibot) Join(channel string) <-chan bool {
 	bot.channelMutex.Unlock()
 
 	// remember that we joined
-	bot.Database().Exec("INSERT INTO channel (name) VALUES (?)", channel)
+	bot.rememberChannel(channel)
 
 	// go have fun
 	go worker.Work()
@@ -241,7 +427,10 @@ func (bot *Kabukibot) Part(channel string) <-chan bool {
 
 	bot.logger.Info("Leaving %s...", channel)
 
-	bot.Database().Exec("DELETE FROM channel WHERE name = ?", channel)
+	_, err := bot.Database().Exec("DELETE FROM channel WHERE name = ?", channel)
+	if err != nil {
+		bot.logger.Error("Could not forget channel %s, it will be joined again after a restart: %s", channel, err.Error())
+	}
 
 	// send off the request to leave the channel, but wait for its confirmation
 	// to shutdown our worker; this signal therefore does not represent the
@@ -249,6 +438,22 @@ func (bot *Kabukibot) Part(channel string) <-chan bool {
 	return bot.twitch.Send(twitch.PartMessage{channel})
 }
 
+// rememberChannel stores the channel, so that it is joined again after a restart.
+// Failing to do so is not worth aborting the join.
+func (bot *Kabukibot) rememberChannel(channel string) {
+	db := bot.Database()
+	known := 0
+
+	err := db.Get(&known, "SELECT COUNT(*) FROM channel WHERE name = ?", channel)
+	if err == nil && known == 0 {
+		_, err = db.Exec("INSERT INTO channel (name) VALUES (?)", channel)
+	}
+
+	if err != nil {
+		bot.logger.Error("Could not store channel %s, it will not be joined after a restart: %s", channel, err.Error())
+	}
+}
+
 func (bot *Kabukibot) Joined(channel string) bool {
 	bot.channelMutex.Lock()
 	_, joined := bot.workers[channel]
@@ -258,11 +463,33 @@ func (bot *Kabukibot) Joined(channel string) bool {
 }
 
 func (bot *Kabukibot) BotUsername() string {
-	return bot.configuration.Account.Username
+	return bot.Configuration().Account.Username
 }
 
 func (bot *Kabukibot) OpUsername() string {
-	return bot.configuration.Operator
+	return bot.Configuration().Operator
+}
+
+// resolveOperatorID binds the operator to their user ID once they have been seen, so
+// that someone taking over the operator's name after a rename is not trusted.
+func (bot *Kabukibot) resolveOperatorID() int {
+	if bot.operatorID == 0 {
+		id, ok := bot.users.IDByName(bot.OpUsername())
+		if ok {
+			bot.operatorID = id
+			bot.logger.Info("The operator %s has the user ID %d.", bot.OpUsername(), id)
+		}
+	}
+
+	return bot.operatorID
+}
+
+func (bot *Kabukibot) Users() *UserDirectory {
+	return bot.users
+}
+
+func (bot *Kabukibot) Admins() *Admins {
+	return bot.admins
 }
 
 func (bot *Kabukibot) IsBot(username string) bool {
@@ -285,6 +512,14 @@ func (bot *Kabukibot) MessagesReceived() uint64 {
 	return bot.twitch.MessagesReceived()
 }
 
+func (bot *Kabukibot) MessagesDropped() uint64 {
+	return bot.twitch.MessagesDropped()
+}
+
+func (bot *Kabukibot) Metrics() *Metrics {
+	return bot.metrics
+}
+
 type initialChannel struct {
 	Name string `db:"name"`
 }
@@ -297,7 +532,10 @@ func (bot *Kabukibot) joinInitialChannels() {
 	list := make([]initialChannel, 0)
 	db := bot.Database()
 
-	db.Select(&list, "SELECT name FROM channel ORDER BY name")
+	err := db.Select(&list, "SELECT name FROM channel ORDER BY name")
+	if err != nil {
+		bot.logger.Error("Could not query the previously joined channels: %s", err.Error())
+	}
 
 	for _, channel := range list {
 		<-bot.Join(channel.Name)
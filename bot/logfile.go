@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// A RotatingFile is a log file that is moved to file.1 (and file.1 to file.2 and
+// so on) once it would grow beyond its maximum size. A maximum size of 0 disables
+// the rotation.
+type RotatingFile struct {
+	filename string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	mutex    sync.Mutex
+}
+
+func OpenRotatingFile(filename string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	self := &RotatingFile{
+		filename: filename,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	err := self.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return self, nil
+}
+
+func (self *RotatingFile) open() error {
+	file, err := os.OpenFile(self.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	self.file = file
+	self.size = info.Size()
+
+	return nil
+}
+
+func (self *RotatingFile) Write(data []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	// if rotating fails, the message is still written to the old file
+	if self.maxSize > 0 && self.size > 0 && self.size+int64(len(data)) > self.maxSize {
+		self.rotate()
+	}
+
+	written, err := self.file.Write(data)
+	self.size += int64(written)
+
+	return written, err
+}
+
+// rotate moves the files and opens a new one. Without rotated files to keep, the
+// file is simply emptied. If the new file cannot be opened, the old one (now file.1)
+// stays open and rotating is tried again once it has grown by the maximum size.
+func (self *RotatingFile) rotate() error {
+	if self.maxFiles <= 0 {
+		err := self.file.Truncate(0)
+		if err == nil {
+			self.size = 0
+		}
+
+		return err
+	}
+
+	// drop the oldest file and move all others one up
+	os.Remove(fmt.Sprintf("%s.%d", self.filename, self.maxFiles))
+
+	for idx := self.maxFiles - 1; idx > 0; idx-- {
+		os.Rename(fmt.Sprintf("%s.%d", self.filename, idx), fmt.Sprintf("%s.%d", self.filename, idx+1))
+	}
+
+	// the open file can still be written to after it has been moved
+	os.Rename(self.filename, self.filename+".1")
+
+	old := self.file
+
+	err := self.open()
+	if err != nil {
+		self.size = 0
+		return err
+	}
+
+	return old.Close()
+}
+
+func (self *RotatingFile) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.file.Close()
+}
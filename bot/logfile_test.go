@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempLogDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kabukibot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func expectFile(t *testing.T, filename string, content string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Could not read %s: %s", filepath.Base(filename), err)
+		return
+	}
+
+	if string(data) != content {
+		t.Errorf("Expected %s to contain %q, got %q.", filepath.Base(filename), content, string(data))
+	}
+}
+
+func TestRotatingFileKeepsTheNewestFiles(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "bot.log")
+
+	file, err := OpenRotatingFile(filename, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		file.Write([]byte(line))
+	}
+
+	file.Close()
+
+	expectFile(t, filename, "fourth\n")
+	expectFile(t, filename+".1", "third\n")
+	expectFile(t, filename+".2", "second\n")
+
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Error("Only two rotated files should have been kept.")
+	}
+}
+
+func TestRotatingFileWithoutRotatedFilesIsEmptied(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "bot.log")
+
+	file, err := OpenRotatingFile(filename, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("first\n"))
+	file.Write([]byte("second\n"))
+	file.Close()
+
+	expectFile(t, filename, "second\n")
+}
+
+func TestRotatingFileContinuesWhenReopeningFails(t *testing.T) {
+	dir := tempLogDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "bot.log")
+
+	file, err := OpenRotatingFile(filename, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("first\n"))
+
+	// directories in place of the files make moving and opening them fail
+	file.filename = filepath.Join(dir, "blocked")
+	os.MkdirAll(filepath.Join(file.filename+".1", "full"), 0755)
+	os.Mkdir(file.filename, 0755)
+
+	_, err = file.Write([]byte("second\n"))
+	if err != nil {
+		t.Errorf("Expected the line to be written to the old file, got '%s'.", err)
+	}
+
+	file.Close()
+
+	expectFile(t, filename, "first\nsecond\n")
+}
@@ -1,10 +1,16 @@
 package bot
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
-import "os"
 
 const (
 	LogLevelDebug = iota
@@ -13,9 +19,33 @@ const (
 	LogLevelError
 )
 
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+// ParseLogLevel turns "debug", "info", "warning" or "error" into a log level.
+func ParseLogLevel(name string) (int, error) {
+	for level, levelName := range logLevelNames {
+		if strings.ToLower(name) == levelName {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid log level '%s', must be one of %s.", name, strings.Join(logLevelNames, ", "))
+}
+
+// Fields are added to log lines to tell what they are about, like the channel,
+// plugin or user.
+type Fields map[string]interface{}
+
 type Logger interface {
 	SetLevel(int)
 
+	// With returns a logger that adds the fields to every line.
+	With(Fields) Logger
+
+	// Subsystem returns a logger for a part of the bot, like "twitch" or "plugin.log",
+	// whose level can be configured separately.
+	Subsystem(string) Logger
+
 	Debug(string, ...interface{})
 	Info(string, ...interface{})
 	Warning(string, ...interface{})
@@ -23,43 +53,203 @@ type Logger interface {
 	Fatal(string, ...interface{})
 }
 
+type LogOptions struct {
+	Level  int
+	Levels map[string]int // per subsystem; "plugin" applies to "plugin.log" as well
+	JSON   bool
+	Output io.Writer // stdout if not set
+}
+
+// logSink is shared by a logger and all loggers derived from it.
+type logSink struct {
+	output io.Writer
+	json   bool
+	level  int
+	levels map[string]int
+	mutex  sync.Mutex
+}
+
 type logger struct {
-	level int
+	sink      *logSink
+	subsystem string
+	fields    Fields
 }
 
-func NewLogger(level int) Logger {
-	return &logger{level}
+func NewLogger(options LogOptions) Logger {
+	sink := &logSink{
+		output: options.Output,
+		json:   options.JSON,
+		level:  options.Level,
+		levels: make(map[string]int),
+	}
+
+	if sink.output == nil {
+		sink.output = os.Stdout
+	}
+
+	for subsystem, level := range options.Levels {
+		sink.levels[subsystem] = level
+	}
+
+	return &logger{sink: sink, fields: Fields{}}
 }
 
 func (self *logger) SetLevel(level int) {
-	self.level = level
+	self.sink.mutex.Lock()
+	self.sink.level = level
+	self.sink.mutex.Unlock()
+}
+
+func (self *logger) With(fields Fields) Logger {
+	merged := make(Fields, len(self.fields)+len(fields))
+
+	for key, value := range self.fields {
+		merged[key] = value
+	}
+
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return &logger{self.sink, self.subsystem, merged}
+}
+
+func (self *logger) Subsystem(name string) Logger {
+	return &logger{self.sink, name, self.fields}
 }
 
 func (self *logger) Debug(format string, args ...interface{}) {
-	self.printLine(LogLevelDebug, "[d] "+format, args...)
+	self.log(LogLevelDebug, "d", format, args)
 }
 
 func (self *logger) Info(format string, args ...interface{}) {
-	self.printLine(LogLevelInfo, "[i] "+format, args...)
+	self.log(LogLevelInfo, "i", format, args)
 }
 
 func (self *logger) Warning(format string, args ...interface{}) {
-	self.printLine(LogLevelWarning, "[W] "+format, args...)
+	self.log(LogLevelWarning, "W", format, args)
 }
 
 func (self *logger) Error(format string, args ...interface{}) {
-	self.printLine(LogLevelError, "[!] "+format, args...)
+	self.log(LogLevelError, "!", format, args)
 }
 
 func (self *logger) Fatal(format string, args ...interface{}) {
-	self.printLine(LogLevelError, "[F] "+format, args...)
+	self.log(LogLevelError, "F", format, args)
 	os.Exit(1)
 }
 
-func (self *logger) printLine(level int, format string, args ...interface{}) {
-	if level >= self.level {
-		line := fmt.Sprintf("[%s] %s\n", time.Now().Format(time.RFC1123), format)
+func (self *logger) log(level int, marker string, format string, args []interface{}) {
+	if !self.sink.enabled(level, self.subsystem) {
+		return
+	}
+
+	// only format when there is something to format, so that messages containing
+	// user input or errors are logged as they are
+	message := format
+	if len(args) > 0 {
+		message = fmt.Sprintf(format, args...)
+	}
+
+	now := time.Now()
+	var line string
+
+	if self.sink.json {
+		line = self.jsonLine(now, level, marker, message)
+	} else {
+		line = self.textLine(now, marker, message)
+	}
+
+	self.sink.write(line)
+}
+
+func (self *logger) textLine(now time.Time, marker string, message string) string {
+	line := fmt.Sprintf("[%s] [%s] ", now.Format(time.RFC1123), marker)
+
+	if self.subsystem != "" {
+		line += "[" + self.subsystem + "] "
+	}
+
+	line += message
+
+	keys := make([]string, 0, len(self.fields))
+
+	for key := range self.fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fmt.Sprintf("%v", self.fields[key])
+
+		if value == "" || strings.ContainsAny(value, " =\"") {
+			value = strconv.Quote(value)
+		}
+
+		line += " " + key + "=" + value
+	}
+
+	return line + "\n"
+}
+
+func (self *logger) jsonLine(now time.Time, level int, marker string, message string) string {
+	data := make(map[string]interface{}, len(self.fields)+4)
+
+	for key, value := range self.fields {
+		data[key] = value
+	}
+
+	data["time"] = now.Format(time.RFC3339)
+	data["level"] = logLevelNames[level]
+	data["message"] = message
+
+	if marker == "F" {
+		data["level"] = "fatal"
+	}
+
+	if self.subsystem != "" {
+		data["subsystem"] = self.subsystem
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		// fields that cannot be encoded are not worth losing the message
+		encoded, _ = json.Marshal(map[string]string{
+			"time":    data["time"].(string),
+			"level":   data["level"].(string),
+			"message": message,
+		})
+	}
+
+	return string(encoded) + "\n"
+}
+
+// enabled checks the level of the most specific configured subsystem.
+func (self *logSink) enabled(level int, subsystem string) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for name := subsystem; name != ""; {
+		threshold, exists := self.levels[name]
+		if exists {
+			return level >= threshold
+		}
+
+		idx := strings.LastIndex(name, ".")
+		if idx < 0 {
+			break
+		}
 
-		fmt.Printf(line, args...)
+		name = name[:idx]
 	}
+
+	return level >= self.level
+}
+
+func (self *logSink) write(line string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	io.WriteString(self.output, line)
 }
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLoggerWritesJSON(t *testing.T) {
+	buffer := bytes.Buffer{}
+	log := NewLogger(LogOptions{Level: LogLevelInfo, JSON: true, Output: &buffer})
+
+	log.Subsystem("plugin.log").With(Fields{"channel": "#chan", "user": "bob"}).Warning("Could not write %s.", "chan.log")
+	log.With(Fields{"broken": func() {}}).Error("Still here.")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q.", buffer.String())
+	}
+
+	first := make(map[string]string)
+
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("Could not decode '%s': %s", lines[0], err)
+	}
+
+	expected := map[string]string{"level": "warning", "subsystem": "plugin.log", "message": "Could not write chan.log.", "channel": "#chan", "user": "bob"}
+
+	for key, value := range expected {
+		if first[key] != value {
+			t.Errorf("Expected %s to be '%s', got '%s'.", key, value, first[key])
+		}
+	}
+
+	if first["time"] == "" {
+		t.Error("The line has no time.")
+	}
+
+	// fields that cannot be encoded are dropped, but the message is kept
+	second := make(map[string]string)
+
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil || second["message"] != "Still here." || second["level"] != "error" {
+		t.Errorf("Expected the message to survive a broken field, got '%s'.", lines[1])
+	}
+}
+
+func TestLoggerLevelsPerSubsystem(t *testing.T) {
+	buffer := bytes.Buffer{}
+	log := NewLogger(LogOptions{
+		Level:  LogLevelWarning,
+		Levels: map[string]int{"twitch": LogLevelError, "plugin": LogLevelDebug, "plugin.log": LogLevelInfo},
+		Output: &buffer,
+	})
+
+	log.Info("global info")
+	log.Warning("global warning")
+	log.Subsystem("twitch").Warning("twitch warning")
+	log.Subsystem("twitch").Error("twitch error")
+	log.Subsystem("plugin.ping").Debug("ping debug")
+	log.Subsystem("plugin.log").Debug("log debug")
+	log.Subsystem("plugin.log").Info("log info")
+
+	expected := []string{"global warning", "twitch error", "ping debug", "log info"}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %v to be logged, got %q.", expected, buffer.String())
+	}
+
+	for idx, line := range lines {
+		if !strings.Contains(line, expected[idx]) {
+			t.Errorf("Expected line %d to be '%s', got '%s'.", idx+1, expected[idx], line)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// HandlerBuckets are the upper bounds (in seconds) of the handler latency histograms.
+var HandlerBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// the counters in the bot_counter table
+const counterReconnects = "reconnects"
+
+// Moderation actions as counted by the Metrics.
+const (
+	ModerationBan     = "ban"
+	ModerationTimeout = "timeout"
+)
+
+// Metrics counts what the bot does, so that it can be monitored. Everything that
+// can be read elsewhere (like the channel stats or the database stats) is not
+// duplicated here.
+type Metrics struct {
+	started    time.Time
+	reconnects uint64
+	sent       map[string]uint64            // per channel
+	notSent    map[string]uint64            // per channel
+	moderation map[string]map[string]uint64 // per channel and action
+	commands   map[string]uint64            // per plugin
+	handlers   map[string]*Histogram        // per plugin
+	mutex      sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		started:    time.Now(),
+		sent:       make(map[string]uint64),
+		notSent:    make(map[string]uint64),
+		moderation: make(map[string]map[string]uint64),
+		commands:   make(map[string]uint64),
+		handlers:   make(map[string]*Histogram),
+	}
+}
+
+func (self *Metrics) Started() time.Time {
+	return self.started
+}
+
+// Reconnects returns how often the connection to Twitch has been lost. As the bot
+// exits when that happens, the number is kept in the database.
+func (self *Metrics) Reconnects() uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.reconnects
+}
+
+func (self *Metrics) loadReconnects(db Database) error {
+	var reconnects uint64
+
+	err := db.Get(&reconnects, "SELECT value FROM bot_counter WHERE name = ?", counterReconnects)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	self.mutex.Lock()
+	self.reconnects = reconnects
+	self.mutex.Unlock()
+
+	return nil
+}
+
+func (self *Metrics) connectionLost(db Database) error {
+	self.mutex.Lock()
+	self.reconnects++
+	self.mutex.Unlock()
+
+	return db.Transaction(func(tx Queryer) error {
+		result, err := tx.Exec("UPDATE bot_counter SET value = value + 1 WHERE name = ?", counterReconnects)
+		if err != nil {
+			return err
+		}
+
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			return nil
+		}
+
+		_, err = tx.Exec("INSERT INTO bot_counter (name, value) VALUES (?, 1)", counterReconnects)
+
+		return err
+	})
+}
+
+func (self *Metrics) messageSent(channel string) {
+	self.mutex.Lock()
+	self.sent[channel]++
+	self.mutex.Unlock()
+}
+
+func (self *Metrics) messageNotSent(channel string) {
+	self.mutex.Lock()
+	self.notSent[channel]++
+	self.mutex.Unlock()
+}
+
+func (self *Metrics) moderated(channel string, action string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if _, exists := self.moderation[channel]; !exists {
+		self.moderation[channel] = make(map[string]uint64)
+	}
+
+	self.moderation[channel][action]++
+}
+
+func (self *Metrics) commandExecuted(plugin string) {
+	self.mutex.Lock()
+	self.commands[plugin]++
+	self.mutex.Unlock()
+}
+
+func (self *Metrics) handled(plugin string, duration time.Duration) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	histogram, exists := self.handlers[plugin]
+	if !exists {
+		histogram = newHistogram(HandlerBuckets)
+		self.handlers[plugin] = histogram
+	}
+
+	histogram.observe(duration.Seconds())
+}
+
+// MessagesSent returns the number of messages sent to each channel.
+func (self *Metrics) MessagesSent() map[string]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return copyCounters(self.sent)
+}
+
+// MessagesNotSent returns the number of messages per channel that could not be
+// sent, e.g. because the queue was full.
+func (self *Metrics) MessagesNotSent() map[string]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return copyCounters(self.notSent)
+}
+
+// ModerationActions returns the number of bans and timeouts per channel.
+func (self *Metrics) ModerationActions() map[string]map[string]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	result := make(map[string]map[string]uint64)
+
+	for channel, actions := range self.moderation {
+		result[channel] = copyCounters(actions)
+	}
+
+	return result
+}
+
+// CommandsExecuted returns the number of commands each plugin has responded to.
+func (self *Metrics) CommandsExecuted() map[string]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return copyCounters(self.commands)
+}
+
+// HandlerDurations returns how long each plugin took to handle incoming messages.
+func (self *Metrics) HandlerDurations() map[string]Histogram {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	result := make(map[string]Histogram)
+
+	for plugin, histogram := range self.handlers {
+		result[plugin] = histogram.copy()
+	}
+
+	return result
+}
+
+func copyCounters(counters map[string]uint64) map[string]uint64 {
+	result := make(map[string]uint64, len(counters))
+
+	for key, value := range counters {
+		result[key] = value
+	}
+
+	return result
+}
+
+// A Histogram counts observations into buckets. Counts[i] is the number of
+// observations that are less than or equal to Buckets[i], so like in Prometheus,
+// the counts are cumulative.
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (self *Histogram) observe(value float64) {
+	for idx, bound := range self.Buckets {
+		if value <= bound {
+			self.Counts[idx]++
+		}
+	}
+
+	self.Count++
+	self.Sum += value
+}
+
+func (self *Histogram) copy() Histogram {
+	counts := make([]uint64, len(self.Counts))
+	copy(counts, self.Counts)
+
+	return Histogram{self.Buckets, counts, self.Count, self.Sum}
+}
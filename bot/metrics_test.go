@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// a client that is never connected and sends whatever it is told to
+type queueingClient struct {
+	twitch.Client
+	accept bool
+}
+
+func (self *queueingClient) Send(msg twitch.OutgoingMessage) <-chan bool {
+	signal := make(chan bool, 1)
+	signal <- self.accept
+	close(signal)
+
+	return signal
+}
+
+func TestMetricsCountMessagesOnceTheyAreSent(t *testing.T) {
+	metrics := NewMetrics()
+	client := &queueingClient{accept: true}
+	sender := newChannelSender(client, "#chan", metrics)
+
+	if !<-sender.SendText("hello") || !<-sender.Ban("troll") {
+		t.Fatal("Expected the messages to be sent.")
+	}
+
+	client.accept = false
+
+	if <-sender.SendText("hello") || <-sender.Timeout("troll", 60) {
+		t.Fatal("Expected the messages to be dropped.")
+	}
+
+	if sent := metrics.MessagesSent()["#chan"]; sent != 2 {
+		t.Errorf("Expected 2 sent messages, got %d.", sent)
+	}
+
+	if notSent := metrics.MessagesNotSent()["#chan"]; notSent != 2 {
+		t.Errorf("Expected 2 dropped messages, got %d.", notSent)
+	}
+
+	actions := metrics.ModerationActions()["#chan"]
+	if actions[ModerationBan] != 1 || actions[ModerationTimeout] != 0 {
+		t.Errorf("Expected only the ban to be counted, got %v.", actions)
+	}
+}
+
+func TestMetricsKeepReconnects(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	metrics := NewMetrics()
+
+	if err := metrics.connectionLost(db); err != nil {
+		t.Fatalf("Could not count the lost connection: %s", err)
+	}
+
+	// the bot has been restarted
+	metrics = NewMetrics()
+
+	if err := metrics.loadReconnects(db); err != nil {
+		t.Fatalf("Could not load the counters: %s", err)
+	}
+
+	if reconnects := metrics.Reconnects(); reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %d.", reconnects)
+	}
+}
@@ -0,0 +1,527 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Migration changes the database schema from one version to the next. Versions
+// start at 1 and are counted separately for the bot itself and every plugin.
+//
+// Migrations run in a transaction together with recording them as applied. MySQL
+// commits schema changes implicitly though, so a failed migration can be partially
+// applied there: migrations must be safe to run again (use IF NOT EXISTS and check
+// for columns with HasColumn).
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(Database) error
+	Down        func(Database) error
+}
+
+// Plugins that need their own tables implement this. The source identifies the
+// plugin's migrations and must never change.
+type migratingPlugin interface {
+	Migrations() (source string, migrations []Migration)
+}
+
+// SQL creates a migration step that runs the statements in order. CREATE TABLE
+// statements get the dialect's table options appended.
+func SQL(statements ...string) func(Database) error {
+	return func(db Database) error {
+		for _, stmt := range statements {
+			stmt = strings.TrimSpace(stmt)
+
+			if strings.HasPrefix(stmt, "CREATE TABLE") {
+				stmt += " " + db.Dialect().TableOptions()
+			}
+
+			_, err := db.Exec(stmt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// HasColumn checks whether the table exists and has the column.
+func HasColumn(db Database, table string, column string) (bool, error) {
+	columns, err := db.Dialect().Columns(db, table)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range columns {
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// AddColumns creates a migration step that adds the columns (given as name and
+// definition) that the table does not have yet.
+func AddColumns(table string, columns ...[2]string) func(Database) error {
+	return func(db Database) error {
+		for _, column := range columns {
+			exists, err := HasColumn(db, table, column[0])
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column[0] + " " + column[1])
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// the source name of the bot's own migrations
+const coreMigrations = "bot"
+
+type MigrationStatus struct {
+	Source    string
+	Migration Migration
+	Applied   bool
+}
+
+func (self MigrationStatus) String() string {
+	return fmt.Sprintf("%s #%d (%s)", self.Source, self.Migration.Version, self.Migration.Description)
+}
+
+type migrationSource struct {
+	name       string
+	migrations []Migration
+}
+
+type Migrator struct {
+	db      Database
+	sources []migrationSource
+}
+
+// NewMigrator collects the migrations of the bot and the given plugins. The bot's
+// migrations always run first, then the plugins' in the given order.
+func NewMigrator(db Database, plugins []Plugin) *Migrator {
+	sources := []migrationSource{{coreMigrations, migrations}}
+
+	for _, plugin := range plugins {
+		migrating, okay := plugin.(migratingPlugin)
+		if okay {
+			name, list := migrating.Migrations()
+			sources = append(sources, migrationSource{name, list})
+		}
+	}
+
+	return &Migrator{db, sources}
+}
+
+type migrationRow struct {
+	Source  string
+	Version int
+}
+
+// Status lists all known migrations in the order they are applied. This does not
+// change the database; without the schema_migration table, nothing is applied yet.
+func (self *Migrator) Status() ([]MigrationStatus, error) {
+	exists, err := HasColumn(self.db, "schema_migration", "version")
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]migrationRow, 0)
+
+	if exists {
+		err = self.db.Select(&rows, "SELECT source, version FROM schema_migration")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	applied := make(map[migrationRow]bool)
+
+	for _, row := range rows {
+		applied[row] = true
+	}
+
+	list := make([]MigrationStatus, 0)
+
+	for _, source := range self.sources {
+		for _, migration := range source.migrations {
+			list = append(list, MigrationStatus{
+				Source:    source.name,
+				Migration: migration,
+				Applied:   applied[migrationRow{source.name, migration.Version}],
+			})
+		}
+	}
+
+	return list, nil
+}
+
+// Pending returns all migrations that have not been applied yet.
+func (self *Migrator) Pending() ([]MigrationStatus, error) {
+	all, err := self.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]MigrationStatus, 0)
+
+	for _, status := range all {
+		if !status.Applied {
+			pending = append(pending, status)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies all pending migrations and returns the ones that were applied.
+func (self *Migrator) Up() ([]MigrationStatus, error) {
+	pending, err := self.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	err = SQL(`CREATE TABLE IF NOT EXISTS schema_migration (
+		source      VARCHAR(64) NOT NULL,
+		version     INT NOT NULL,
+		description VARCHAR(255) NOT NULL,
+		applied_at  BIGINT NOT NULL,
+		PRIMARY KEY (source, version)
+	)`)(self.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, status := range pending {
+		err := self.db.Transaction(func(tx Queryer) error {
+			err := status.Migration.Up(&txDatabase{tx, self.db})
+			if err != nil {
+				return fmt.Errorf("Could not apply %s: %s", status, err.Error())
+			}
+
+			_, err = tx.Exec(
+				"INSERT INTO schema_migration (source, version, description, applied_at) VALUES (?, ?, ?, ?)",
+				status.Source, status.Migration.Version, status.Migration.Description, time.Now().Unix(),
+			)
+
+			return err
+		})
+		if err != nil {
+			return pending[:idx], err
+		}
+	}
+
+	return pending, nil
+}
+
+// Down reverts the given number of the most recent migrations and returns the ones
+// that were reverted.
+func (self *Migrator) Down(steps int) ([]MigrationStatus, error) {
+	all, err := self.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := make([]MigrationStatus, 0)
+
+	for idx := len(all) - 1; idx >= 0 && len(reverted) < steps; idx-- {
+		status := all[idx]
+
+		if !status.Applied {
+			continue
+		}
+
+		err := self.db.Transaction(func(tx Queryer) error {
+			err := status.Migration.Down(&txDatabase{tx, self.db})
+			if err != nil {
+				return fmt.Errorf("Could not revert %s: %s", status, err.Error())
+			}
+
+			_, err = tx.Exec("DELETE FROM schema_migration WHERE source = ? AND version = ?", status.Source, status.Migration.Version)
+
+			return err
+		})
+		if err != nil {
+			return reverted, err
+		}
+
+		reverted = append(reverted, status)
+	}
+
+	return reverted, nil
+}
+
+// the bot's own tables
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create core tables",
+		Up: SQL(
+			`CREATE TABLE IF NOT EXISTS channel (
+				name VARCHAR(64) NOT NULL,
+				PRIMARY KEY (name)
+			)`,
+			`CREATE TABLE IF NOT EXISTS plugin (
+				channel VARCHAR(64) NOT NULL,
+				plugin  VARCHAR(64) NOT NULL,
+				PRIMARY KEY (channel, plugin)
+			)`,
+			`CREATE TABLE IF NOT EXISTS acl (
+				channel    VARCHAR(64) NOT NULL,
+				permission VARCHAR(128) NOT NULL,
+				user_ident VARCHAR(64) NOT NULL,
+				user_id    BIGINT NULL,
+				negated    TINYINT(1) NOT NULL DEFAULT 0,
+				expires_at BIGINT NULL,
+				PRIMARY KEY (channel, permission, user_ident)
+			)`,
+			`CREATE TABLE IF NOT EXISTS acl_group (
+				channel   VARCHAR(64) NOT NULL,
+				groupname VARCHAR(64) NOT NULL,
+				username  VARCHAR(64) NOT NULL,
+				user_id   BIGINT NULL,
+				PRIMARY KEY (channel, groupname, username)
+			)`,
+			dictionaryTable("dictionary"),
+			`CREATE TABLE IF NOT EXISTS twitch_user (
+				id    BIGINT NOT NULL,
+				login VARCHAR(64) NOT NULL,
+				PRIMARY KEY (id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS bot_admin (
+				username   VARCHAR(64) NOT NULL,
+				user_id    BIGINT NULL,
+				capability VARCHAR(32) NOT NULL,
+				PRIMARY KEY (username, capability)
+			)`,
+		),
+		Down: SQL(
+			"DROP TABLE bot_admin",
+			"DROP TABLE twitch_user",
+			"DROP TABLE dictionary",
+			"DROP TABLE acl_group",
+			"DROP TABLE acl",
+			"DROP TABLE plugin",
+			"DROP TABLE channel",
+		),
+	},
+	{
+		Version:     2,
+		Description: "create dictionary revisions",
+		Up: SQL(
+			`CREATE TABLE IF NOT EXISTS dictionary_revision (
+				channel    VARCHAR(64) NOT NULL DEFAULT '',
+				keyname    VARCHAR(128) NOT NULL,
+				revision   INT NOT NULL,
+				old_value  TEXT NULL,
+				new_value  TEXT NULL,
+				author     VARCHAR(64) NOT NULL DEFAULT '',
+				source     VARCHAR(64) NOT NULL DEFAULT '',
+				changed_at BIGINT NOT NULL,
+				PRIMARY KEY (channel, keyname, revision)
+			)`,
+		),
+		Down: SQL("DROP TABLE dictionary_revision"),
+	},
+	{
+		Version:     3,
+		Description: "create channel settings",
+		Up: SQL(
+			`CREATE TABLE IF NOT EXISTS channel_setting (
+				channel VARCHAR(64) NOT NULL,
+				plugin  VARCHAR(64) NOT NULL,
+				keyname VARCHAR(64) NOT NULL,
+				value   TEXT NOT NULL,
+				PRIMARY KEY (channel, plugin, keyname)
+			)`,
+		),
+		Down: SQL("DROP TABLE channel_setting"),
+	},
+	{
+		Version:     4,
+		Description: "upgrade tables from before migrations existed",
+		Up: func(db Database) error {
+			err := AddColumns("acl",
+				[2]string{"user_id", "BIGINT NULL"},
+				[2]string{"negated", "TINYINT(1) NOT NULL DEFAULT 0"},
+				[2]string{"expires_at", "BIGINT NULL"},
+			)(db)
+			if err != nil {
+				return err
+			}
+
+			return upgradeDictionary(db)
+		},
+		// the old schema is not worth restoring
+		Down: SQL(),
+	},
+	{
+		Version:     5,
+		Description: "rename permissions to their namespaced names",
+		Up:          renameLegacyPermissions,
+		Down:        SQL(),
+	},
+	{
+		Version:     6,
+		Description: "create counters",
+		Up: SQL(
+			`CREATE TABLE IF NOT EXISTS bot_counter (
+				name VARCHAR(64) NOT NULL,
+				value BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (name)
+			)`,
+		),
+		Down: SQL("DROP TABLE bot_counter"),
+	},
+	{
+		Version:     7,
+		Description: "lowercase dictionary keys",
+		Up:          lowercaseDictionaryKeys,
+		Down:        SQL(),
+	},
+}
+
+func dictionaryTable(name string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + name + ` (
+		channel VARCHAR(64) NOT NULL DEFAULT '',
+		keyname VARCHAR(128) NOT NULL,
+		value   TEXT NOT NULL,
+		PRIMARY KEY (channel, keyname)
+	)`
+}
+
+// upgradeDictionary adds the channel to the old global-only dictionary. As SQLite
+// cannot change primary keys, the table is rebuilt and all existing entries become
+// global ones. This picks up where an interrupted upgrade left off.
+func upgradeDictionary(db Database) error {
+	hasChannel, err := HasColumn(db, "dictionary", "channel")
+	if err != nil {
+		return err
+	}
+
+	hasKeyname, err := HasColumn(db, "dictionary", "keyname")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hasChannel:
+		return nil
+
+	case !hasKeyname:
+		// the old table has already been dropped, only the rename is missing
+		return SQL("ALTER TABLE dictionary_upgrade RENAME TO dictionary")(db)
+
+	default:
+		return SQL(
+			"DROP TABLE IF EXISTS dictionary_upgrade",
+			dictionaryTable("dictionary_upgrade"),
+			"INSERT INTO dictionary_upgrade (channel, keyname, value) SELECT '', keyname, value FROM dictionary",
+			"DROP TABLE dictionary",
+			"ALTER TABLE dictionary_upgrade RENAME TO dictionary",
+		)(db)
+	}
+}
+
+type legacyACLRow struct {
+	Channel    string
+	Permission string
+	UserIdent  string `db:"user_ident"`
+}
+
+// renameLegacyPermissions renames ACL entries for permissions from before they were
+// namespaced. Two old permissions can map to the same new one; in that case the
+// entry that is already there wins and the other one is dropped.
+func renameLegacyPermissions(db Database) error {
+	rows := make([]legacyACLRow, 0)
+
+	err := db.Select(&rows, "SELECT channel, permission, user_ident FROM acl ORDER BY channel, permission, user_ident")
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[legacyACLRow]bool)
+
+	for _, row := range rows {
+		existing[row] = true
+	}
+
+	for _, row := range rows {
+		renamed, isLegacy := LegacyPermission(row.Permission)
+		if !isLegacy {
+			continue
+		}
+
+		target := legacyACLRow{row.Channel, renamed, row.UserIdent}
+
+		if existing[target] {
+			_, err = db.Exec("DELETE FROM acl WHERE channel = ? AND permission = ? AND user_ident = ?", row.Channel, row.Permission, row.UserIdent)
+		} else {
+			_, err = db.Exec("UPDATE acl SET permission = ? WHERE channel = ? AND permission = ? AND user_ident = ?", renamed, row.Channel, row.Permission, row.UserIdent)
+			existing[target] = true
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type dictKeyRow struct {
+	Channel string
+	Keyname string
+}
+
+// lowercaseDictionaryKeys renames entries that were stored with uppercase letters,
+// as keys are case-insensitive now. If the lowercase key exists as well, it is the
+// one that could be looked up before, so it wins and the other entry is dropped.
+// Revisions are left alone.
+func lowercaseDictionaryKeys(db Database) error {
+	rows := make([]dictKeyRow, 0)
+
+	err := db.Select(&rows, "SELECT channel, keyname FROM dictionary ORDER BY channel, keyname")
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[dictKeyRow]bool)
+
+	for _, row := range rows {
+		existing[row] = true
+	}
+
+	for _, row := range rows {
+		lowercase := normalizeKey(row.Keyname)
+		if lowercase == row.Keyname {
+			continue
+		}
+
+		target := dictKeyRow{row.Channel, lowercase}
+
+		if existing[target] {
+			_, err = db.Exec("DELETE FROM dictionary WHERE channel = ? AND keyname = ?", row.Channel, row.Keyname)
+		} else {
+			_, err = db.Exec("UPDATE dictionary SET keyname = ? WHERE channel = ? AND keyname = ?", lowercase, row.Channel, row.Keyname)
+			existing[target] = true
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
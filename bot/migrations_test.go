@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func testLogger() Logger {
+	return NewLogger(LogOptions{Output: ioutil.Discard})
+}
+
+func openTestDatabase(t *testing.T) Database {
+	db, err := OpenDatabase("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Could not open the database: %s", err)
+	}
+
+	return db
+}
+
+func exec(t *testing.T, db Database, statements ...string) {
+	for _, stmt := range statements {
+		_, err := db.Exec(stmt)
+		if err != nil {
+			t.Fatalf("%s failed: %s", stmt, err)
+		}
+	}
+}
+
+func TestMigrationsUpgradeBaselineSchema(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	// the schema before migrations existed
+	exec(t, db,
+		"CREATE TABLE acl (channel VARCHAR(64) NOT NULL, permission VARCHAR(64) NOT NULL, user_ident VARCHAR(64) NOT NULL, PRIMARY KEY (channel, permission, user_ident))",
+		"CREATE TABLE dictionary (keyname VARCHAR(128) NOT NULL, value TEXT NOT NULL, PRIMARY KEY (keyname))",
+		"INSERT INTO acl (channel, permission, user_ident) VALUES ('#chan', 'list_custom_commands', 'bob')",
+		"INSERT INTO dictionary (keyname, value) VALUES ('greeting', 'hello')",
+	)
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	for _, column := range []string{"user_id", "negated", "expires_at"} {
+		if exists, _ := HasColumn(db, "acl", column); !exists {
+			t.Errorf("acl.%s has not been added.", column)
+		}
+	}
+
+	// existing entries become global ones, and channels can now have their own
+	dict := NewDictionary(db, testLogger(), NewEventBus())
+
+	err = dict.load()
+	if err != nil {
+		t.Fatalf("Could not load the dictionary: %s", err)
+	}
+
+	if value := dict.Get("greeting"); value != "hello" {
+		t.Errorf("Expected the global greeting to be 'hello', got '%s'.", value)
+	}
+
+	err = dict.Channel("#chan").Set("greeting", "hi")
+	if err != nil {
+		t.Errorf("Could not set a channel entry next to the global one: %s", err)
+	}
+
+	pending, err := NewMigrator(db, nil).Pending()
+	if err != nil || len(pending) > 0 {
+		t.Errorf("Expected no pending migrations, got %v (%v).", pending, err)
+	}
+}
+
+func TestMigrationsResumeInterruptedDictionaryUpgrade(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	// the old table has been dropped, but the new one not been renamed yet
+	exec(t, db,
+		dictionaryTable("dictionary_upgrade"),
+		"INSERT INTO dictionary_upgrade (channel, keyname, value) VALUES ('', 'greeting', 'hello')",
+	)
+
+	err := upgradeDictionary(db)
+	if err != nil {
+		t.Fatalf("Resuming the upgrade failed: %s", err)
+	}
+
+	value := ""
+
+	err = db.Get(&value, "SELECT value FROM dictionary WHERE channel = '' AND keyname = 'greeting'")
+	if err != nil || value != "hello" {
+		t.Errorf("Expected the entry to survive, got '%s' (%v).", value, err)
+	}
+}
+
+func TestMigrationsAreRecordedTogetherWithTheirChanges(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	migrator := NewMigrator(db, nil)
+	migrator.sources = append(migrator.sources, migrationSource{"broken", []Migration{
+		{
+			Version:     1,
+			Description: "fails halfway",
+			Up:          SQL("CREATE TABLE halfway (id INT NOT NULL)", "THIS IS NOT SQL"),
+			Down:        SQL(),
+		},
+	}})
+
+	_, err := migrator.Up()
+	if err == nil {
+		t.Fatal("Expected the broken migration to fail.")
+	}
+
+	if exists, _ := HasColumn(db, "halfway", "id"); exists {
+		t.Error("The failed migration has not been rolled back.")
+	}
+
+	pending, _ := migrator.Pending()
+	if len(pending) != 1 || pending[0].Source != "broken" {
+		t.Errorf("Expected only the broken migration to be pending, got %v.", pending)
+	}
+}
+
+func TestMigrationStatusDoesNotChangeTheDatabase(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	pending, err := NewMigrator(db, nil).Pending()
+	if err != nil {
+		t.Fatalf("Checking the status failed: %s", err)
+	}
+
+	if len(pending) != len(migrations) {
+		t.Errorf("Expected all %d migrations to be pending, got %d.", len(migrations), len(pending))
+	}
+
+	tables, err := db.Dialect().Tables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tables) > 0 {
+		t.Errorf("Expected no tables to be created, got %v.", tables)
+	}
+}
+
+func TestMigrationsRenameLegacyPermissions(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	// both old speedrun permissions map to speedruncom.use
+	exec(t, db,
+		"INSERT INTO acl (channel, permission, user_ident) VALUES ('#chan', 'use_speedruncom_commands', 'bob')",
+		"INSERT INTO acl (channel, permission, user_ident) VALUES ('#chan', 'use_speedrun_commands', 'bob')",
+		"INSERT INTO acl (channel, permission, user_ident) VALUES ('#chan', 'use_speedrun_commands', 'kevin')",
+		"INSERT INTO acl (channel, permission, user_ident) VALUES ('#chan', 'use_foo_cmd', '$mods')",
+	)
+
+	err = renameLegacyPermissions(db)
+	if err != nil {
+		t.Fatalf("Renaming failed: %s", err)
+	}
+
+	rows := make([]legacyACLRow, 0)
+
+	err = db.Select(&rows, "SELECT channel, permission, user_ident FROM acl ORDER BY permission, user_ident")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []legacyACLRow{
+		{"#chan", "custom_commands.use.foo", "$mods"},
+		{"#chan", "speedruncom.use", "bob"},
+		{"#chan", "speedruncom.use", "kevin"},
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %v, got %v.", expected, rows)
+	}
+
+	for idx, row := range rows {
+		if row != expected[idx] {
+			t.Errorf("Expected %v, got %v.", expected[idx], row)
+		}
+	}
+}
+
+func TestMigrationsLowercaseDictionaryKeys(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	exec(t, db,
+		"INSERT INTO dictionary (channel, keyname, value) VALUES ('', 'Foo', 'renamed')",
+		"INSERT INTO dictionary (channel, keyname, value) VALUES ('#chan', 'Foo', 'dropped')",
+		"INSERT INTO dictionary (channel, keyname, value) VALUES ('#chan', 'foo', 'kept')",
+	)
+
+	err = lowercaseDictionaryKeys(db)
+	if err != nil {
+		t.Fatalf("Lowercasing failed: %s", err)
+	}
+
+	rows := make([]dictRow, 0)
+
+	err = db.Select(&rows, "SELECT channel, keyname, value FROM dictionary ORDER BY channel, keyname")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []dictRow{
+		{"", "foo", "renamed"},
+		{"#chan", "foo", "kept"},
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %v, got %v.", expected, rows)
+	}
+
+	for idx, row := range rows {
+		if row != expected[idx] {
+			t.Errorf("Expected %v, got %v.", expected[idx], row)
+		}
+	}
+}
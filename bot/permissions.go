@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Permissions are namespaced by the plugin that defines them, e.g.
+// "custom_commands.configure" or "custom_commands.use.foo". ACL entries can use a
+// trailing wildcard to cover a whole namespace ("custom_commands.use.*") or
+// everything ("*").
+const PermissionWildcard = "*"
+
+var permissionRegex = regexp.MustCompile(`^([a-z0-9_-]+\.)*([a-z0-9_-]+|\*)$`)
+
+// permissions used before they were namespaced
+var legacyPermissions = map[string]string{
+	"configure_custom_commands":     "custom_commands.configure",
+	"configure_custom_commands_acl": "custom_commands.configure_acl",
+	"list_custom_commands":          "custom_commands.list",
+	"configure_domain_bans":         "moderation.domain_bans",
+	"use_emote_counter":             "emote_counter.use",
+	"use_speedruncom_commands":      "speedruncom.use",
+	"use_speedrun_commands":         "speedruncom.use",
+	"trolling":                      "troll.use",
+	"gta_commands":                  "gta.use",
+	"crash_commands":                "crash.use",
+	"chatty_commands":               "chatty.use",
+	"sda_commands":                  "sda.use",
+	"esa_commands":                  "esa.use",
+	"eggs_commands":                 "eggs.use",
+}
+
+var legacyCustomCommand = regexp.MustCompile(`^use_(.+)_cmd$`)
+
+// IsPermissionPattern checks the syntax of a permission or wildcard pattern.
+func IsPermissionPattern(pattern string) bool {
+	return permissionRegex.MatchString(pattern)
+}
+
+// PermissionMatches checks whether the ACL pattern covers the given permission.
+func PermissionMatches(pattern string, permission string) bool {
+	if pattern == PermissionWildcard || pattern == permission {
+		return true
+	}
+
+	return strings.HasSuffix(pattern, "."+PermissionWildcard) && strings.HasPrefix(permission, strings.TrimSuffix(pattern, PermissionWildcard))
+}
+
+// permissionPatterns returns all ACL patterns that could cover the permission,
+// i.e. "a.b.c", "a.b.*", "a.*" and "*".
+func permissionPatterns(permission string) []string {
+	parts := strings.Split(permission, ".")
+	result := []string{permission}
+
+	for i := len(parts) - 1; i > 0; i-- {
+		result = append(result, strings.Join(parts[:i], ".")+"."+PermissionWildcard)
+	}
+
+	return append(result, PermissionWildcard)
+}
+
+// LegacyPermission maps a pre-namespace permission to its new name.
+func LegacyPermission(permission string) (string, bool) {
+	if renamed, ok := legacyPermissions[permission]; ok {
+		return renamed, true
+	}
+
+	if match := legacyCustomCommand.FindStringSubmatch(permission); match != nil {
+		return "custom_commands.use." + match[1], true
+	}
+
+	return permission, false
+}
+
+// PermissionNamespaces groups the permissions by their namespace (everything up to
+// the first dot) and returns the namespaces sorted alphabetically. The grouped
+// permissions have their namespace stripped.
+func PermissionNamespaces(permissions []string) ([]string, map[string][]string) {
+	grouped := make(map[string][]string)
+
+	for _, permission := range permissions {
+		ns, name := permission, ""
+
+		if idx := strings.Index(permission, "."); idx != -1 {
+			ns, name = permission[:idx], permission[(idx+1):]
+		}
+
+		grouped[ns] = append(grouped[ns], name)
+	}
+
+	namespaces := make([]string, 0, len(grouped))
+
+	for ns, names := range grouped {
+		sort.Strings(names)
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces, grouped
+}
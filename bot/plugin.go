@@ -1,6 +1,13 @@
 package bot
 
-import "github.com/sgt-kabukiman/kabukibot/twitch"
+import (
+	"fmt"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// after this many panics, a plugin is automatically disabled in a channel
+const maxPluginPanics = 3
 
 type Plugin interface {
 	Name() string
@@ -21,6 +28,12 @@ type Plugin interface {
 // 	Unload(*twitch.Channel, *Kabukibot, Dispatcher)
 // }
 
+// Plugins with background work of their own (outside of the channels) implement
+// this; Stop is called once all channels have been shut down.
+type stoppablePlugin interface {
+	Stop()
+}
+
 type PluginWorker interface {
 	Enable()
 	Disable()
@@ -33,6 +46,17 @@ type pluginWorkerStruct struct {
 	Plugin  Plugin
 	Worker  PluginWorker
 	Enabled bool
+	Panics  int
+}
+
+// pluginLabel returns a name suitable for log messages, even for plugins without a name
+func pluginLabel(plugin Plugin) string {
+	name := plugin.Name()
+	if name == "" {
+		name = fmt.Sprintf("%T", plugin)
+	}
+
+	return name
 }
 
 // these are just used to detect message types that a plugin worker wants to handle
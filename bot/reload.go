@@ -0,0 +1,85 @@
+package bot
+
+// Plugins that can apply configuration changes while the bot is running implement
+// this. They return the changed settings that only take effect after a restart. On
+// error, the plugin must keep using its previous configuration.
+type reconfigurablePlugin interface {
+	Reconfigure(config *Configuration) (restartRequired []string, err error)
+}
+
+// A ReloadReport describes how a new configuration has been applied.
+type ReloadReport struct {
+	RestartRequired []string // changed settings that only take effect after a restart
+	Errors          []error  // plugins that failed to apply their new configuration
+}
+
+// ReloadConfiguration reads the configuration file again and applies it. If the file
+// is invalid, nothing is changed and the error is returned.
+func (bot *Kabukibot) ReloadConfiguration() (ReloadReport, error) {
+	bot.logger.Info("Reloading configuration file @ %s...", bot.Configuration().Filename())
+
+	config, err := LoadConfiguration(bot.Configuration().Filename())
+	if err != nil {
+		bot.logger.Error("Could not reload the configuration: %s", err.Error())
+		return ReloadReport{}, err
+	}
+
+	return bot.Reconfigure(config)
+}
+
+// Reconfigure applies a new configuration. The settings needed to connect to Twitch
+// and the database, to recognize commands, to log and to serve the API are kept until
+// the next restart. If any plugin rejects its settings, nothing is changed.
+func (bot *Kabukibot) Reconfigure(config *Configuration) (ReloadReport, error) {
+	bot.reloadMutex.Lock()
+	defer bot.reloadMutex.Unlock()
+
+	current := bot.Configuration()
+	report := ReloadReport{
+		RestartRequired: current.restartRequired(config),
+		Errors:          make([]error, 0),
+	}
+
+	merged := *config
+	merged.Account = current.Account
+	merged.Operator = current.Operator
+	merged.CommandPrefix = current.CommandPrefix
+	merged.Database = current.Database
+	merged.IRC = current.IRC
+	merged.Log = current.Log
+	merged.API = current.API
+
+	err := merged.validatePlugins()
+	if err != nil {
+		bot.logger.Error("Could not reload the configuration: %s", err.Error())
+		return ReloadReport{}, err
+	}
+
+	bot.configMutex.Lock()
+	bot.configuration = &merged
+	bot.configMutex.Unlock()
+
+	for _, plugin := range bot.plugins {
+		reconfigurable, okay := plugin.(reconfigurablePlugin)
+		if !okay {
+			continue
+		}
+
+		restart, err := reconfigurable.Reconfigure(&merged)
+		if err != nil {
+			bot.logger.Error(err.Error())
+			report.Errors = append(report.Errors, err)
+			continue
+		}
+
+		report.RestartRequired = append(report.RestartRequired, restart...)
+	}
+
+	for _, setting := range report.RestartRequired {
+		bot.logger.Warning("The setting '%s' has changed, but only takes effect after a restart.", setting)
+	}
+
+	bot.logger.Info("The configuration has been reloaded.")
+
+	return report, nil
+}
@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"testing"
+)
+
+type reconfigurable struct {
+	reconfigured int
+}
+
+func (self *reconfigurable) Name() string                      { return "test" }
+func (self *reconfigurable) Setup(*Kabukibot)                  {}
+func (self *reconfigurable) CreateWorker(Channel) PluginWorker { return nil }
+
+func (self *reconfigurable) Reconfigure(config *Configuration) ([]string, error) {
+	self.reconfigured++
+	return []string{"plugins.test.limit"}, nil
+}
+
+func TestReconfigureRejectsInvalidPluginSettingsFirst(t *testing.T) {
+	RegisterPluginConfig("test", func() PluginConfig { return &testPluginConfig{} })
+	defer delete(pluginConfigs, "test")
+
+	config := validConfiguration()
+	plugin := &reconfigurable{}
+
+	kabukibot, _ := NewKabukibot(nil, testLogger(), nil, &config)
+	kabukibot.AddPlugin(plugin)
+
+	invalid := validConfiguration()
+	invalid.Plugins = map[string]interface{}{"test": map[interface{}]interface{}{"limit": -1}}
+
+	_, err := kabukibot.Reconfigure(&invalid)
+	if err == nil {
+		t.Fatal("Expected the invalid configuration to be rejected.")
+	}
+
+	if kabukibot.Configuration() != &config || plugin.reconfigured > 0 {
+		t.Error("Nothing should have been changed.")
+	}
+
+	valid := validConfiguration()
+	valid.CommandPrefix = "x_"
+	valid.Plugins = map[string]interface{}{"test": map[interface{}]interface{}{"limit": 1}}
+
+	report, err := kabukibot.Reconfigure(&valid)
+	if err != nil {
+		t.Fatalf("Could not reconfigure: %s", err)
+	}
+
+	if kabukibot.Configuration().Plugins == nil || kabukibot.Configuration().CommandPrefix != "" || plugin.reconfigured != 1 {
+		t.Error("The plugins should have been reconfigured, but the command prefix kept.")
+	}
+
+	expected := []string{"commandPrefix", "plugins.test.limit"}
+
+	if len(report.RestartRequired) != len(expected) || report.RestartRequired[0] != expected[0] || report.RestartRequired[1] != expected[1] {
+		t.Errorf("Expected %v to need a restart, got %v.", expected, report.RestartRequired)
+	}
+}
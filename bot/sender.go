@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
 
@@ -21,18 +23,26 @@ type Sender interface {
 type channelSender struct {
 	twitch  twitch.Client
 	channel string
+	metrics *Metrics
 }
 
-func newChannelSender(client twitch.Client, channel string) *channelSender {
-	return &channelSender{client, channel}
+func newChannelSender(client twitch.Client, channel string, metrics *Metrics) *channelSender {
+	return &channelSender{client, channel, metrics}
 }
 
-func (self *channelSender) newResponder(msg *TextMessage) *responder {
-	return &responder{self, msg}
+func (self *channelSender) newResponder(msg *TextMessage, plugin string) *responder {
+	return &responder{cn: self, msg: msg, plugin: plugin}
 }
 
+// Send counts the message once it is known whether it could be sent.
 func (self *channelSender) Send(msg twitch.OutgoingMessage) <-chan bool {
-	return self.twitch.Send(msg)
+	return afterSending(self.twitch.Send(msg), func(sent bool) {
+		if sent {
+			self.metrics.messageSent(self.channel)
+		} else {
+			self.metrics.messageNotSent(self.channel)
+		}
+	})
 }
 
 func (self *channelSender) SendText(text string) <-chan bool {
@@ -47,25 +57,64 @@ func (self *channelSender) Respond(text string) <-chan bool {
 }
 
 func (self *channelSender) Ban(user string) <-chan bool {
-	return self.SendText(".ban " + user)
+	return self.moderate(ModerationBan, ".ban "+user)
 }
 
 func (self *channelSender) Timeout(user string, seconds int) <-chan bool {
-	return self.SendText(fmt.Sprintf(".timeout %s %d", user, seconds))
+	return self.moderate(ModerationTimeout, fmt.Sprintf(".timeout %s %d", user, seconds))
+}
+
+func (self *channelSender) moderate(action string, command string) <-chan bool {
+	return afterSending(self.SendText(command), func(sent bool) {
+		if sent {
+			self.metrics.moderated(self.channel, action)
+		}
+	})
+}
+
+// afterSending calls fn with the result of sending a message and then passes the
+// result on.
+func afterSending(signal <-chan bool, fn func(sent bool)) <-chan bool {
+	result := make(chan bool, 1)
+
+	go func() {
+		sent := <-signal
+		fn(sent)
+
+		result <- sent
+		close(result)
+	}()
+
+	return result
 }
 
 // a sender that is tied to a received message and can be used to transparently address the
 // original sender by name
 type responder struct {
-	cn  *channelSender
-	msg *TextMessage
+	cn       *channelSender
+	msg      *TextMessage
+	plugin   string    // the plugin that handles the message
+	answered sync.Once // to count commands only once, no matter how many lines the plugin sends
+}
+
+// answer counts the message as an executed command, if it is one.
+func (self *responder) answer() {
+	self.answered.Do(func() {
+		if self.msg.Command() != "" {
+			self.cn.metrics.commandExecuted(self.plugin)
+		}
+	})
 }
 
 func (self *responder) Send(msg twitch.OutgoingMessage) <-chan bool {
+	self.answer()
+
 	return self.cn.Send(msg)
 }
 
 func (self *responder) SendText(text string) <-chan bool {
+	self.answer()
+
 	return self.cn.SendText(text)
 }
 
@@ -74,9 +123,68 @@ func (self *responder) Respond(text string) <-chan bool {
 }
 
 func (self *responder) Ban(user string) <-chan bool {
-	return self.SendText(".ban " + user)
+	return self.cn.Ban(user)
 }
 
 func (self *responder) Timeout(user string, seconds int) <-chan bool {
-	return self.SendText(fmt.Sprintf(".timeout %s %d", user, seconds))
+	return self.cn.Timeout(user, seconds)
+}
+
+// ContextSender wraps a sender so that nothing is sent anymore once the context is
+// done, e.g. because a task took too long or the bot has left the channel.
+func ContextSender(ctx context.Context, sender Sender) Sender {
+	return &contextSender{ctx, sender}
+}
+
+type contextSender struct {
+	ctx    context.Context
+	sender Sender
+}
+
+func (self *contextSender) Send(msg twitch.OutgoingMessage) <-chan bool {
+	if self.ctx.Err() != nil {
+		return failedSignal()
+	}
+
+	return self.sender.Send(msg)
+}
+
+func (self *contextSender) SendText(text string) <-chan bool {
+	if self.ctx.Err() != nil {
+		return failedSignal()
+	}
+
+	return self.sender.SendText(text)
+}
+
+func (self *contextSender) Respond(text string) <-chan bool {
+	if self.ctx.Err() != nil {
+		return failedSignal()
+	}
+
+	return self.sender.Respond(text)
+}
+
+func (self *contextSender) Ban(user string) <-chan bool {
+	if self.ctx.Err() != nil {
+		return failedSignal()
+	}
+
+	return self.sender.Ban(user)
+}
+
+func (self *contextSender) Timeout(user string, seconds int) <-chan bool {
+	if self.ctx.Err() != nil {
+		return failedSignal()
+	}
+
+	return self.sender.Timeout(user, seconds)
+}
+
+func failedSignal() <-chan bool {
+	signal := make(chan bool, 1)
+	signal <- false
+	close(signal)
+
+	return signal
 }
@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type SettingType int
+
+const (
+	StringSetting SettingType = iota
+	IntSetting
+	BoolSetting
+)
+
+// A Setting is a per-channel value that the channel's staff can change. Only the
+// values differing from the default are stored.
+type Setting struct {
+	Key     string
+	Type    SettingType
+	Default string
+
+	// Validate optionally checks the normalized value; its error is shown in chat.
+	Validate func(value string) error
+}
+
+// Plugins with per-channel settings implement this.
+type settingsPlugin interface {
+	Settings() []Setting
+}
+
+var ErrUnknownSetting = errors.New("Unknown setting")
+
+// collectSettings gathers the declared settings by plugin name.
+func collectSettings(plugins []Plugin) map[string][]Setting {
+	result := make(map[string][]Setting)
+
+	for _, plugin := range plugins {
+		declaring, okay := plugin.(settingsPlugin)
+		if okay && len(plugin.Name()) > 0 {
+			result[plugin.Name()] = declaring.Settings()
+		}
+	}
+
+	return result
+}
+
+type settingRow struct {
+	Plugin string `db:"plugin"`
+	Key    string `db:"keyname"`
+	Value  string `db:"value"`
+}
+
+type ChannelSettings struct {
+	channel  string
+	db       Database
+	log      Logger
+	declared map[string][]Setting
+	values   map[string]string // "plugin.key" => value, only those differing from the default
+	mutex    sync.RWMutex
+}
+
+func NewChannelSettings(channel string, declared map[string][]Setting, db Database, log Logger) *ChannelSettings {
+	return &ChannelSettings{
+		channel:  channel,
+		db:       db,
+		log:      log,
+		declared: declared,
+		values:   make(map[string]string),
+		mutex:    sync.RWMutex{},
+	}
+}
+
+func (self *ChannelSettings) load() error {
+	list := make([]settingRow, 0)
+
+	err := self.db.Select(&list, "SELECT plugin, keyname, value FROM channel_setting WHERE channel = ?", self.channel)
+	if err != nil {
+		return err
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, row := range list {
+		self.values[row.Plugin+"."+row.Key] = row.Value
+	}
+
+	return nil
+}
+
+// Plugins returns the names of all plugins that have settings.
+func (self *ChannelSettings) Plugins() []string {
+	names := make([]string, 0, len(self.declared))
+
+	for name := range self.declared {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Declared returns the settings of a plugin, or nil if it has none.
+func (self *ChannelSettings) Declared(plugin string) []Setting {
+	return self.declared[plugin]
+}
+
+func (self *ChannelSettings) setting(plugin string, key string) (Setting, bool) {
+	for _, setting := range self.declared[plugin] {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+
+	return Setting{}, false
+}
+
+// Get returns the setting's value in this channel or its default. Unknown settings
+// are empty.
+func (self *ChannelSettings) Get(plugin string, key string) string {
+	setting, exists := self.setting(plugin, key)
+	if !exists {
+		return ""
+	}
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	value, exists := self.values[plugin+"."+key]
+	if !exists {
+		return setting.Default
+	}
+
+	return value
+}
+
+func (self *ChannelSettings) Int(plugin string, key string) int {
+	value, _ := strconv.Atoi(self.Get(plugin, key))
+	return value
+}
+
+func (self *ChannelSettings) Bool(plugin string, key string) bool {
+	return self.Get(plugin, key) == "true"
+}
+
+// Check normalizes a value for the setting or tells why it is not acceptable.
+func (self *ChannelSettings) Check(plugin string, key string, value string) (string, error) {
+	setting, exists := self.setting(plugin, key)
+	if !exists {
+		return "", ErrUnknownSetting
+	}
+
+	value = strings.TrimSpace(value)
+
+	switch setting.Type {
+	case IntSetting:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%s.%s must be a whole number.", plugin, key)
+		}
+
+		value = strconv.Itoa(number)
+
+	case BoolSetting:
+		switch strings.ToLower(value) {
+		case "true", "yes", "on", "1":
+			value = "true"
+		case "false", "no", "off", "0":
+			value = "false"
+		default:
+			return "", fmt.Errorf("%s.%s must be either on or off.", plugin, key)
+		}
+	}
+
+	if setting.Validate != nil {
+		err := setting.Validate(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return value, nil
+}
+
+// Set checks, stores and then applies a new value. Setting the default removes the
+// stored value.
+func (self *ChannelSettings) Set(plugin string, key string, value string) error {
+	value, err := self.Check(plugin, key, value)
+	if err != nil {
+		return err
+	}
+
+	setting, _ := self.setting(plugin, key)
+
+	if value == setting.Default {
+		_, err = self.db.Exec("DELETE FROM channel_setting WHERE channel = ? AND plugin = ? AND keyname = ?", self.channel, plugin, key)
+	} else {
+		stmt := self.db.Dialect().Upsert("channel_setting", []string{"channel", "plugin", "keyname"}, []string{"value"})
+		_, err = self.db.Exec(stmt, self.channel, plugin, key, value)
+	}
+
+	if err != nil {
+		self.log.Error("Could not store setting %s.%s in %s: %s", plugin, key, self.channel, err.Error())
+		return err
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if value == setting.Default {
+		delete(self.values, plugin+"."+key)
+	} else {
+		self.values[plugin+"."+key] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	taskPoolSize  = 4                // tasks running concurrently per channel
+	taskQueueSize = 20               // tasks waiting for a free slot before new ones are rejected
+	taskTimeout   = 30 * time.Second // default deadline for a single task
+	taskGrace     = 5 * time.Second  // how long to wait for running tasks when stopping the pool
+)
+
+// A TaskFunc is a piece of work that runs outside of the channel's goroutine. Its
+// context is cancelled when the task times out or when the bot parts the channel
+// or shuts down.
+type TaskFunc func(ctx context.Context)
+
+// The TaskPool lets plugins move slow work (like HTTP requests) off the channel
+// loop, so that the channel keeps handling messages in the meantime. Every channel
+// has its own, bounded pool.
+type TaskPool struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	channel string
+	log     Logger
+	timeout time.Duration
+	tasks   chan TaskFunc
+	ordered chan TaskFunc
+	running sync.WaitGroup
+}
+
+func newTaskPool(parent context.Context, channel string, log Logger) *TaskPool {
+	ctx, cancel := context.WithCancel(parent)
+
+	return &TaskPool{
+		ctx:     ctx,
+		cancel:  cancel,
+		channel: channel,
+		log:     log,
+		timeout: taskTimeout,
+		tasks:   make(chan TaskFunc, taskQueueSize),
+		ordered: make(chan TaskFunc, taskQueueSize),
+	}
+}
+
+// Go runs the task on the pool; tasks may finish in any order. It returns false if
+// the pool is full or has already been stopped.
+func (self *TaskPool) Go(task TaskFunc) bool {
+	return self.submit(self.tasks, task)
+}
+
+// GoOrdered runs the task once all previously ordered tasks of this channel have
+// finished, so that responses are sent in the same order as the commands came in.
+func (self *TaskPool) GoOrdered(task TaskFunc) bool {
+	return self.submit(self.ordered, task)
+}
+
+func (self *TaskPool) submit(queue chan TaskFunc, task TaskFunc) bool {
+	if self.ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case queue <- task:
+		return true
+
+	default:
+		self.log.Warning("Task pool for %s is full, rejecting task.", self.channel)
+		return false
+	}
+}
+
+func (self *TaskPool) start() {
+	for i := 0; i < taskPoolSize; i++ {
+		self.running.Add(1)
+		go self.work(self.tasks)
+	}
+
+	self.running.Add(1)
+	go self.work(self.ordered)
+}
+
+// stop cancels all tasks and waits a bit for them to notice.
+func (self *TaskPool) stop() {
+	self.cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		self.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(taskGrace):
+		self.log.Warning("Tasks for %s did not stop in time.", self.channel)
+	}
+}
+
+func (self *TaskPool) work(queue <-chan TaskFunc) {
+	defer self.running.Done()
+
+	for {
+		select {
+		case task := <-queue:
+			self.run(task)
+
+		case <-self.ctx.Done():
+			return
+		}
+	}
+}
+
+func (self *TaskPool) run(task TaskFunc) {
+	ctx, cancel := context.WithTimeout(self.ctx, self.timeout)
+	defer cancel()
+
+	defer func() {
+		if err := recover(); err != nil {
+			self.log.Error("Task panicked in %s: %v\n%s", self.channel, err, debug.Stack())
+		}
+	}()
+
+	task(ctx)
+}
@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTaskPoolKeepsOrderedTasksInOrder(t *testing.T) {
+	pool := newTaskPool(context.Background(), "#chan", testLogger())
+	pool.start()
+	defer pool.stop()
+
+	mutex := sync.Mutex{}
+	finished := make([]int, 0)
+	done := make(chan struct{})
+
+	for i := 0; i < 5; i++ {
+		number := i
+
+		pool.GoOrdered(func(ctx context.Context) {
+			// the earlier tasks take longer, so they would finish last if they ran concurrently
+			time.Sleep(time.Duration(5-number) * 5 * time.Millisecond)
+
+			mutex.Lock()
+			finished = append(finished, number)
+			mutex.Unlock()
+
+			if number == 4 {
+				close(done)
+			}
+		})
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The tasks did not finish in time.")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for idx, number := range finished {
+		if idx != number {
+			t.Fatalf("Expected the tasks to finish in order, got %v.", finished)
+		}
+	}
+}
+
+func TestTaskPoolRunsTasksConcurrently(t *testing.T) {
+	pool := newTaskPool(context.Background(), "#chan", testLogger())
+	pool.start()
+	defer pool.stop()
+
+	started := sync.WaitGroup{}
+	started.Add(taskPoolSize)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := 0; i < taskPoolSize; i++ {
+		pool.Go(func(ctx context.Context) {
+			started.Done()
+			<-release
+		})
+	}
+
+	all := make(chan struct{})
+
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+
+	select {
+	case <-all:
+	case <-time.After(time.Second):
+		t.Fatal("Expected all tasks to run at the same time.")
+	}
+}
+
+func TestTaskPoolTimesOutTasks(t *testing.T) {
+	pool := newTaskPool(context.Background(), "#chan", testLogger())
+	pool.timeout = 20 * time.Millisecond
+	pool.start()
+	defer pool.stop()
+
+	result := make(chan error, 1)
+
+	pool.Go(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			result <- ctx.Err()
+		case <-time.After(time.Second):
+			result <- nil
+		}
+	})
+
+	if err := <-result; err != context.DeadlineExceeded {
+		t.Errorf("Expected the task to time out, got %v.", err)
+	}
+}
+
+func TestTaskPoolSurvivesPanickingTasks(t *testing.T) {
+	pool := newTaskPool(context.Background(), "#chan", testLogger())
+	pool.start()
+	defer pool.stop()
+
+	done := make(chan struct{})
+
+	pool.GoOrdered(func(ctx context.Context) { panic("broken") })
+	pool.GoOrdered(func(ctx context.Context) { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the next task to run after a panic.")
+	}
+}
+
+func TestTaskPoolRejectsTasksWhenFull(t *testing.T) {
+	pool := newTaskPool(context.Background(), "#chan", testLogger())
+
+	// without workers nothing leaves the queue
+	for i := 0; i < taskQueueSize; i++ {
+		if !pool.Go(func(ctx context.Context) {}) {
+			t.Fatalf("Expected task %d to be queued.", i)
+		}
+	}
+
+	if pool.Go(func(ctx context.Context) {}) {
+		t.Error("Expected the task to be rejected when the queue is full.")
+	}
+
+	pool.stop()
+}
+
+// a plugin that starts a task as soon as it is enabled and reports how it ended
+type taskPlugin struct {
+	started chan struct{}
+	ended   chan error
+	pool    *TaskPool
+}
+
+func (self *taskPlugin) Name() string     { return "" }
+func (self *taskPlugin) Setup(*Kabukibot) {}
+
+func (self *taskPlugin) CreateWorker(channel Channel) PluginWorker {
+	self.pool = channel.Tasks()
+	return self
+}
+
+func (self *taskPlugin) Enable() {
+	self.pool.Go(func(ctx context.Context) {
+		close(self.started)
+
+		select {
+		case <-ctx.Done():
+			self.ended <- ctx.Err()
+		case <-time.After(time.Second):
+			self.ended <- nil
+		}
+	})
+}
+
+func (self *taskPlugin) Disable()              {}
+func (self *taskPlugin) Part()                 {}
+func (self *taskPlugin) Shutdown()             {}
+func (self *taskPlugin) Permissions() []string { return []string{} }
+
+func TestPartingCancelsTheChannelsTasks(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	config := validConfiguration()
+	plugin := &taskPlugin{started: make(chan struct{}), ended: make(chan error, 1)}
+
+	kabukibot, _ := NewKabukibot(&queueingClient{accept: true}, testLogger(), db, &config)
+	kabukibot.AddPlugin(plugin)
+	kabukibot.dictionary = NewDictionary(db, testLogger(), kabukibot.events)
+	kabukibot.users = NewUserDirectory(db, testLogger(), kabukibot.events)
+
+	channel := newChannelWorker("#chan", kabukibot)
+	go channel.Work()
+
+	select {
+	case <-plugin.started:
+	case <-time.After(time.Second):
+		t.Fatal("The task did not start.")
+	}
+
+	<-channel.Leave()
+
+	if err := <-plugin.ended; err != context.Canceled {
+		t.Errorf("Expected the task to be cancelled, got %v.", err)
+	}
+
+	if channel.Tasks().Go(func(ctx context.Context) {}) {
+		t.Error("Expected no more tasks to be accepted after parting.")
+	}
+}
@@ -10,9 +10,12 @@ import (
 type TextMessage struct {
 	twitch.TextMessage
 
-	prefix    string
-	operator  string
-	processed bool
+	prefix     string
+	operator   string
+	operatorID int
+	admins     *Admins
+	processed  bool
+	identified <-chan struct{}
 }
 
 func (self *TextMessage) IsCommand(cmd string) bool {
@@ -23,8 +26,9 @@ func (self *TextMessage) IsGlobalCommand(cmd string) bool {
 	return self.IsCommand(self.prefix + cmd)
 }
 
+// IsFrom compares the sender's login name, not the display name.
 func (self *TextMessage) IsFrom(user string) bool {
-	return strings.ToLower(self.User.Name) == strings.ToLower(user)
+	return self.User.Login == strings.ToLower(user)
 }
 
 func (self *TextMessage) IsFromBroadcaster() bool {
@@ -32,9 +36,23 @@ func (self *TextMessage) IsFromBroadcaster() bool {
 }
 
 func (self *TextMessage) IsFromOperator() bool {
+	if self.operatorID > 0 && self.User.ID > 0 {
+		return self.User.ID == self.operatorID
+	}
+
 	return self.IsFrom(self.operator)
 }
 
+// IsFromAdmin checks whether the user is the operator or a bot admin that has been
+// granted the capability.
+func (self *TextMessage) IsFromAdmin(capability string) bool {
+	if self.IsFromOperator() {
+		return true
+	}
+
+	return self.admins != nil && self.admins.Has(self.User, capability)
+}
+
 func (self *TextMessage) IsFromBot() bool {
 	return self.User.Myself
 }
@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+// The UserDirectory maps Twitch user IDs to login names. Names can change, IDs
+// cannot, so everything that has to identify a user reliably (ACL entries, the
+// blacklist, the operator) is bound to the ID as soon as the user has been seen
+// in chat. The directory learns from every message and publishes a
+// UserIdentifiedEvent when it sees a user for the first time or after a rename.
+//
+// Storing new users and publishing the events happens in the background, so that a
+// slow database cannot hold up the messages of all channels.
+type UserDirectory struct {
+	db      Database
+	log     Logger
+	events  *EventBus
+	mutex   sync.RWMutex
+	byID    map[int]string
+	byLogin map[string]int
+	queue   chan identification
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// an identification waits in the queue to be stored and published
+type identification struct {
+	id       int
+	login    string
+	previous string // the user's former login, if the user has been renamed
+	replaced int    // the user that used to have this login, if any
+	done     chan struct{}
+}
+
+// how many identifications can wait to be stored; users beyond that are simply
+// identified again with their next message
+const identificationQueueSize = 100
+
+type userRow struct {
+	ID    int
+	Login string
+}
+
+func NewUserDirectory(db Database, log Logger, events *EventBus) *UserDirectory {
+	return &UserDirectory{
+		db:      db,
+		log:     log,
+		events:  events,
+		byID:    make(map[int]string),
+		byLogin: make(map[string]int),
+		queue:   make(chan identification, identificationQueueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (self *UserDirectory) load() error {
+	list := make([]userRow, 0)
+
+	err := self.db.Select(&list, "SELECT id, login FROM twitch_user")
+	if err != nil {
+		return err
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, row := range list {
+		self.byID[row.ID] = row.Login
+		self.byLogin[row.Login] = row.ID
+	}
+
+	self.log.Debug("Loaded %d users.", len(list))
+
+	return nil
+}
+
+// Observe records the user's current name. Users without an ID (e.g. from
+// messages without tags) are ignored. If the user is new or has been renamed, the
+// returned channel is closed once the UserIdentifiedEvent has been handled, so that
+// the user's message can wait for that; otherwise it is nil.
+func (self *UserDirectory) Observe(user twitch.User) <-chan struct{} {
+	login := strings.ToLower(user.Login)
+
+	if user.ID <= 0 || login == "" {
+		return nil
+	}
+
+	self.mutex.RLock()
+	known := self.byID[user.ID]
+	self.mutex.RUnlock()
+
+	if known == login {
+		return nil
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	item := identification{id: user.ID, login: login, previous: known, done: make(chan struct{})}
+
+	// someone else used to have this name and has been renamed since
+	if other, exists := self.byLogin[login]; exists && other != user.ID {
+		item.replaced = other
+	}
+
+	// only remember the user once it is certain that it will be stored
+	select {
+	case self.queue <- item:
+	default:
+		self.log.Warning("Too many users waiting to be stored, skipping %s for now.", login)
+		return nil
+	}
+
+	if item.replaced != 0 {
+		delete(self.byID, item.replaced)
+	}
+
+	if known != "" {
+		delete(self.byLogin, known)
+	}
+
+	self.byID[user.ID] = login
+	self.byLogin[login] = user.ID
+
+	return item.done
+}
+
+// Start stores and publishes the identified users in the background.
+func (self *UserDirectory) Start() {
+	go self.work()
+}
+
+// Stop handles the users that are still waiting and then stops the background work.
+func (self *UserDirectory) Stop() {
+	close(self.stop)
+	<-self.stopped
+}
+
+func (self *UserDirectory) work() {
+	defer close(self.stopped)
+
+	for {
+		select {
+		case item := <-self.queue:
+			self.identify(item)
+
+		case <-self.stop:
+			for {
+				select {
+				case item := <-self.queue:
+					self.identify(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (self *UserDirectory) identify(item identification) {
+	defer close(item.done)
+
+	log := self.log.With(Fields{"user": item.login})
+	upsert := self.db.Dialect().Upsert("twitch_user", []string{"id"}, []string{"login"})
+
+	err := self.db.Transaction(func(tx Queryer) error {
+		if item.replaced != 0 {
+			_, err := tx.Exec("DELETE FROM twitch_user WHERE id = ?", item.replaced)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(upsert, item.id, item.login)
+
+		return err
+	})
+
+	// the user is still published, so that at least everything in memory is bound
+	// to the ID; it is stored again after the next restart
+	if err != nil {
+		log.Error("Could not store user %s (%d): %s", item.login, item.id, err.Error())
+	}
+
+	if item.previous == "" {
+		log.Debug("Identified %s as user %d.", item.login, item.id)
+	} else {
+		log.Info("User %d has been renamed from %s to %s.", item.id, item.previous, item.login)
+	}
+
+	self.events.Publish(UserIdentifiedEvent{item.id, item.login, item.previous})
+}
+
+func (self *UserDirectory) IDByName(login string) (int, bool) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	id, ok := self.byLogin[strings.ToLower(login)]
+
+	return id, ok
+}
+
+func (self *UserDirectory) NameByID(id int) (string, bool) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	login, ok := self.byID[id]
+
+	return login, ok
+}
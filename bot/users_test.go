@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/sgt-kabukiman/kabukibot/twitch"
+)
+
+func TestUserDirectoryStoresRenames(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	_, err := NewMigrator(db, nil).Up()
+	if err != nil {
+		t.Fatalf("Migrating failed: %s", err)
+	}
+
+	events := NewEventBus()
+	published := make([]UserIdentifiedEvent, 0)
+
+	events.Subscribe(EventUserIdentified, func(event Event) {
+		published = append(published, event.(UserIdentifiedEvent))
+	})
+
+	users := NewUserDirectory(db, testLogger(), events)
+	users.Start()
+
+	<-users.Observe(twitch.User{Login: "bob", ID: 42})
+
+	if users.Observe(twitch.User{Login: "Bob", ID: 42}) != nil {
+		t.Error("A known user should not be identified again.")
+	}
+
+	// bob renames himself and somebody else grabs the old name
+	users.Observe(twitch.User{Login: "robert", ID: 42})
+	users.Observe(twitch.User{Login: "bob", ID: 43})
+	users.Stop()
+
+	expected := []UserIdentifiedEvent{{42, "bob", ""}, {42, "robert", "bob"}, {43, "bob", ""}}
+
+	if len(published) != len(expected) {
+		t.Fatalf("Expected %v to be published, got %v.", expected, published)
+	}
+
+	for idx, event := range published {
+		if event != expected[idx] {
+			t.Errorf("Expected %v, got %v.", expected[idx], event)
+		}
+	}
+
+	rows := make([]userRow, 0)
+
+	err = db.Select(&rows, "SELECT id, login FROM twitch_user ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0] != (userRow{42, "robert"}) || rows[1] != (userRow{43, "bob"}) {
+		t.Errorf("Expected robert (42) and bob (43) to be stored, got %v.", rows)
+	}
+}
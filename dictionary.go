@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"gopkg.in/yaml.v2"
+)
+
+func exportDictionary(db bot.Database, logger bot.Logger) {
+	dict, err := bot.LoadDictionary(db, logger)
+	if err != nil {
+		logger.Fatal("Could not load the dictionary: " + err.Error())
+	}
+
+	dump := dict.Export()
+
+	var data []byte
+
+	if *dictExportFormat == "json" {
+		data, err = json.MarshalIndent(dump, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(dump)
+	}
+
+	if err != nil {
+		logger.Fatal("Could not encode the dictionary: " + err.Error())
+	}
+
+	if *dictExportOutput == "" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	err = ioutil.WriteFile(*dictExportOutput, data, 0644)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	logger.Info("Exported %d global entries and %d channels to %s.", len(dump.Global), len(dump.Channels), *dictExportOutput)
+}
+
+func importDictionary(db bot.Database, logger bot.Logger) {
+	data, err := ioutil.ReadFile(*dictImportFile)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	format := *dictImportFormat
+	if format == "" {
+		format = "yaml"
+
+		if strings.ToLower(filepath.Ext(*dictImportFile)) == ".json" {
+			format = "json"
+		}
+	}
+
+	dump := bot.DictionaryDump{}
+
+	if format == "json" {
+		err = json.Unmarshal(data, &dump)
+	} else {
+		err = yaml.Unmarshal(data, &dump)
+	}
+
+	if err != nil {
+		logger.Fatal("Could not decode " + *dictImportFile + ": " + err.Error())
+	}
+
+	dict, err := bot.LoadDictionary(db, logger)
+	if err != nil {
+		logger.Fatal("Could not load the dictionary: " + err.Error())
+	}
+
+	updated, deleted, err := dict.Import(dump, *dictImportPrune, bot.DictionaryAuthor{Source: "import"})
+
+	logger.Info("Imported %s: %d entries updated, %d deleted.", *dictImportFile, updated, deleted)
+
+	if err != nil {
+		logger.Fatal("The import was aborted: " + err.Error())
+	}
+}
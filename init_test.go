@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin/acl"
+	"github.com/sgt-kabukiman/kabukibot/plugin/admin"
 	"github.com/sgt-kabukiman/kabukibot/plugin/banhammer_bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin/blacklist"
 	"github.com/sgt-kabukiman/kabukibot/plugin/content"
@@ -16,6 +17,8 @@ import (
 	"github.com/sgt-kabukiman/kabukibot/plugin/monitor"
 	"github.com/sgt-kabukiman/kabukibot/plugin/ping"
 	"github.com/sgt-kabukiman/kabukibot/plugin/plugin_control"
+	"github.com/sgt-kabukiman/kabukibot/plugin/reload"
+	"github.com/sgt-kabukiman/kabukibot/plugin/settings"
 	"github.com/sgt-kabukiman/kabukibot/plugin/speedruncom"
 	"github.com/sgt-kabukiman/kabukibot/plugin/subhype"
 	"github.com/sgt-kabukiman/kabukibot/plugin/sysinfo"
@@ -44,10 +47,22 @@ func initTester(t *test.Tester) {
 		return acl.NewPlugin()
 	})
 
+	t.AddPlugin("admin", func() bot.Plugin {
+		return admin.NewPlugin()
+	})
+
+	t.AddPlugin("reload", func() bot.Plugin {
+		return reload.NewPlugin()
+	})
+
 	t.AddPlugin("plugin_control", func() bot.Plugin {
 		return plugin_control.NewPlugin()
 	})
 
+	t.AddPlugin("settings", func() bot.Plugin {
+		return settings.NewPlugin()
+	})
+
 	t.AddPlugin("speedruncom", func() bot.Plugin {
 		return speedruncom.NewPlugin()
 	})
@@ -95,4 +110,8 @@ func initTester(t *test.Tester) {
 	t.AddPlugin("gta", func() bot.Plugin {
 		return content.NewGTAPlugin()
 	})
+
+	t.AddPlugin("crash", func() bot.Plugin {
+		return test.NewCrashPlugin()
+	})
 }
@@ -3,14 +3,18 @@ package main
 import (
 	"io/ioutil"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin"
-	"github.com/jmoiron/sqlx"
+	"github.com/sgt-kabukiman/kabukibot/api"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin/acl"
+	"github.com/sgt-kabukiman/kabukibot/plugin/admin"
 	"github.com/sgt-kabukiman/kabukibot/plugin/banhammer_bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin/blacklist"
 	"github.com/sgt-kabukiman/kabukibot/plugin/content"
@@ -24,6 +28,8 @@ import (
 	"github.com/sgt-kabukiman/kabukibot/plugin/monitor"
 	"github.com/sgt-kabukiman/kabukibot/plugin/ping"
 	"github.com/sgt-kabukiman/kabukibot/plugin/plugin_control"
+	"github.com/sgt-kabukiman/kabukibot/plugin/reload"
+	"github.com/sgt-kabukiman/kabukibot/plugin/settings"
 	"github.com/sgt-kabukiman/kabukibot/plugin/speedruncom"
 	"github.com/sgt-kabukiman/kabukibot/plugin/subhype"
 	"github.com/sgt-kabukiman/kabukibot/plugin/sysinfo"
@@ -35,30 +41,99 @@ var (
 	configFile   = kingpin.Flag("config", "Path to the config file to use").Required().String()
 	channelsFile = kingpin.Flag("channels", "Path to a file of channels to join after connecting").String()
 	debug        = kingpin.Flag("debug", "Enable debug output").Bool()
+
+	runCmd = kingpin.Command("run", "Connect to Twitch and run the bot").Default()
+
+	dictCmd          = kingpin.Command("dict", "Manage the dictionary")
+	dictExportCmd    = dictCmd.Command("export", "Write all dictionary entries to a file")
+	dictExportFormat = dictExportCmd.Flag("format", "The file format (yaml or json)").Default("yaml").Enum("yaml", "json")
+	dictExportOutput = dictExportCmd.Flag("output", "Path to the file to write (default: stdout)").String()
+	dictImportCmd    = dictCmd.Command("import", "Update the dictionary from a file")
+	dictImportFile   = dictImportCmd.Arg("file", "Path to the YAML or JSON file to read").Required().String()
+	dictImportFormat = dictImportCmd.Flag("format", "The file format (yaml or json; default: guessed from the file extension)").Enum("yaml", "json")
+	dictImportPrune  = dictImportCmd.Flag("prune", "Delete entries that are not in the file").Bool()
+
+	migrateCmd       = kingpin.Command("migrate", "Manage the database schema")
+	migrateUpCmd     = migrateCmd.Command("up", "Apply all pending migrations")
+	migrateDownCmd   = migrateCmd.Command("down", "Revert the most recent migrations")
+	migrateDownSteps = migrateDownCmd.Flag("steps", "The number of migrations to revert").Default("1").Int()
+	migrateStatusCmd = migrateCmd.Command("status", "List all migrations and whether they have been applied")
 )
 
 func main() {
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	// do not mix log output into the exported data
+	quiet := command == dictExportCmd.FullCommand() && *dictExportOutput == ""
 
-	// create logger
+	// create a logger until the configured one can be used
 	level := bot.LogLevelInfo
 	if *debug {
 		level = bot.LogLevelDebug
 	}
 
-	logger := bot.NewLogger(level)
+	if quiet {
+		level = bot.LogLevelError
+	}
+
+	logger := bot.NewLogger(bot.LogOptions{Level: level})
 
 	// load configuration
-	logger.Info("Loading configuration file @ " + *configFile + "...")
+	logger.Info("Loading configuration file @ %s...", *configFile)
 	config, err := bot.LoadConfiguration(*configFile)
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
 
+	options, err := config.Log.Options()
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	if *debug {
+		options.Level = bot.LogLevelDebug
+	}
+
+	if quiet && options.Output == nil {
+		options.Level = bot.LogLevelError
+		options.Levels = nil
+	}
+
+	logger = bot.NewLogger(options)
+
+	// connect to database
+	logger.Info("Connecting to database...")
+	db, err := bot.OpenDatabase(config.Database.Driver, config.Database.DSN)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	switch command {
+	case dictExportCmd.FullCommand():
+		exportDictionary(db, logger)
+
+	case dictImportCmd.FullCommand():
+		importDictionary(db, logger)
+
+	case migrateUpCmd.FullCommand():
+		migrateUp(db, logger)
+
+	case migrateDownCmd.FullCommand():
+		migrateDown(db, logger)
+
+	case migrateStatusCmd.FullCommand():
+		migrateStatus(db, logger)
+
+	default:
+		run(db, logger, config)
+	}
+}
+
+func run(db bot.Database, logger bot.Logger, config *bot.Configuration) {
 	var channels []string
 
 	if *channelsFile != "" {
-		logger.Info("Reading initial channel list @ " + *channelsFile + "...")
+		logger.Info("Reading initial channel list @ %s...", *channelsFile)
 		data, err := ioutil.ReadFile(*channelsFile)
 		if err != nil {
 			logger.Fatal(err.Error())
@@ -67,16 +142,9 @@ func main() {
 		channels = strings.Split(string(data), "\n")
 	}
 
-	// connect to database
-	logger.Info("Connecting to database...")
-	db, err := sqlx.Connect("mysql", config.Database.DSN)
-	if err != nil {
-		logger.Fatal(err.Error())
-	}
-
 	// setup our TwitchClient
 	server := net.JoinHostPort(config.IRC.Host, strconv.Itoa(config.IRC.Port))
-	twitch := twitch.NewTwitchClient(server, config.Account.Username, config.Account.Password, 2*time.Second, logger)
+	twitch := twitch.NewTwitchClient(server, config.Account.Username, config.Account.Password, 2*time.Second, logger.Subsystem("twitch"))
 
 	// build the bot
 	kabukibot, err := bot.NewKabukibot(twitch, logger, db, config)
@@ -85,28 +153,9 @@ func main() {
 	}
 
 	// add plugins
-	kabukibot.AddPlugin(blacklist.NewPlugin()) // load this as early as possible, because users will only be blacklisted for all following plugins
-	kabukibot.AddPlugin(log.NewPlugin())
-	kabukibot.AddPlugin(ping.NewPlugin())
-	kabukibot.AddPlugin(join.NewPlugin())
-	kabukibot.AddPlugin(acl.NewPlugin())
-	kabukibot.AddPlugin(plugin_control.NewPlugin())
-	kabukibot.AddPlugin(speedruncom.NewPlugin())
-	kabukibot.AddPlugin(echo.NewPlugin())
-	kabukibot.AddPlugin(sysinfo.NewPlugin())
-	kabukibot.AddPlugin(dictionary.NewPlugin())
-	kabukibot.AddPlugin(domain_ban.NewPlugin())
-	kabukibot.AddPlugin(banhammer_bot.NewPlugin())
-	kabukibot.AddPlugin(emote_counter.NewPlugin())
-	kabukibot.AddPlugin(subhype.NewPlugin())
-	kabukibot.AddPlugin(troll.NewPlugin())
-	kabukibot.AddPlugin(monitor.NewPlugin())
-	kabukibot.AddPlugin(custom_commands.NewPlugin())
-	kabukibot.AddPlugin(content.NewGTAPlugin())
-	kabukibot.AddPlugin(content.NewCrashPlugin())
-	kabukibot.AddPlugin(content.NewChattyPlugin())
-	kabukibot.AddPlugin(content.NewSDAPlugin())
-	kabukibot.AddPlugin(content.NewESAPlugin())
+	for _, plugin := range plugins() {
+		kabukibot.AddPlugin(plugin)
+	}
 
 	// here we go
 	err = kabukibot.Connect()
@@ -118,10 +167,86 @@ func main() {
 	logger.Info("Letting the magic happen...")
 	go kabukibot.Work()
 
+	// serve the admin API, if configured
+	apiServer := api.NewServer(kabukibot, config.API.Token)
+
+	if config.API.Listen != "" {
+		err = apiServer.Start(config.API.Listen)
+		if err != nil {
+			logger.Fatal("Could not start the API: %s", err.Error())
+		}
+	}
+
+	// reload the configuration file on SIGHUP; the outcome is logged
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+
+	go func() {
+		for range reloadSignal {
+			kabukibot.ReloadConfiguration()
+		}
+	}()
+
 	for _, cn := range channels {
 		<-kabukibot.Join(cn)
 	}
 
-	// wait for disconnect
-	<-kabukibot.Alive()
+	// shut down cleanly when being asked to stop
+	stopSignal := make(chan os.Signal, 2)
+	signal.Notify(stopSignal, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case sig := <-stopSignal:
+		logger.Info("Received %s, shutting down...", sig.String())
+
+		// do not wait forever for a hanging shutdown
+		go func() {
+			<-stopSignal
+			logger.Fatal("Received a second signal, exiting immediately.")
+		}()
+
+		apiServer.Stop()
+
+		err = kabukibot.Shutdown()
+		if err != nil {
+			os.Exit(1)
+		}
+
+	case <-kabukibot.Alive():
+		// still give the plugins a chance to save their state
+		logger.Error("The connection to Twitch has been lost.")
+		apiServer.Stop()
+		kabukibot.Shutdown()
+		os.Exit(1)
+	}
+}
+
+func plugins() []bot.Plugin {
+	return []bot.Plugin{
+		blacklist.NewPlugin(), // load this as early as possible, because users will only be blacklisted for all following plugins
+		log.NewPlugin(),
+		ping.NewPlugin(),
+		join.NewPlugin(),
+		acl.NewPlugin(),
+		admin.NewPlugin(),
+		reload.NewPlugin(),
+		plugin_control.NewPlugin(),
+		settings.NewPlugin(),
+		speedruncom.NewPlugin(),
+		echo.NewPlugin(),
+		sysinfo.NewPlugin(),
+		dictionary.NewPlugin(),
+		domain_ban.NewPlugin(),
+		banhammer_bot.NewPlugin(),
+		emote_counter.NewPlugin(),
+		subhype.NewPlugin(),
+		troll.NewPlugin(),
+		monitor.NewPlugin(),
+		custom_commands.NewPlugin(),
+		content.NewGTAPlugin(),
+		content.NewCrashPlugin(),
+		content.NewChattyPlugin(),
+		content.NewSDAPlugin(),
+		content.NewESAPlugin(),
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func migrateUp(db bot.Database, logger bot.Logger) {
+	applied, err := bot.NewMigrator(db, plugins()).Up()
+
+	for _, status := range applied {
+		logger.Info("Applied %s.", status)
+	}
+
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	if len(applied) == 0 {
+		logger.Info("The database schema is up to date.")
+	}
+}
+
+func migrateDown(db bot.Database, logger bot.Logger) {
+	reverted, err := bot.NewMigrator(db, plugins()).Down(*migrateDownSteps)
+
+	for _, status := range reverted {
+		logger.Info("Reverted %s.", status)
+	}
+
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	if len(reverted) == 0 {
+		logger.Info("There are no migrations to revert.")
+	}
+}
+
+func migrateStatus(db bot.Database, logger bot.Logger) {
+	list, err := bot.NewMigrator(db, plugins()).Status()
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	for _, status := range list {
+		mark := " "
+		if status.Applied {
+			mark = "x"
+		}
+
+		fmt.Printf("[%s] %s\n", mark, status)
+	}
+}
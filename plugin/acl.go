@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+var userIdentRegex = regexp.MustCompile(`[^a-zA-Z0-9_$@,]`)
+var userNameRegex = regexp.MustCompile(`[^a-z0-9_]`)
+
+type ACLAction int
+
+const (
+	ACLAllow ACLAction = iota
+	ACLDeny
+	ACLRevoke
+)
+
+// HandleACLCommand grants, denies or revokes a permission for a list of user idents
+// given in a chat command and responds with the outcome. It is shared by the acl
+// plugin and all plugins that manage their own permissions, like custom commands.
+// Grants can be limited in time by appending "--for <duration>", e.g. "--for 3d".
+func HandleACLCommand(acl *bot.ACL, action ACLAction, permission string, args []string, sender bot.Sender, permisionName string) {
+	args, duration, okay := extractExpiry(args)
+	if !okay {
+		sender.Respond("invalid duration given. Use something like `--for 3d` or `--for 12h`.")
+		return
+	}
+
+	if duration > 0 && action != ACLAllow {
+		sender.Respond("only grants can be limited in time.")
+		return
+	}
+
+	until := time.Time{}
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+
+	// normalize the arguments into a single array of (possibly bogus) idents
+	args = strings.Split(strings.ToLower(userIdentRegex.ReplaceAllString(strings.Join(args, ","), "")), ",")
+
+	if len(args) == 0 {
+		sender.Respond("invalid groups/usernames. Use a comma separated list if you give multiple.")
+		return
+	}
+
+	processed := make([]string, 0)
+	failed := make([]string, 0)
+
+	for i, ident := range args {
+		if ident == "" {
+			continue
+		}
+
+		if acl.IsUsername(ident) {
+			ident = userNameRegex.ReplaceAllString(ident, "")
+
+			// if we removed bogus characters, discard the user ident to not accidentally grant or revoke permissions
+			if ident != args[i] {
+				continue
+			}
+		} else if strings.HasPrefix(ident, "@") && !bot.IsCustomGroup(ident) {
+			continue
+		}
+
+		changed := false
+		var err error
+
+		switch action {
+		case ACLAllow:
+			changed, err = acl.AllowUntil(ident, permission, until)
+		case ACLDeny:
+			changed, err = acl.Deny(ident, permission)
+		case ACLRevoke:
+			changed, err = acl.Revoke(ident, permission)
+		}
+
+		if err != nil {
+			failed = append(failed, ident)
+		} else if changed {
+			processed = append(processed, ident)
+		}
+	}
+
+	if len(processed) == 0 {
+		if len(failed) > 0 {
+			sender.Respond(StorageFailure)
+		} else {
+			sender.Respond("no changes needed.")
+		}
+
+		return
+	}
+
+	idents := bot.HumanJoin(processed, ", ")
+	response := ""
+
+	switch action {
+	case ACLAllow:
+		if duration > 0 {
+			response = "granted permission for " + permisionName + " to " + idents + " for " + bot.FormatDuration(duration, true) + "."
+		} else {
+			response = "granted permission for " + permisionName + " to " + idents + "."
+		}
+	case ACLDeny:
+		response = "denied permission for " + permisionName + " to " + idents + "."
+	case ACLRevoke:
+		response = "revoked all permission entries for " + permisionName + " from " + idents + "."
+	}
+
+	if len(failed) > 0 {
+		response += " The change for " + bot.HumanJoin(failed, ", ") + " could not be saved, please try again later."
+	}
+
+	sender.Respond(response)
+}
+
+// extractExpiry removes a "--for <duration>" flag from the arguments.
+func extractExpiry(args []string) ([]string, time.Duration, bool) {
+	result := make([]string, 0, len(args))
+	duration := time.Duration(0)
+
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--for" {
+			result = append(result, args[i])
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, 0, false
+		}
+
+		parsed := bot.ParseDuration(args[i+1], nil, nil)
+		if parsed == nil || *parsed < time.Minute {
+			return nil, 0, false
+		}
+
+		duration = *parsed
+		i++
+	}
+
+	return result, duration, true
+}
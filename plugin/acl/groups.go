@@ -0,0 +1,123 @@
+package acl
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+)
+
+var memberRegex = regexp.MustCompile(`[^a-z0-9_,]`)
+
+func (self *Worker) isGroupCommand(msg *bot.TextMessage) bool {
+	return msg.IsGlobalCommand("groups") || msg.IsGlobalCommand("group") || msg.IsGlobalCommand("group_add") || msg.IsGlobalCommand("group_remove") || msg.IsGlobalCommand("group_delete")
+}
+
+func (self *Worker) handleGroupCommand(msg *bot.TextMessage, sender bot.Sender) {
+	acl := self.channel.ACL()
+
+	if msg.IsGlobalCommand("groups") {
+		groups := acl.Groups()
+
+		if len(groups) == 0 {
+			sender.Respond("there are no custom groups defined yet.")
+		} else {
+			sender.Respond("custom groups are: " + bot.HumanJoin(groups, ", ") + ".")
+		}
+
+		return
+	}
+
+	// everything from now on requires a group name as the first parameter
+	args := msg.Arguments()
+	if len(args) == 0 {
+		sender.Respond("no group name given.")
+		return
+	}
+
+	group := strings.ToLower(args[0])
+
+	if !bot.IsCustomGroup(group) {
+		sender.Respond("invalid group name given. Group names must start with @ and consist of letters, numbers and underscores.")
+		return
+	}
+
+	// commands are matched by prefix, so the longer names must be checked first
+	switch {
+	case msg.IsGlobalCommand("group_delete"):
+		deleted, err := acl.DeleteGroup(group)
+
+		switch {
+		case err != nil:
+			sender.Respond(plugin.StorageFailure)
+		case deleted:
+			sender.Respond(group + " has been deleted.")
+		default:
+			sender.Respond(group + " does not exist.")
+		}
+
+	case msg.IsGlobalCommand("group_add") || msg.IsGlobalCommand("group_remove"):
+		if len(args) == 1 {
+			sender.Respond("no usernames given.")
+			return
+		}
+
+		add := msg.IsGlobalCommand("group_add")
+		users := strings.Split(strings.ToLower(strings.Join(args[1:], ",")), ",")
+		processed := make([]string, 0)
+		failed := make([]string, 0)
+
+		for _, user := range users {
+			// discard bogus usernames and groups entirely
+			if user == "" || memberRegex.MatchString(user) || !acl.IsUsername(user) {
+				continue
+			}
+
+			changed := false
+			var err error
+
+			if add {
+				changed, err = acl.AddToGroup(group, user)
+			} else {
+				changed, err = acl.RemoveFromGroup(group, user)
+			}
+
+			if err != nil {
+				failed = append(failed, user)
+			} else if changed {
+				processed = append(processed, user)
+			}
+		}
+
+		response := ""
+
+		switch {
+		case len(processed) == 0 && len(failed) > 0:
+			sender.Respond(plugin.StorageFailure)
+			return
+		case len(processed) == 0:
+			sender.Respond("no changes needed.")
+			return
+		case add:
+			response = "added " + bot.HumanJoin(processed, ", ") + " to " + group + "."
+		default:
+			response = "removed " + bot.HumanJoin(processed, ", ") + " from " + group + "."
+		}
+
+		if len(failed) > 0 {
+			response += " The change for " + bot.HumanJoin(failed, ", ") + " could not be saved, please try again later."
+		}
+
+		sender.Respond(response)
+
+	default:
+		members := acl.GroupMembers(group)
+
+		if len(members) == 0 {
+			sender.Respond(group + " does not exist.")
+		} else {
+			sender.Respond(group + " consists of " + bot.HumanJoin(members, ", ") + ".")
+		}
+	}
+}
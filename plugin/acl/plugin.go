@@ -1,14 +1,32 @@
 package acl
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
 )
 
+type aclConfig struct {
+	// announce in chat when a temporary grant has expired
+	NotifyExpiry bool `yaml:"notifyExpiry"`
+}
+
+func init() {
+	bot.RegisterPluginConfig("acl", func() bot.PluginConfig { return &aclConfig{} })
+}
+
+func (self *aclConfig) Validate() error {
+	return nil
+}
+
 type pluginStruct struct {
 	plugin.BasePlugin
 
-	bot *bot.Kabukibot
+	bot    *bot.Kabukibot
+	config aclConfig
+	mutex  sync.RWMutex
 }
 
 func NewPlugin() *pluginStruct {
@@ -17,11 +35,39 @@ func NewPlugin() *pluginStruct {
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.bot = bot
+
+	_, err := self.Reconfigure(bot.Configuration())
+	if err != nil {
+		bot.Logger().Warning(err.Error())
+	}
+}
+
+func (self *pluginStruct) Reconfigure(config *bot.Configuration) ([]string, error) {
+	loaded := aclConfig{}
+
+	err := config.PluginConfig("acl", &loaded)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load 'acl' plugin configuration: %s", err)
+	}
+
+	self.mutex.Lock()
+	self.config = loaded
+	self.mutex.Unlock()
+
+	return nil, nil
+}
+
+func (self *pluginStruct) notifyExpiry() bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return self.config.NotifyExpiry
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &Worker{
 		bot:     self.bot,
+		plugin:  self,
 		channel: channel,
 	}
 }
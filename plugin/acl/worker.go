@@ -3,6 +3,7 @@ package acl
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
@@ -11,11 +12,38 @@ import (
 type Worker struct {
 	plugin.NilWorker
 
-	bot     *bot.Kabukibot
-	channel bot.Channel
+	bot          *bot.Kabukibot
+	plugin       *pluginStruct
+	channel      bot.Channel
+	subscription *bot.Subscription
 }
 
-var permRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+func (self *Worker) Enable() {
+	// always listen, so that notifications can be turned on and off while running
+	if self.subscription == nil {
+		self.subscription = self.channel.Events().Subscribe(bot.EventPermissionExpired, self.onPermissionExpired)
+	}
+}
+
+func (self *Worker) Disable() {
+	if self.subscription != nil {
+		self.subscription.Unsubscribe()
+		self.subscription = nil
+	}
+}
+
+// onPermissionExpired is called from the channel's ACL sweeper
+func (self *Worker) onPermissionExpired(event bot.Event) {
+	if !self.plugin.notifyExpiry() {
+		return
+	}
+
+	expired := event.(bot.PermissionExpiredEvent)
+
+	self.channel.Sender().SendText("The temporary permission for " + expired.Permission + " granted to " + expired.UserIdent + " has expired.")
+}
+
+var permRegex = regexp.MustCompile(`[^a-zA-Z0-9_.*-]`)
 
 func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 	if msg.IsProcessed() {
@@ -23,7 +51,7 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 	}
 
 	// skip unwanted commands
-	if !msg.IsGlobalCommand("allow") && !msg.IsGlobalCommand("deny") && !msg.IsGlobalCommand("permissions") && !msg.IsGlobalCommand("allowed") {
+	if !msg.IsGlobalCommand("allow") && !msg.IsGlobalCommand("deny") && !msg.IsGlobalCommand("revoke") && !msg.IsGlobalCommand("permissions") && !msg.IsGlobalCommand("allowed") && !self.isGroupCommand(msg) {
 		return
 	}
 
@@ -32,6 +60,11 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		return
 	}
 
+	if self.isGroupCommand(msg) {
+		self.handleGroupCommand(msg, sender)
+		return
+	}
+
 	// send the list of available permissions
 	if msg.IsGlobalCommand("permissions") {
 		permissions := self.collectPermissions()
@@ -39,7 +72,7 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		if len(permissions) == 0 {
 			sender.Respond("there are no permissions available to be configured.")
 		} else {
-			sender.Respond("available permissions are: " + bot.HumanJoin(permissions, ", "))
+			sender.Respond("available permissions are: " + formatPermissions(permissions))
 		}
 
 		return
@@ -61,12 +94,19 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		return
 	}
 
+	// be nice to people who still remember the old names
+	if renamed, ok := bot.LegacyPermission(permission); ok {
+		permission = renamed
+	}
+
 	found := false
 
-	for _, p := range permissions {
-		if p == permission {
-			found = true
-			break
+	if bot.IsPermissionPattern(permission) {
+		for _, p := range permissions {
+			if bot.PermissionMatches(permission, p) {
+				found = true
+				break
+			}
 		}
 	}
 
@@ -80,11 +120,28 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 	if msg.IsGlobalCommand("allowed") {
 		users := acl.AllowedUsers(permission)
+		denied := acl.DeniedUsers(permission)
 
-		if len(users) == 0 {
+		if len(users) == 0 && len(denied) == 0 {
 			sender.Respond("\"" + permission + "\" is granted to nobody at the moment, only you can use it.")
+			return
+		}
+
+		granted := "nobody"
+		if len(users) > 0 {
+			for idx, user := range users {
+				if expires, ok := acl.Expiry(user, permission); ok {
+					users[idx] = user + " (" + bot.FormatDuration(expires.Sub(time.Now())/time.Minute*time.Minute, false) + " left)"
+				}
+			}
+
+			granted = bot.HumanJoin(users, ", ")
+		}
+
+		if len(denied) == 0 {
+			sender.Respond("\"" + permission + "\" is granted to " + granted + ".")
 		} else {
-			sender.Respond("\"" + permission + "\" is granted to " + bot.HumanJoin(users, ", ") + ".")
+			sender.Respond("\"" + permission + "\" is granted to " + granted + " and denied to " + bot.HumanJoin(denied, ", ") + ".")
 		}
 
 		return
@@ -92,57 +149,20 @@ func (self *Worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 	// no user ident(s) given
 	if len(args) == 1 {
-		sender.Respond("no groups/usernames given. Group names are " + bot.HumanJoin(bot.ACLGroups(), ", ") + ".")
+		groups := append(bot.ACLGroups(), acl.Groups()...)
+		sender.Respond("no groups/usernames given. Group names are " + bot.HumanJoin(groups, ", ") + ".")
 		return
 	}
 
-	self.HandleAllowDeny(msg.IsGlobalCommand("allow"), permission, args[1:], sender, permission)
-}
-
-var userIdentRegex = regexp.MustCompile(`[^a-zA-Z0-9_$,]`)
-var userNameRegex = regexp.MustCompile(`[^a-z0-9_]`)
-
-// HandleAllowDeny is exported because the custom commands plugin re-uses it. #cheating
-func (self *Worker) HandleAllowDeny(allow bool, permission string, args []string, sender bot.Sender, permisionName string) {
-	// normalize the arguments into a single array of (possibly bogus) idents
-	args = strings.Split(strings.ToLower(userIdentRegex.ReplaceAllString(strings.Join(args, ","), "")), ",")
+	action := plugin.ACLAllow
 
-	if len(args) == 0 {
-		sender.Respond("invalid groups/usernames. Use a comma separated list if you give multiple.")
-		return
+	if msg.IsGlobalCommand("deny") {
+		action = plugin.ACLDeny
+	} else if msg.IsGlobalCommand("revoke") {
+		action = plugin.ACLRevoke
 	}
 
-	processed := make([]string, 0)
-	acl := self.channel.ACL()
-
-	for i, ident := range args {
-		if acl.IsUsername(ident) {
-			ident = userNameRegex.ReplaceAllString(ident, "")
-
-			// if we removed bogus characters, discard the user ident to not accidentally grant or revoke permissions
-			if ident != args[i] {
-				continue
-			}
-		}
-
-		if allow {
-			if acl.Allow(ident, permission) {
-				processed = append(processed, ident)
-			}
-		} else {
-			if acl.Deny(ident, permission) {
-				processed = append(processed, ident)
-			}
-		}
-	}
-
-	if len(processed) == 0 {
-		sender.Respond("no changes needed.")
-	} else if allow {
-		sender.Respond("granted permission for " + permisionName + " to " + bot.HumanJoin(processed, ", ") + ".")
-	} else {
-		sender.Respond("revoked permission for " + permisionName + " from " + bot.HumanJoin(processed, ", ") + ".")
-	}
+	plugin.HandleACLCommand(acl, action, permission, args[1:], sender, permission)
 }
 
 func (self *Worker) collectPermissions() []string {
@@ -156,3 +176,16 @@ func (self *Worker) collectPermissions() []string {
 
 	return result
 }
+
+// formatPermissions groups the permissions by namespace, like
+// "custom_commands: configure, list; troll: use".
+func formatPermissions(permissions []string) string {
+	namespaces, grouped := bot.PermissionNamespaces(permissions)
+	result := make([]string, len(namespaces))
+
+	for idx, ns := range namespaces {
+		result[idx] = ns + ": " + strings.Join(grouped[ns], ", ")
+	}
+
+	return strings.Join(result, "; ")
+}
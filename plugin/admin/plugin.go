@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+)
+
+type pluginStruct struct {
+	plugin.BasePlugin
+	plugin.NilWorker
+
+	admins *bot.Admins
+}
+
+var usernameCleaner = regexp.MustCompile(`[^a-z0-9_]`)
+
+func NewPlugin() *pluginStruct {
+	return &pluginStruct{}
+}
+
+func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
+	self.admins = bot.Admins()
+}
+
+func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
+	return self
+}
+
+func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
+	// only the operator can manage admins
+	if msg.IsProcessed() || !msg.IsFromOperator() {
+		return
+	}
+
+	if msg.IsGlobalCommand("admins") {
+		self.handleList(sender)
+	} else if msg.IsGlobalCommand("admin_add") {
+		self.handleChange(msg, sender, true)
+	} else if msg.IsGlobalCommand("admin_remove") {
+		self.handleChange(msg, sender, false)
+	}
+}
+
+func (self *pluginStruct) handleList(sender bot.Sender) {
+	admins := self.admins.List()
+
+	if len(admins) == 0 {
+		sender.Respond("there are no bot admins.")
+		return
+	}
+
+	names := make([]string, 0, len(admins))
+
+	for name := range admins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for idx, name := range names {
+		names[idx] = name + " (" + strings.Join(admins[name], ", ") + ")"
+	}
+
+	sender.Respond("bot admins are: " + bot.HumanJoin(names, ", ") + ".")
+}
+
+func (self *pluginStruct) handleChange(msg *bot.TextMessage, sender bot.Sender, add bool) {
+	args := msg.Arguments()
+
+	if len(args) == 0 {
+		sender.Respond("you have to give a username.")
+		return
+	}
+
+	username := usernameCleaner.ReplaceAllString(strings.ToLower(args[0]), "")
+	if len(username) == 0 {
+		sender.Respond("the given username is invalid.")
+		return
+	}
+
+	capabilities := make([]string, 0)
+
+	for _, c := range strings.Split(strings.ToLower(strings.Join(args[1:], ",")), ",") {
+		c = strings.TrimSpace(c)
+
+		if c == "" {
+			continue
+		}
+
+		if !bot.IsAdminCapability(c) {
+			sender.Respond("invalid capability (" + c + ") given. Capabilities are " + bot.HumanJoin(append(bot.AdminCapabilities(), bot.AdminAll), ", ") + ".")
+			return
+		}
+
+		capabilities = append(capabilities, c)
+	}
+
+	if !add {
+		self.handleRemove(username, capabilities, sender)
+		return
+	}
+
+	if len(capabilities) == 0 {
+		sender.Respond("you have to give at least one capability.")
+		return
+	}
+
+	processed := make([]string, 0)
+	failed := false
+
+	for _, c := range capabilities {
+		granted, err := self.admins.Grant(username, c)
+		if err != nil {
+			failed = true
+			break
+		}
+
+		if granted {
+			processed = append(processed, c)
+		}
+	}
+
+	respond(sender, "granted "+bot.HumanJoin(processed, ", ")+" to "+username+".", len(processed) > 0, failed)
+}
+
+func (self *pluginStruct) handleRemove(username string, capabilities []string, sender bot.Sender) {
+	if len(capabilities) == 0 {
+		revoked, err := self.admins.Revoke(username, "")
+
+		switch {
+		case err != nil:
+			sender.Respond(plugin.StorageFailure)
+		case revoked:
+			sender.Respond(username + " is no longer a bot admin.")
+		default:
+			sender.Respond(username + " is not a bot admin.")
+		}
+
+		return
+	}
+
+	processed := make([]string, 0)
+	failed := false
+
+	for _, c := range capabilities {
+		revoked, err := self.admins.Revoke(username, c)
+		if err != nil {
+			failed = true
+			break
+		}
+
+		if revoked {
+			processed = append(processed, c)
+		}
+	}
+
+	respond(sender, "revoked "+bot.HumanJoin(processed, ", ")+" from "+username+".", len(processed) > 0, failed)
+}
+
+// respond reports the outcome of changing several capabilities, which stops at the
+// first one that could not be saved.
+func respond(sender bot.Sender, success string, changed bool, failed bool) {
+	switch {
+	case changed && failed:
+		sender.Respond(success + " The remaining changes could not be saved, please try again later.")
+	case changed:
+		sender.Respond(success)
+	case failed:
+		sender.Respond(plugin.StorageFailure)
+	default:
+		sender.Respond("no changes needed.")
+	}
+}
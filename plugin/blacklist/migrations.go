@@ -0,0 +1,28 @@
+package blacklist
+
+import (
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func (self *pluginStruct) Migrations() (string, []bot.Migration) {
+	return "blacklist", []bot.Migration{
+		{
+			Version:     1,
+			Description: "create blacklist table",
+			Up: bot.SQL(
+				`CREATE TABLE IF NOT EXISTS blacklist (
+					username VARCHAR(64) NOT NULL,
+					user_id  BIGINT NULL,
+					PRIMARY KEY (username)
+				)`,
+			),
+			Down: bot.SQL("DROP TABLE blacklist"),
+		},
+		{
+			Version:     2,
+			Description: "upgrade the blacklist from before migrations existed",
+			Up:          bot.AddColumns("blacklist", [2]string{"user_id", "BIGINT NULL"}),
+			Down:        bot.SQL(),
+		},
+	}
+}
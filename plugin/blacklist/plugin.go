@@ -4,20 +4,27 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
 )
 
+// blacklisted users are bound to their user ID once it is known
+type blacklistEntry struct {
+	Username string
+	UserID   int
+}
+
 type pluginStruct struct {
 	plugin.BasePlugin
 	plugin.NilWorker
 
-	db    *sqlx.DB
-	log   bot.Logger
-	users []string
-	bot   string
-	mutex sync.RWMutex
+	db        bot.Database
+	log       bot.Logger
+	directory *bot.UserDirectory
+	users     []blacklistEntry
+	bot       string
+	operator  string
+	mutex     sync.RWMutex
 }
 
 func NewPlugin() *pluginStruct {
@@ -26,11 +33,18 @@ func NewPlugin() *pluginStruct {
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.db = bot.Database()
-	self.log = bot.Logger()
+	self.log = bot.PluginLogger(self.Name())
+	self.directory = bot.Users()
 	self.bot = strings.ToLower(bot.BotUsername())
+	self.operator = strings.ToLower(bot.OpUsername())
 	self.mutex = sync.RWMutex{}
 
 	self.loadBlacklist()
+	self.subscribe(bot.Events())
+}
+
+func (self *pluginStruct) subscribe(events *bot.EventBus) {
+	events.Subscribe(bot.EventUserIdentified, self.onUserIdentified)
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
@@ -1,10 +1,13 @@
 package blacklist
 
 import (
+	"database/sql"
 	"regexp"
 	"strings"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+	"github.com/sgt-kabukiman/kabukibot/twitch"
 )
 
 func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -13,13 +16,13 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 	}
 
 	// mark messages from blacklisted users as processed
-	if self.isBlacklisted(msg.User.Name) {
+	if self.isBlacklisted(msg.User) {
 		self.log.Info("User is blacklisted.")
 		msg.SetProcessed()
 		return
 	}
 
-	if !msg.IsFromOperator() {
+	if !msg.IsFromAdmin(bot.AdminBlacklist) {
 		return
 	}
 
@@ -49,7 +52,7 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 	// perform blacklisting
 
 	if msg.IsGlobalCommand("blacklist") {
-		if username == strings.ToLower(msg.User.Name) {
+		if msg.IsFrom(username) {
 			sender.Respond("you cannot blacklist yourself.")
 			return
 		}
@@ -59,7 +62,16 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 			return
 		}
 
-		if self.blacklist(username) {
+		if username == self.operator {
+			sender.Respond("you cannot blacklist the operator.")
+			return
+		}
+
+		added, err := self.Blacklist(username)
+
+		if err != nil {
+			sender.Respond(plugin.StorageFailure)
+		} else if added {
 			sender.Respond(username + " has been blacklisted.")
 		} else {
 			sender.Respond(username + " is already on the blacklist.")
@@ -70,69 +82,101 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 
 	// perform unblacklisting
 
-	if self.unblacklist(username) {
+	removed, err := self.Unblacklist(username)
+
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+	} else if removed {
 		sender.Respond(username + " has been un-blacklisted.")
 	} else {
 		sender.Respond(username + " is not blacklisted.")
 	}
 }
 
-func (self *pluginStruct) blacklist(username string) bool {
-	// use a read-lock around the isBlacklisted check
+// Blacklisted returns the names of all blacklisted users.
+func (self *pluginStruct) Blacklisted() []string {
 	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	names := make([]string, len(self.users))
 
-	if self.isBlacklisted(username) {
-		self.mutex.RUnlock()
-		return false
+	for idx, u := range self.users {
+		names[idx] = u.Username
 	}
 
-	self.mutex.RUnlock()
+	return names
+}
+
+// Blacklist adds a user by their login name. It does not check whether that is a
+// sensible thing to do, like blacklisting the operator.
+func (self *pluginStruct) Blacklist(username string) (bool, error) {
 	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.find(username) != -1 {
+		return false, nil
+	}
+
+	entry := blacklistEntry{Username: username}
+	userID := sql.NullInt64{}
+
+	if id, known := self.directory.IDByName(username); known {
+		entry.UserID = id
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
 
-	_, err := self.db.Exec("INSERT INTO blacklist (username) VALUES (?)", username)
+	_, err := self.db.Exec("INSERT INTO blacklist (username, user_id) VALUES (?, ?)", username, userID)
 	if err != nil {
-		self.log.Fatal("Could not insert blacklist entry from the database: " + err.Error())
+		self.log.Error("Could not insert blacklist entry into the database: " + err.Error())
+		return false, err
 	}
 
-	self.users = append(self.users, username)
-	self.mutex.Unlock()
+	self.users = append(self.users, entry)
 
-	return true
+	return true, nil
 }
 
-func (self *pluginStruct) unblacklist(username string) bool {
+func (self *pluginStruct) Unblacklist(username string) (bool, error) {
 	self.mutex.Lock()
 	defer self.mutex.Unlock()
 
-	pos := -1
-
-	for idx, u := range self.users {
-		if u == username {
-			pos = idx
-			break
-		}
-	}
-
+	pos := self.find(username)
 	if pos == -1 {
-		return false
+		return false, nil
 	}
 
 	_, err := self.db.Exec("DELETE FROM blacklist WHERE username = ?", username)
 	if err != nil {
-		self.log.Fatal("Could not delete blacklist entry from the database: " + err.Error())
+		self.log.Error("Could not delete blacklist entry from the database: " + err.Error())
+		return false, err
 	}
 
 	self.users = append(self.users[:pos], self.users[(pos+1):]...)
 
-	return true
+	return true, nil
 }
 
-func (self *pluginStruct) isBlacklisted(username string) bool {
+func (self *pluginStruct) find(username string) int {
+	for idx, u := range self.users {
+		if u.Username == username {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func (self *pluginStruct) isBlacklisted(user twitch.User) bool {
 	self.mutex.RLock()
 	defer self.mutex.RUnlock()
 
 	for _, u := range self.users {
-		if u == username {
+		// someone else could have taken over the name of a blacklisted user
+		if u.UserID > 0 && user.ID > 0 {
+			if u.UserID == user.ID {
+				return true
+			}
+		} else if u.Username == user.Login {
 			return true
 		}
 	}
@@ -140,20 +184,144 @@ func (self *pluginStruct) isBlacklisted(username string) bool {
 	return false
 }
 
+// onUserIdentified binds entries to the user's ID and follows renames. If the new
+// name is blacklisted on its own, the renamed user's entry is merged into it, unless
+// it belongs to somebody else; then the old name is kept, as the entry matches by ID
+// anyway.
+func (self *pluginStruct) onUserIdentified(event bot.Event) {
+	identified := event.(bot.UserIdentifiedEvent)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	owner, taken := 0, false
+	pending := false
+
+	for _, u := range self.users {
+		switch {
+		case u.Username == identified.Login:
+			owner, taken = u.UserID, true
+			pending = pending || u.UserID == 0
+
+		case u.UserID == identified.ID:
+			pending = true
+		}
+	}
+
+	if !pending {
+		return
+	}
+
+	updated := make([]blacklistEntry, 0, len(self.users))
+	renamed := ""
+
+	err := self.db.Transaction(func(tx bot.Queryer) error {
+		updated = updated[:0]
+		renamed = ""
+
+		for _, u := range self.users {
+			var err error
+
+			switch {
+			case u.UserID == 0 && u.Username == identified.Login:
+				_, err = tx.Exec("UPDATE blacklist SET user_id = ? WHERE username = ?", identified.ID, u.Username)
+				u.UserID = identified.ID
+
+			case u.UserID != identified.ID || u.Username == identified.Login:
+				// not affected
+
+			case !taken:
+				_, err = tx.Exec("UPDATE blacklist SET username = ? WHERE username = ?", identified.Login, u.Username)
+				renamed, u.Username = u.Username, identified.Login
+
+			case owner == 0 || owner == identified.ID:
+				_, err = tx.Exec("DELETE FROM blacklist WHERE username = ?", u.Username)
+				if err != nil {
+					return err
+				}
+
+				renamed = u.Username
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			updated = append(updated, u)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		self.log.Error("Could not update the blacklist entry of %s (%d): %s", identified.Login, identified.ID, err.Error())
+		return
+	}
+
+	self.users = updated
+
+	if renamed != "" {
+		self.log.Info("Blacklisted user %s is now known as %s.", renamed, identified.Login)
+	}
+}
+
 type blacklistUser struct {
 	Username string
+	UserID   sql.NullInt64 `db:"user_id"`
 }
 
+// loadBlacklist keeps the current entries if the blacklist cannot be queried.
 func (self *pluginStruct) loadBlacklist() {
-	self.mutex.Lock()
-	defer self.mutex.Unlock()
-
 	list := make([]blacklistUser, 0)
-	self.db.Select(&list, "SELECT username FROM blacklist ORDER BY username")
 
-	self.users = make([]string, 0)
+	err := self.db.Select(&list, "SELECT username, user_id FROM blacklist ORDER BY username")
+	if err != nil {
+		self.log.Error("Could not load the blacklist: %s", err.Error())
+		return
+	}
+
+	users := make([]blacklistEntry, 0, len(list))
 
 	for _, u := range list {
-		self.users = append(self.users, u.Username)
+		entry := blacklistEntry{Username: u.Username, UserID: int(u.UserID.Int64)}
+
+		// migrate entries from before user IDs were tracked
+		if !u.UserID.Valid {
+			if id, known := self.directory.IDByName(u.Username); known {
+				entry.UserID = id
+			}
+		}
+
+		users = append(users, entry)
 	}
+
+	err = self.db.Transaction(func(tx bot.Queryer) error {
+		for idx, u := range list {
+			if u.UserID.Valid || users[idx].UserID == 0 {
+				continue
+			}
+
+			_, err := tx.Exec("UPDATE blacklist SET user_id = ? WHERE username = ?", users[idx].UserID, u.Username)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	// the entries still work by name and are bound again after the next restart
+	if err != nil {
+		self.log.Error("Could not bind the blacklist to user IDs: %s", err.Error())
+
+		for idx, u := range list {
+			users[idx].UserID = int(u.UserID.Int64)
+		}
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.users = users
 }
@@ -5,7 +5,6 @@ import (
 	"sync"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
-	"github.com/sgt-kabukiman/kabukibot/plugin/speedruncom"
 )
 
 type command struct {
@@ -34,27 +33,27 @@ func newPlugin(name string, perm string, srcom bool, srprefix string) *pluginStr
 }
 
 func NewGTAPlugin() *pluginStruct {
-	return newPlugin("gta", "gta_commands", true, "gta_")
+	return newPlugin("gta", "gta.use", true, "gta_")
 }
 
 func NewCrashPlugin() *pluginStruct {
-	return newPlugin("crash", "crash_commands", true, "crash_")
+	return newPlugin("crash", "crash.use", true, "crash_")
 }
 
 func NewChattyPlugin() *pluginStruct {
-	return newPlugin("chatty", "chatty_commands", false, "")
+	return newPlugin("chatty", "chatty.use", false, "")
 }
 
 func NewESAPlugin() *pluginStruct {
-	return newPlugin("esa", "esa_commands", false, "")
+	return newPlugin("esa", "esa.use", false, "")
 }
 
 func NewSDAPlugin() *pluginStruct {
-	return newPlugin("sda", "sda_commands", false, "")
+	return newPlugin("sda", "sda.use", false, "")
 }
 
 func NewEGGSPlugin() *pluginStruct {
-	return newPlugin("eggs", "eggs_commands", false, "")
+	return newPlugin("eggs", "eggs.use", false, "")
 }
 
 func (self *pluginStruct) Name() string {
@@ -87,34 +86,35 @@ func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	}
 
 	if self.srcom {
-		var srPlugin *speedruncom.Plugin
+		self.subscribe(bot.Events())
+	}
+}
 
-		for _, w := range bot.Plugins() {
-			asserted, okay := w.(*speedruncom.Plugin)
-			if okay {
-				srPlugin = asserted
-				break
-			}
-		}
+func (self *pluginStruct) subscribe(events *bot.EventBus) {
+	// the speedrun.com plugin announces the WR commands defined in the bot config file
+	events.Subscribe(bot.EventWorldRecordCommand, self.onWorldRecordCommand)
+}
 
-		if srPlugin == nil {
-			panic("Cannot run the " + self.name + " plugin without the speedrun.com plugin.")
-		}
+func (self *pluginStruct) onWorldRecordCommand(e bot.Event) {
+	event := e.(bot.WorldRecordCommandEvent)
 
-		// collect WR commands defined in the bot config file
-		for cmd, dictKey := range srPlugin.CollectCommands(self.srPrefix) {
-			self.commands[cmd] = command{
-				dictKey: dictKey,
-				fixed:   true,
-			}
-		}
+	if !strings.HasPrefix(event.DictKey, self.srPrefix) {
+		return
+	}
+
+	self.cmdMutex.Lock()
+	defer self.cmdMutex.Unlock()
+
+	self.commands[event.Command] = command{
+		dictKey: event.DictKey,
+		fixed:   true,
 	}
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &worker{
 		acl:    channel.ACL(),
-		dict:   self.dict,
+		dict:   channel.Dictionary(),
 		plugin: self,
 	}
 }
@@ -128,13 +128,22 @@ func (self *pluginStruct) resolveCommand(cmd string) (string, bool) {
 	return c.dictKey, okay
 }
 
-func (self *pluginStruct) defineCommand(cmd string, dictKey string, initialValue string) bool {
+func (self *pluginStruct) author(msg *bot.TextMessage) bot.DictionaryAuthor {
+	return bot.DictionaryAuthor{User: msg.User.Login, Source: self.name}
+}
+
+func (self *pluginStruct) defineCommand(cmd string, dictKey string, initialValue string, author bot.DictionaryAuthor) (bool, error) {
 	self.cmdMutex.Lock()
 	defer self.cmdMutex.Unlock()
 
 	_, exists := self.commands[cmd]
 	if exists {
-		return false
+		return false, nil
+	}
+
+	err := self.dict.SetBy(self.cmdKeyPrefix()+cmd, dictKey, author)
+	if err != nil {
+		return false, err
 	}
 
 	self.commands[cmd] = command{
@@ -142,36 +151,38 @@ func (self *pluginStruct) defineCommand(cmd string, dictKey string, initialValue
 		fixed:   false,
 	}
 
-	self.dict.Set(self.cmdKeyPrefix()+cmd, dictKey)
-
 	if len(initialValue) > 0 {
 		// do not overwrite existing values
 		existing := self.dict.Get(dictKey)
 
 		if len(existing) == 0 {
-			self.dict.Set(dictKey, initialValue)
+			// the command exists now, it just has no content yet
+			self.dict.SetBy(dictKey, initialValue, author)
 		}
 	}
 
-	return true
+	return true, nil
 }
 
-func (self *pluginStruct) undefineCommand(cmd string) bool {
+func (self *pluginStruct) undefineCommand(cmd string, author bot.DictionaryAuthor) (bool, error) {
 	self.cmdMutex.Lock()
 	defer self.cmdMutex.Unlock()
 
 	c, exists := self.commands[cmd]
 	if !exists || c.fixed {
-		return false
+		return false, nil
 	}
 
-	delete(self.commands, cmd)
+	_, err := self.dict.DeleteBy(self.cmdKeyPrefix()+cmd, author)
+	if err != nil {
+		return false, err
+	}
 
-	self.dict.Delete(self.cmdKeyPrefix() + cmd)
+	delete(self.commands, cmd)
 
 	// do not remove the actual FAQ content, in case multiple commands may point to it;
 	// plus it does not relly hurt to have unused dict keys lying around.
 	// self.dict.Delete(targetKey)
 
-	return true
+	return true, nil
 }
@@ -11,7 +11,7 @@ type worker struct {
 	plugin.NilWorker
 
 	acl    *bot.ACL
-	dict   *bot.Dictionary
+	dict   *bot.ChannelDictionary
 	plugin *pluginStruct
 }
 
@@ -26,7 +26,7 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 	name := self.plugin.name
 
-	if msg.IsFromOperator() {
+	if msg.IsFromAdmin(bot.AdminContent) {
 		if msg.IsGlobalCommand(name + "_define") {
 			msg.SetProcessed()
 
@@ -40,7 +40,11 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 			dictKey := args[1]
 			initial := strings.Join(args[2:], " ")
 
-			if self.plugin.defineCommand(cmdName, dictKey, initial) {
+			created, err := self.plugin.defineCommand(cmdName, dictKey, initial, self.plugin.author(msg))
+
+			if err != nil {
+				sender.Respond(plugin.StorageFailure)
+			} else if created {
 				sender.Respond("new command !" + cmdName + " has been created.")
 			} else {
 				dictKey, _ := self.plugin.resolveCommand(cmdName)
@@ -59,7 +63,11 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 			cmdName := args[0]
 
-			if self.plugin.undefineCommand(cmdName) {
+			removed, err := self.plugin.undefineCommand(cmdName, self.plugin.author(msg))
+
+			if err != nil {
+				sender.Respond(plugin.StorageFailure)
+			} else if removed {
 				sender.Respond("the command !" + cmdName + " has been removed.")
 			} else {
 				sender.Respond("!" + cmdName + " does not exist or cannot be removed.")
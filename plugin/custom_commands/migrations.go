@@ -0,0 +1,23 @@
+package custom_commands
+
+import (
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func (self *pluginStruct) Migrations() (string, []bot.Migration) {
+	return "custom_commands", []bot.Migration{
+		{
+			Version:     1,
+			Description: "create custom_commands table",
+			Up: bot.SQL(
+				`CREATE TABLE IF NOT EXISTS custom_commands (
+					channel VARCHAR(64) NOT NULL,
+					command VARCHAR(64) NOT NULL,
+					message TEXT NOT NULL,
+					PRIMARY KEY (channel, command)
+				)`,
+			),
+			Down: bot.SQL("DROP TABLE custom_commands"),
+		},
+	}
+}
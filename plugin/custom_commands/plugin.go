@@ -1,12 +1,12 @@
 package custom_commands
 
 import (
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 )
 
 type pluginStruct struct {
-	db *sqlx.DB
+	db  bot.Database
+	log bot.Logger
 }
 
 func NewPlugin() *pluginStruct {
@@ -19,6 +19,7 @@ func (self *pluginStruct) Name() string {
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.db = bot.Database()
+	self.log = bot.PluginLogger(self.Name())
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
@@ -26,5 +27,6 @@ func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 		channel: channel,
 		acl:     channel.ACL(),
 		db:      self.db,
+		log:     self.log,
 	}
 }
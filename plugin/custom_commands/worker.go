@@ -2,24 +2,21 @@ package custom_commands
 
 import (
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
-	"github.com/sgt-kabukiman/kabukibot/plugin/acl"
 )
 
 type worker struct {
 	plugin.NilWorker
 
-	channel   bot.Channel
-	acl       *bot.ACL
-	aclWorker *acl.Worker
-	db        *sqlx.DB
-	commands  map[string]string
+	channel  bot.Channel
+	acl      *bot.ACL
+	db       bot.Database
+	log      bot.Logger
+	commands map[string]string
 }
 
 type ccDbStruct struct {
@@ -29,29 +26,21 @@ type ccDbStruct struct {
 
 func (self *worker) Enable() {
 	list := make([]ccDbStruct, 0)
-	self.db.Select(&list, "SELECT command, message FROM custom_commands WHERE channel = ? ORDER BY command", self.channel.Name())
+
+	err := self.db.Select(&list, "SELECT command, message FROM custom_commands WHERE channel = ? ORDER BY command", self.channel.Name())
+	if err != nil {
+		self.log.Error("Could not load custom commands for %s: %s", self.channel.Name(), err.Error())
+	}
 
 	self.commands = make(map[string]string)
 
 	for _, item := range list {
 		self.commands[item.Command] = item.Message
 	}
-
-	for _, w := range self.channel.Workers() {
-		asserted, okay := w.(*acl.Worker)
-		if okay {
-			self.aclWorker = asserted
-			break
-		}
-	}
-
-	if self.aclWorker == nil {
-		panic("Cannot run the custom commands plugin without the ACL plugin.")
-	}
 }
 
 func (self *worker) Permissions() []string {
-	permissions := []string{"configure_custom_commands", "configure_custom_commands_acl", "list_custom_commands"}
+	permissions := []string{"custom_commands.configure", "custom_commands.configure_acl", "custom_commands.list"}
 
 	for cmd := range self.commands {
 		permissions = append(permissions, permissionForCommand(cmd))
@@ -91,6 +80,8 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		fallthrough
 	case "cc_deny":
 		fallthrough
+	case "cc_revoke":
+		fallthrough
 	case "cc_get":
 		fallthrough
 	case "cc_set":
@@ -110,9 +101,11 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 		switch command {
 		case "cc_allow":
-			self.respondAllowDeny("allow", cc, args[1:], sender)
+			self.respondACL(plugin.ACLAllow, cc, args[1:], sender)
 		case "cc_deny":
-			self.respondAllowDeny("deny", cc, args[1:], sender)
+			self.respondACL(plugin.ACLDeny, cc, args[1:], sender)
+		case "cc_revoke":
+			self.respondACL(plugin.ACLRevoke, cc, args[1:], sender)
 		case "cc_get":
 			self.respondGet(cc, sender)
 		case "cc_set":
@@ -140,7 +133,7 @@ func (self *worker) respondList(sender bot.Sender) {
 	}
 }
 
-func (self *worker) respondAllowDeny(kind string, cmd string, args []string, sender bot.Sender) {
+func (self *worker) respondACL(action plugin.ACLAction, cmd string, args []string, sender bot.Sender) {
 	_, exists := self.commands[cmd]
 	if !exists {
 		sender.Respond("there is no custom command named '" + cmd + "'.")
@@ -149,7 +142,7 @@ func (self *worker) respondAllowDeny(kind string, cmd string, args []string, sen
 
 	permission := permissionForCommand(cmd)
 
-	self.aclWorker.HandleAllowDeny(kind == "allow", permission, args, sender, "!"+cmd)
+	plugin.HandleACLCommand(self.acl, action, permission, args, sender, "!"+cmd)
 }
 
 func (self *worker) respondGet(cmd string, sender bot.Sender) {
@@ -173,67 +166,118 @@ func (self *worker) respondSet(cmd string, args []string, sender bot.Sender) {
 		return
 	}
 
+	created, err := self.SetCommand(cmd, strings.Join(args, " "))
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+		return
+	}
+
+	if created {
+		sender.Respond("command !" + cmd + " has been created. Do not forget to set permissions via `!cc_allow " + cmd + " $mods,someone,etc`.")
+	} else {
+		sender.Respond("command !" + cmd + " has been updated.")
+	}
+}
+
+func (self *worker) respondDelete(cmd string, sender bot.Sender) {
+	deleted, err := self.DeleteCommand(cmd)
+
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+	} else if deleted {
+		sender.Respond("!" + cmd + " has been deleted.")
+	} else {
+		sender.Respond("there is no custom command named '" + cmd + "'.")
+	}
+}
+
+// Commands returns a copy of the channel's commands.
+func (self *worker) Commands() map[string]string {
+	commands := make(map[string]string, len(self.commands))
+
+	for cmd, response := range self.commands {
+		commands[cmd] = response
+	}
+
+	return commands
+}
+
+// SetCommand creates or updates a command and tells whether it has been created.
+// The command name must already be normalized.
+func (self *worker) SetCommand(cmd string, response string) (bool, error) {
 	_, exists := self.commands[cmd]
-	response := strings.Join(args, " ")
 
-	self.commands[cmd] = response
+	var err error
 
 	if exists {
-		sender.Respond("command !" + cmd + " has been updated.")
-
-		_, err := self.db.Exec("UPDATE custom_commands SET message = ? WHERE channel = ? AND command = ?", response, self.channel.Name(), cmd)
-		if err != nil {
-			log.Fatal("Could not update new custom command: " + err.Error())
-		}
+		_, err = self.db.Exec("UPDATE custom_commands SET message = ? WHERE channel = ? AND command = ?", response, self.channel.Name(), cmd)
 	} else {
-		sender.Respond("command !" + cmd + " has been created. Do not forget to set permissions via `!cc_allow " + cmd + " $mods,someone,etc`.")
+		_, err = self.db.Exec("INSERT INTO custom_commands (channel, command, message) VALUES (?, ?, ?)", self.channel.Name(), cmd, response)
+	}
 
-		_, err := self.db.Exec("INSERT INTO custom_commands (channel, command, message) VALUES (?, ?, ?)", self.channel.Name(), cmd, response)
-		if err != nil {
-			log.Fatal("Could not store new custom command: " + err.Error())
-		}
+	if err != nil {
+		self.log.Error("Could not store custom command !%s in %s: %s", cmd, self.channel.Name(), err.Error())
+		return false, err
 	}
+
+	self.commands[cmd] = response
+
+	return !exists, nil
 }
 
-func (self *worker) respondDelete(cmd string, sender bot.Sender) {
+// DeleteCommand removes a command and its permissions.
+func (self *worker) DeleteCommand(cmd string) (bool, error) {
 	_, exists := self.commands[cmd]
 	if !exists {
-		sender.Respond("there is no custom command named '" + cmd + "'.")
-		return
+		return false, nil
 	}
 
-	sender.Respond("!" + cmd + " has been deleted.")
+	_, err := self.db.Exec("DELETE FROM custom_commands WHERE channel = ? AND command = ?", self.channel.Name(), cmd)
+	if err != nil {
+		self.log.Error("Could not delete custom command !%s in %s: %s", cmd, self.channel.Name(), err.Error())
+		return false, err
+	}
 
 	delete(self.commands, cmd)
 
-	// cleanup database
-	_, err := self.db.Exec("DELETE FROM custom_commands WHERE channel = ? AND command = ?", self.channel.Name(), cmd)
+	// cleanup ACL entries; leftovers do no harm and are replaced when the command
+	// is created again
+	err = self.acl.DeletePermission(permissionForCommand(cmd))
 	if err != nil {
-		log.Fatal("Could not delete new custom command: " + err.Error())
+		self.log.Error("Could not remove the permissions of !%s in %s: %s", cmd, self.channel.Name(), err.Error())
+	}
+
+	return true, nil
+}
+
+// NormalizeCommand cleans up a command name; reserved and invalid names become empty.
+func (self *worker) NormalizeCommand(cmd string) string {
+	cmd = normalizeCommand(cmd)
+	if isPluginCommand(cmd) {
+		return ""
 	}
 
-	// cleanup ACL entries
-	self.acl.DeletePermission(permissionForCommand(cmd))
+	return cmd
 }
 
 func isPluginCommand(cmd string) bool {
-	return cmd == "cc_set" || cmd == "cc_get" || cmd == "cc_del" || cmd == "cc_list" || cmd == "cc_allow" || cmd == "cc_deny"
+	return cmd == "cc_set" || cmd == "cc_get" || cmd == "cc_del" || cmd == "cc_list" || cmd == "cc_allow" || cmd == "cc_deny" || cmd == "cc_revoke"
 }
 
 func requiredPermission(cmd string) string {
-	if cmd == "cc_allow" || cmd == "cc_deny" {
-		return "configure_custom_commands_acl"
+	if cmd == "cc_allow" || cmd == "cc_deny" || cmd == "cc_revoke" {
+		return "custom_commands.configure_acl"
 	} else if cmd == "cc_list" {
-		return "list_custom_commands"
+		return "custom_commands.list"
 	} else if isPluginCommand(cmd) {
-		return "configure_custom_commands"
+		return "custom_commands.configure"
 	} else {
 		return permissionForCommand(cmd)
 	}
 }
 
 func permissionForCommand(cmd string) string {
-	return "use_" + cmd + "_cmd"
+	return "custom_commands.use." + cmd
 }
 
 var ccCommandCleaner = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
@@ -1,7 +1,9 @@
 package dictionary
 
 import (
+	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
@@ -28,8 +30,7 @@ func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 }
 
 func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
-	// op-only
-	if !msg.IsFromOperator() {
+	if !msg.IsFromAdmin(bot.AdminDictionary) {
 		return
 	}
 
@@ -39,32 +40,75 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 		self.handleGet(msg, sender)
 	} else if msg.IsGlobalCommand("dict_keys") {
 		self.handleKeys(msg, sender)
+	} else if msg.IsGlobalCommand("dict_search") {
+		self.handleSearch(msg, sender)
+	} else if msg.IsGlobalCommand("dict_del") {
+		self.handleDelete(msg, sender)
+	} else if msg.IsGlobalCommand("dict_history") {
+		self.handleHistory(msg, sender)
+	} else if msg.IsGlobalCommand("dict_revert") {
+		self.handleRevert(msg, sender)
 	}
 }
 
-func (self *pluginStruct) handleSet(msg *bot.TextMessage, sender bot.Sender) {
+// scope parses the optional "--channel [#name]" flag in front of the regular
+// arguments. Without it, the global dictionary is used; with it, the entries of the
+// given (or current) channel.
+func (self *pluginStruct) scope(msg *bot.TextMessage) ([]string, *bot.ChannelDictionary) {
 	args := msg.Arguments()
 
+	if len(args) == 0 || args[0] != "--channel" {
+		return args, nil
+	}
+
+	channel := msg.Channel
+	args = args[1:]
+
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		channel = strings.ToLower(args[0])
+		args = args[1:]
+	}
+
+	return args, self.dict.Channel(channel)
+}
+
+func (self *pluginStruct) handleSet(msg *bot.TextMessage, sender bot.Sender) {
+	args, scoped := self.scope(msg)
+
 	if len(args) < 2 {
 		sender.Respond("you have not given any text.")
 		return
 	}
 
-	key := args[0]
+	key := strings.ToLower(args[0])
 	value := strings.Join(args[1:], " ")
-	exists := self.dict.Has(key)
+	suffix := ""
+	exists := false
+
+	var err error
 
-	self.dict.Set(key, value)
+	author := authorOf(msg)
 
-	if exists {
-		sender.Respond("replaced '" + key + "' with '" + value + "'.")
+	if scoped == nil {
+		exists = self.dict.Has(key)
+		err = self.dict.SetBy(key, value, author)
 	} else {
-		sender.Respond("added '" + key + "' with '" + value + "'.")
+		exists = scoped.HasOwn(key)
+		err = scoped.SetBy(key, value, author)
+		suffix = " in " + scoped.Channel()
+	}
+
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+	} else if exists {
+		sender.Respond("replaced '" + key + "' with '" + value + "'" + suffix + ".")
+	} else {
+		sender.Respond("added '" + key + "' with '" + value + "'" + suffix + ".")
 	}
 }
 
 func (self *pluginStruct) handleGet(msg *bot.TextMessage, sender bot.Sender) {
-	args := msg.Arguments()
+	args, scoped := self.scope(msg)
 
 	if len(args) < 1 {
 		sender.Respond("you have not given a key.")
@@ -73,21 +117,273 @@ func (self *pluginStruct) handleGet(msg *bot.TextMessage, sender bot.Sender) {
 
 	key := strings.ToLower(args[0])
 
-	if self.dict.Has(key) {
+	switch {
+	case scoped == nil && self.dict.Has(key):
 		sender.Respond(key + " = " + self.dict.Get(key))
-	} else {
+
+	case scoped != nil && scoped.HasOwn(key):
+		sender.Respond(key + " = " + scoped.Get(key) + " (in " + scoped.Channel() + ")")
+
+	case scoped != nil && scoped.Has(key):
+		sender.Respond(key + " = " + scoped.Get(key) + " (inherited from the global dictionary)")
+
+	default:
 		sender.Respond("the key '" + key + "' does not exist.")
 	}
 }
 
 func (self *pluginStruct) handleKeys(msg *bot.TextMessage, sender bot.Sender) {
-	keys := self.dict.Keys()
+	args, scoped := self.scope(msg)
+	args, page := extractPage(args)
+
+	prefix := ""
+	if len(args) > 0 {
+		prefix = strings.ToLower(args[0])
+	}
+
+	keys := make([]string, 0)
+
+	for _, key := range self.keys(scoped) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
 	if len(keys) == 0 {
-		sender.Respond("there are no keys yet.")
+		if prefix == "" {
+			sender.Respond("there are no keys yet.")
+		} else {
+			sender.Respond("there are no keys starting with '" + prefix + "'.")
+		}
+
+		return
+	}
+
+	respondPaged(sender, "keys", keys, page)
+}
+
+func (self *pluginStruct) handleSearch(msg *bot.TextMessage, sender bot.Sender) {
+	args, scoped := self.scope(msg)
+	args, page := extractPage(args)
+
+	if len(args) < 1 {
+		sender.Respond("you have not given a search term.")
 		return
 	}
 
+	term := strings.ToLower(strings.Join(args, " "))
+	keys := make([]string, 0)
+
+	for _, key := range self.keys(scoped) {
+		value := ""
+
+		if scoped == nil {
+			value = self.dict.Get(key)
+		} else {
+			value = scoped.Get(key)
+		}
+
+		if strings.Contains(key, term) || strings.Contains(strings.ToLower(value), term) {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		sender.Respond("nothing matches '" + term + "'.")
+		return
+	}
+
+	respondPaged(sender, "matching keys", keys, page)
+}
+
+func (self *pluginStruct) handleDelete(msg *bot.TextMessage, sender bot.Sender) {
+	args, scoped := self.scope(msg)
+
+	if len(args) < 1 {
+		sender.Respond("you have not given a key.")
+		return
+	}
+
+	key := strings.ToLower(args[0])
+	suffix := ""
+	deleted := false
+
+	var err error
+
+	if scoped == nil {
+		deleted, err = self.dict.DeleteBy(key, authorOf(msg))
+	} else {
+		deleted, err = scoped.DeleteBy(key, authorOf(msg))
+		suffix = " in " + scoped.Channel()
+	}
+
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+	} else if deleted {
+		sender.Respond("deleted '" + key + "'" + suffix + ".")
+	} else {
+		sender.Respond("the key '" + key + "' does not exist" + suffix + ".")
+	}
+}
+
+func (self *pluginStruct) keys(scoped *bot.ChannelDictionary) []string {
+	keys := self.dict.Keys()
+	if scoped != nil {
+		keys = scoped.Keys()
+	}
+
 	sort.Strings(keys)
 
-	sender.Respond("keys are: " + bot.HumanJoin(keys, ", "))
+	return keys
+}
+
+const pageSize = 20
+
+// extractPage treats a trailing number as the page to show, unless it is the only
+// argument; a lone number is what the user is looking for.
+func extractPage(args []string) ([]string, int) {
+	if len(args) < 2 {
+		return args, 1
+	}
+
+	page, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || page < 1 {
+		return args, 1
+	}
+
+	return args[:len(args)-1], page
+}
+
+func respondPaged(sender bot.Sender, label string, keys []string, page int) {
+	pages := (len(keys) + pageSize - 1) / pageSize
+
+	if page > pages {
+		sender.Respond(fmt.Sprintf("there is no page %d, there are only %d.", page, pages))
+		return
+	}
+
+	if pages == 1 {
+		sender.Respond(label + " are: " + bot.HumanJoin(keys, ", "))
+		return
+	}
+
+	from := (page - 1) * pageSize
+	to := from + pageSize
+
+	if to > len(keys) {
+		to = len(keys)
+	}
+
+	sender.Respond(fmt.Sprintf("%s (page %d of %d) are: %s", label, page, pages, bot.HumanJoin(keys[from:to], ", ")))
+}
+
+const historyLength = 5
+
+// revisions is implemented by both the global and the channel dictionary.
+type revisions interface {
+	History(string, int) ([]bot.DictionaryRevision, error)
+	Revert(string, int, bot.DictionaryAuthor) (bot.DictionaryRevision, bool, error)
+}
+
+func (self *pluginStruct) revisions(scoped *bot.ChannelDictionary) revisions {
+	if scoped == nil {
+		return self.dict
+	}
+
+	return scoped
+}
+
+func (self *pluginStruct) handleHistory(msg *bot.TextMessage, sender bot.Sender) {
+	args, scoped := self.scope(msg)
+
+	if len(args) < 1 {
+		sender.Respond("you have not given a key.")
+		return
+	}
+
+	key := strings.ToLower(args[0])
+	history, err := self.revisions(scoped).History(key, historyLength)
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+		return
+	}
+
+	if len(history) == 0 {
+		sender.Respond("there is no history for '" + key + "'.")
+		return
+	}
+
+	list := make([]string, 0, len(history))
+
+	for _, rev := range history {
+		change := "'" + rev.NewValue + "'"
+		if rev.Deleted {
+			change = "deleted"
+		}
+
+		list = append(list, fmt.Sprintf("#%d %s (%s, %s)", rev.Revision, change, formatAuthor(rev.Author), rev.Time.Format("2006-01-02 15:04")))
+	}
+
+	sender.Respond("history of '" + key + "': " + strings.Join(list, "; "))
+}
+
+func (self *pluginStruct) handleRevert(msg *bot.TextMessage, sender bot.Sender) {
+	args, scoped := self.scope(msg)
+
+	if len(args) < 1 {
+		sender.Respond("you have not given a key.")
+		return
+	}
+
+	key := strings.ToLower(args[0])
+	revision := 0
+
+	if len(args) > 1 {
+		parsed, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil || parsed < 1 {
+			sender.Respond("invalid revision given. Expected a number like 3.")
+			return
+		}
+
+		revision = parsed
+	}
+
+	rev, okay, err := self.revisions(scoped).Revert(key, revision, authorOf(msg))
+
+	switch {
+	case err != nil:
+		sender.Respond(plugin.StorageFailure)
+
+	case !okay && revision == 0:
+		sender.Respond("there is no history for '" + key + "'.")
+
+	case !okay:
+		sender.Respond(fmt.Sprintf("there is no revision #%d of '%s'.", revision, key))
+
+	case revision == 0:
+		sender.Respond(fmt.Sprintf("undid revision #%d of '%s'.", rev.Revision, key))
+
+	default:
+		sender.Respond(fmt.Sprintf("restored '%s' to revision #%d.", key, rev.Revision))
+	}
+}
+
+func authorOf(msg *bot.TextMessage) bot.DictionaryAuthor {
+	return bot.DictionaryAuthor{User: msg.User.Login, Source: "dictionary"}
+}
+
+func formatAuthor(author bot.DictionaryAuthor) string {
+	switch {
+	case author.User != "" && author.Source != "":
+		return author.User + " via " + author.Source
+
+	case author.User != "":
+		return author.User
+
+	case author.Source != "":
+		return author.Source
+
+	default:
+		return "unknown"
+	}
 }
@@ -0,0 +1,27 @@
+package domain_ban
+
+import (
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+// TODO: The bans keep their own table instead of using the channel settings. They
+// are a list of domains with a counter each, which the key/value settings cannot
+// hold; they can move once the settings support lists.
+func (self *pluginStruct) Migrations() (string, []bot.Migration) {
+	return "domain_ban", []bot.Migration{
+		{
+			Version:     1,
+			Description: "create domain_ban table",
+			Up: bot.SQL(
+				`CREATE TABLE IF NOT EXISTS domain_ban (
+					channel VARCHAR(64) NOT NULL,
+					domain  VARCHAR(255) NOT NULL,
+					bantype VARCHAR(32) NOT NULL,
+					counter INT NOT NULL DEFAULT 0,
+					PRIMARY KEY (channel, domain)
+				)`,
+			),
+			Down: bot.SQL("DROP TABLE domain_ban"),
+		},
+	}
+}
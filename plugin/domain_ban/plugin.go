@@ -1,12 +1,13 @@
 package domain_ban
 
 import (
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 )
 
 type pluginStruct struct {
-	db *sqlx.DB
+	db      bot.Database
+	log     bot.Logger
+	flusher *bot.FlushScheduler
 }
 
 func NewPlugin() *pluginStruct {
@@ -19,6 +20,8 @@ func (self *pluginStruct) Name() string {
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.db = bot.Database()
+	self.log = bot.PluginLogger(self.Name())
+	self.flusher = bot.Flusher()
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
@@ -26,5 +29,7 @@ func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 		channel: channel.Name(),
 		acl:     channel.ACL(),
 		db:      self.db,
+		log:     self.log,
+		flusher: self.flusher,
 	}
 }
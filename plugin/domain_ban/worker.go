@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/dchest/validator"
-	"github.com/jmoiron/sqlx"
 	"github.com/mvdan/xurls"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
@@ -28,13 +27,15 @@ type ban struct {
 type worker struct {
 	plugin.NilWorker
 
-	channel     string
-	acl         *bot.ACL
-	db          *sqlx.DB
-	bans        map[string]ban
-	syncing     chan struct{}
-	stopSyncing chan struct{}
-	mutex       sync.RWMutex
+	channel  string
+	acl      *bot.ACL
+	db       bot.Database
+	log      bot.Logger
+	flusher  *bot.FlushScheduler
+	bans     map[string]ban
+	dirty    map[string]bool // domains changed since the last flush
+	mutex    sync.RWMutex
+	flushing sync.Mutex
 }
 
 type domainBanDbStruct struct {
@@ -44,10 +45,21 @@ type domainBanDbStruct struct {
 }
 
 func (self *worker) Enable() {
+	// if we for some reason are already flushing, stop now
+	self.flusher.Unregister(self)
+
 	list := make([]domainBanDbStruct, 0)
-	self.db.Select(&list, "SELECT domain, bantype, counter FROM domain_ban WHERE channel = ? ORDER BY domain", self.channel)
+
+	err := self.db.Select(&list, "SELECT domain, bantype, counter FROM domain_ban WHERE channel = ? ORDER BY domain", self.channel)
+	if err != nil {
+		self.log.Error("Could not load domain bans for %s: %s", self.channel, err.Error())
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 
 	self.bans = make(map[string]ban)
+	self.dirty = make(map[string]bool)
 
 	for _, item := range list {
 		b := ban{
@@ -71,24 +83,23 @@ func (self *worker) Enable() {
 		self.bans[item.Domain] = b
 	}
 
-	// if we for some reason are already syncing, stop now
-	if self.syncing != nil {
-		self.Disable()
-	}
+	self.flusher.Register("domain_ban in "+self.channel, self)
+}
 
-	self.syncing = make(chan struct{})
-	self.stopSyncing = make(chan struct{})
+func (self *worker) Disable() {
+	self.flusher.Unregister(self)
+}
 
-	go self.worker()
+func (self *worker) Part() {
+	self.flusher.Unregister(self)
 }
 
-func (self *worker) Disable() {
-	close(self.stopSyncing)
-	<-self.syncing
+func (self *worker) Shutdown() {
+	self.flusher.Unregister(self)
 }
 
 func (self *worker) Permissions() []string {
-	return []string{"configure_domain_bans"}
+	return []string{"moderation.domain_bans"}
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -98,7 +109,7 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 	cmd := msg.Command()
 	if cmd == "ban_domain" || cmd == "unban_domain" || cmd == "banned_domains" {
-		if self.acl.IsAllowed(msg.User, "configure_domain_bans") {
+		if self.acl.IsAllowed(msg.User, "moderation.domain_bans") {
 			if cmd == "banned_domains" {
 				self.bannedDomains(sender)
 			} else {
@@ -186,6 +197,7 @@ func (self *worker) banDomain(domain string, args []string, sender bot.Sender) {
 	b.Timeout = timeout
 
 	self.bans[domain] = b
+	self.dirty[domain] = true
 
 	self.mutex.Unlock()
 
@@ -195,7 +207,7 @@ func (self *worker) banDomain(domain string, args []string, sender bot.Sender) {
 		sender.Respond(fmt.Sprintf("links to %s will be *banned*.", domain))
 	}
 
-	// let the worker take care of writing this to the database
+	// the flush scheduler takes care of writing this to the database
 }
 
 func (self *worker) unbanDomain(domain string, sender bot.Sender) {
@@ -215,9 +227,10 @@ func (self *worker) unbanDomain(domain string, sender bot.Sender) {
 	}
 
 	delete(self.bans, domain)
+	self.dirty[domain] = true
 	self.mutex.Unlock()
 
-	// let the worker take care of writing this to the database
+	// the flush scheduler takes care of writing this to the database
 }
 
 func (self *worker) textMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -290,7 +303,7 @@ func (self *worker) textMessage(msg *bot.TextMessage, sender bot.Sender) {
 	}
 
 	// kick the offender
-	name := strings.ToLower(msg.User.Name)
+	name := msg.User.Login
 
 	if action.Type == "ban" {
 		sender.Ban(name)
@@ -306,36 +319,79 @@ func (self *worker) textMessage(msg *bot.TextMessage, sender bot.Sender) {
 	// and count that we hit one
 	action.Counter++
 	self.bans[worstDomain] = action
+	self.dirty[worstDomain] = true
 }
 
-func (self *worker) worker() {
-	defer close(self.syncing)
+// Flush writes the bans that changed since the last flush. Only one flush runs at
+// a time, so that older states never overwrite newer ones.
+func (self *worker) Flush() error {
+	self.flushing.Lock()
+	defer self.flushing.Unlock()
 
-	for {
-		select {
-		case <-time.After(5 * time.Minute):
-			self.sync()
+	self.mutex.Lock()
 
-		case <-self.stopSyncing:
-			self.sync()
-			return
+	changed := make(map[string]ban, len(self.dirty))
+	removed := make([]string, 0)
+
+	for domain := range self.dirty {
+		if b, exists := self.bans[domain]; exists {
+			changed[domain] = b
+		} else {
+			removed = append(removed, domain)
 		}
 	}
-}
 
-func (self *worker) sync() {
-	self.mutex.RLock()
-	defer self.mutex.RUnlock()
+	self.dirty = make(map[string]bool)
 
-	self.db.Exec("DELETE FROM domain_ban WHERE channel = ?", self.channel)
+	self.mutex.Unlock()
 
-	for domain, ban := range self.bans {
-		t := ban.Type
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	upsert := self.db.Dialect().Upsert("domain_ban", []string{"channel", "domain"}, []string{"bantype", "counter"})
+
+	err := self.db.Transaction(func(tx bot.Queryer) error {
+		for domain, b := range changed {
+			_, err := tx.Exec(upsert, self.channel, domain, b.storedType(), b.Counter)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, domain := range removed {
+			_, err := tx.Exec("DELETE FROM domain_ban WHERE channel = ? AND domain = ?", self.channel, domain)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 
-		if t == "timeout" {
-			t += ":" + bot.FormatDuration(ban.Timeout, false)
+	// mark everything as changed again, so the next flush picks it up
+	if err != nil {
+		self.mutex.Lock()
+
+		for domain := range changed {
+			self.dirty[domain] = true
+		}
+
+		for _, domain := range removed {
+			self.dirty[domain] = true
 		}
 
-		self.db.Exec("INSERT INTO domain_ban (channel, domain, bantype, counter) VALUES (?, ?, ?)", self.channel, domain, t, ban.Counter)
+		self.mutex.Unlock()
 	}
+
+	return err
+}
+
+// storedType is either "ban" or "timeout:N", with N being the duration to time out
+func (self ban) storedType() string {
+	if self.Type == "timeout" {
+		return self.Type + ":" + bot.FormatDuration(self.Timeout, false)
+	}
+
+	return self.Type
 }
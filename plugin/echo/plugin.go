@@ -21,7 +21,7 @@ func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 }
 
 func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
-	if msg.IsFromOperator() && (msg.IsGlobalCommand("echo") || msg.IsGlobalCommand("say")) {
+	if msg.IsFromAdmin(bot.AdminEcho) && (msg.IsGlobalCommand("echo") || msg.IsGlobalCommand("say")) {
 		response := strings.Join(msg.Arguments(), " ")
 
 		if len(response) == 0 {
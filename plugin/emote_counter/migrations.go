@@ -0,0 +1,23 @@
+package emote_counter
+
+import (
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+func (self *pluginStruct) Migrations() (string, []bot.Migration) {
+	return "emote_counter", []bot.Migration{
+		{
+			Version:     1,
+			Description: "create emote_counter table",
+			Up: bot.SQL(
+				`CREATE TABLE IF NOT EXISTS emote_counter (
+					channel VARCHAR(64) NOT NULL,
+					emote   VARCHAR(128) NOT NULL,
+					counter INT NOT NULL DEFAULT 0,
+					PRIMARY KEY (channel, emote)
+				)`,
+			),
+			Down: bot.SQL("DROP TABLE emote_counter"),
+		},
+	}
+}
@@ -3,12 +3,13 @@ package emote_counter
 import (
 	"sync"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 )
 
 type pluginStruct struct {
-	db *sqlx.DB
+	db      bot.Database
+	log     bot.Logger
+	flusher *bot.FlushScheduler
 }
 
 func NewPlugin() *pluginStruct {
@@ -21,16 +22,17 @@ func (self *pluginStruct) Name() string {
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.db = bot.Database()
+	self.log = bot.PluginLogger(self.Name())
+	self.flusher = bot.Flusher()
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &worker{
-		channel:     channel.Name(),
-		acl:         channel.ACL(),
-		db:          self.db,
-		syncing:     nil,
-		stopSyncing: nil,
-		queue:       make(chan *bot.TextMessage, 50),
-		mutex:       sync.RWMutex{},
+		channel: channel.Name(),
+		acl:     channel.ACL(),
+		db:      self.db,
+		log:     self.log,
+		flusher: self.flusher,
+		mutex:   sync.RWMutex{},
 	}
 }
@@ -5,10 +5,8 @@ import (
 	"sort"
 	"strconv"
 	"sync"
-	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/plugin"
 )
@@ -18,14 +16,16 @@ type emoteCountMap map[string]int
 type worker struct {
 	plugin.NilWorker
 
-	channel     string
-	acl         *bot.ACL
-	db          *sqlx.DB
-	stats       emoteCountMap
-	queue       chan *bot.TextMessage
-	syncing     chan struct{}
-	stopSyncing chan struct{}
-	mutex       sync.RWMutex
+	channel  string
+	acl      *bot.ACL
+	db       bot.Database
+	log      bot.Logger
+	flusher  *bot.FlushScheduler
+	stats    emoteCountMap
+	dirty    map[string]bool // emotes counted since the last flush
+	reset    bool            // whether the stored counters must be deleted
+	mutex    sync.RWMutex
+	flushing sync.Mutex
 }
 
 type emoteDbStruct struct {
@@ -34,33 +34,44 @@ type emoteDbStruct struct {
 }
 
 func (self *worker) Enable() {
+	// if we for some reason are already flushing, stop now
+	self.flusher.Unregister(self)
+
 	list := make([]emoteDbStruct, 0)
-	self.db.Select(&list, "SELECT emote, counter FROM emote_counter WHERE channel = ?", self.channel)
 
+	err := self.db.Select(&list, "SELECT emote, counter FROM emote_counter WHERE channel = ?", self.channel)
+	if err != nil {
+		self.log.Error("Could not load emote counts for %s: %s", self.channel, err.Error())
+	}
+
+	self.mutex.Lock()
 	self.stats = make(emoteCountMap)
+	self.dirty = make(map[string]bool)
+	self.reset = false
 
 	for _, item := range list {
 		self.stats[item.Emote] = item.Counter
 	}
 
-	// if we for some reason are already syncing, stop now
-	if self.syncing != nil {
-		self.Disable()
-	}
-
-	self.syncing = make(chan struct{})
-	self.stopSyncing = make(chan struct{})
+	self.mutex.Unlock()
 
-	go self.worker()
+	self.flusher.Register("emote_counter in "+self.channel, self)
 }
 
 func (self *worker) Disable() {
-	close(self.stopSyncing)
-	<-self.syncing
+	self.flusher.Unregister(self)
+}
+
+func (self *worker) Part() {
+	self.flusher.Unregister(self)
+}
+
+func (self *worker) Shutdown() {
+	self.flusher.Unregister(self)
 }
 
 func (self *worker) Permissions() []string {
-	return []string{"use_emote_counter"}
+	return []string{"emote_counter.use"}
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -78,12 +89,12 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		self.handleResetCommand(msg, sender)
 		msg.SetProcessed()
 	} else {
-		self.handleRegularText(msg, sender)
+		self.countEmotes(msg)
 	}
 }
 
 func (self *worker) handleTopEmotesCommand(msg *bot.TextMessage, sender bot.Sender) {
-	if !self.acl.IsAllowed(msg.User, "use_emote_counter") {
+	if !self.acl.IsAllowed(msg.User, "emote_counter.use") {
 		return
 	}
 
@@ -124,7 +135,7 @@ func (self *worker) handleTopEmotesCommand(msg *bot.TextMessage, sender bot.Send
 }
 
 func (self *worker) handleEmoteCountCommand(msg *bot.TextMessage, sender bot.Sender) {
-	if !self.acl.IsAllowed(msg.User, "use_emote_counter") {
+	if !self.acl.IsAllowed(msg.User, "emote_counter.use") {
 		return
 	}
 
@@ -148,52 +159,85 @@ func (self *worker) handleEmoteCountCommand(msg *bot.TextMessage, sender bot.Sen
 }
 
 func (self *worker) handleResetCommand(msg *bot.TextMessage, sender bot.Sender) {
-	if !self.acl.IsAllowed(msg.User, "use_emote_counter") {
+	if !self.acl.IsAllowed(msg.User, "emote_counter.use") {
 		return
 	}
 
 	self.mutex.Lock()
 	self.stats = make(emoteCountMap)
+	self.dirty = make(map[string]bool)
+	self.reset = true
 	self.mutex.Unlock()
 
-	self.sync()
+	// in case of an error, the next regular flush will try again
+	if self.Flush() != nil {
+		sender.Respond(plugin.StorageFailure)
+		return
+	}
 
 	sender.Respond("the emote counter has been reset.")
 }
 
-func (self *worker) handleRegularText(msg *bot.TextMessage, sender bot.Sender) {
-	// anticipating that finding emotes will be complex when taking FFZ into account, we put
-	// this into the worker goroutine
-	self.queue <- msg
-}
-
-func (self *worker) worker() {
-	defer close(self.syncing)
+// Flush writes the counters that changed since the last flush. Only one flush runs
+// at a time, so that older counts never overwrite newer ones.
+func (self *worker) Flush() error {
+	self.flushing.Lock()
+	defer self.flushing.Unlock()
 
-	for {
-		select {
-		case <-time.After(5 * time.Minute):
-			self.sync()
+	self.mutex.Lock()
 
-		case msg := <-self.queue:
-			self.countEmotes(msg)
+	reset := self.reset
+	changed := make(emoteCountMap, len(self.dirty))
 
-		case <-self.stopSyncing:
-			self.sync()
-			return
+	for emote := range self.dirty {
+		if counter, exists := self.stats[emote]; exists {
+			changed[emote] = counter
 		}
 	}
-}
 
-func (self *worker) sync() {
-	self.mutex.RLock()
-	defer self.mutex.RUnlock()
+	self.reset = false
+	self.dirty = make(map[string]bool)
 
-	self.db.Exec("DELETE FROM emote_counter WHERE channel = ?", self.channel)
+	self.mutex.Unlock()
 
-	for emote, counter := range self.stats {
-		self.db.Exec("INSERT INTO emote_counter (channel, emote, counter) VALUES (?, ?, ?)", self.channel, emote, counter)
+	if !reset && len(changed) == 0 {
+		return nil
 	}
+
+	upsert := self.db.Dialect().Upsert("emote_counter", []string{"channel", "emote"}, []string{"counter"})
+
+	err := self.db.Transaction(func(tx bot.Queryer) error {
+		if reset {
+			_, err := tx.Exec("DELETE FROM emote_counter WHERE channel = ?", self.channel)
+			if err != nil {
+				return err
+			}
+		}
+
+		for emote, counter := range changed {
+			_, err := tx.Exec(upsert, self.channel, emote, counter)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	// mark everything as changed again, so the next flush picks it up
+	if err != nil {
+		self.mutex.Lock()
+
+		self.reset = self.reset || reset
+
+		for emote := range changed {
+			self.dirty[emote] = true
+		}
+
+		self.mutex.Unlock()
+	}
+
+	return err
 }
 
 func (self *worker) countEmotes(msg *bot.TextMessage) {
@@ -205,6 +249,7 @@ func (self *worker) countEmotes(msg *bot.TextMessage) {
 
 		count, _ := self.stats[emote]
 		self.stats[emote] = count + len(occurences)
+		self.dirty[emote] = true
 	}
 
 	self.mutex.Unlock()
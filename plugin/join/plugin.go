@@ -45,13 +45,13 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 func (self *pluginStruct) handleJoin(msg *bot.TextMessage, sender bot.Sender) {
 	args := msg.Arguments()
 	sentOn := msg.Channel
-	user := msg.User.Name
+	user := msg.User.Login
 	toJoin := ""
 
 	if len(args) == 0 && sentOn == self.home {
 		// anyone#bot: !join
 		toJoin = user
-	} else if len(args) > 0 && msg.IsFromOperator() && isChannel(args[0]) {
+	} else if len(args) > 0 && msg.IsFromAdmin(bot.AdminJoin) && isChannel(args[0]) {
 		// op#anywhere: !join #channel
 		toJoin = args[0]
 	}
@@ -74,18 +74,18 @@ func (self *pluginStruct) handleJoin(msg *bot.TextMessage, sender bot.Sender) {
 func (self *pluginStruct) handlePart(msg *bot.TextMessage, sender bot.Sender) {
 	args := msg.Arguments()
 	sentOn := msg.Channel
-	user := msg.User.Name
+	user := msg.User.Login
 	toLeave := ""
 
 	if len(args) == 0 {
 		if sentOn == self.home {
 			// (anyone)#bot: !part
 			toLeave = user
-		} else if msg.IsFromOperator() || msg.IsFromBroadcaster() {
+		} else if msg.IsFromAdmin(bot.AdminJoin) || msg.IsFromBroadcaster() {
 			// [op|owner]#(anywhere): !part
 			toLeave = sentOn
 		}
-	} else if isChannel(args[0]) && msg.IsFromOperator() {
+	} else if isChannel(args[0]) && msg.IsFromAdmin(bot.AdminJoin) {
 		// op#(anywhere): !part #something
 		toLeave = args[0]
 	}
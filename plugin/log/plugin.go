@@ -4,14 +4,28 @@ package log
 // channels, this will slow down a bit, but thankfully only the goroutines for those
 // channels.
 
-import "github.com/sgt-kabukiman/kabukibot/bot"
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
 
 type logConfig struct {
 	Directory string
 }
 
+func init() {
+	bot.RegisterPluginConfig("log", func() bot.PluginConfig { return &logConfig{} })
+}
+
+func (self *logConfig) Validate() error {
+	return nil
+}
+
 type pluginStruct struct {
 	config logConfig
+	mutex  sync.RWMutex
 }
 
 func NewPlugin() *pluginStruct {
@@ -23,17 +37,39 @@ func (self *pluginStruct) Name() string {
 }
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
-	self.config = logConfig{}
+	_, err := self.Reconfigure(bot.Configuration())
+	if err != nil {
+		bot.Logger().Warning(err.Error())
+	}
+}
+
+// Reconfigure switches to the new log directory; workers reopen their files with
+// the next message they log.
+func (self *pluginStruct) Reconfigure(config *bot.Configuration) ([]string, error) {
+	loaded := logConfig{}
 
-	err := bot.Configuration().PluginConfig(self.Name(), &self.config)
+	err := config.PluginConfig("log", &loaded)
 	if err != nil {
-		bot.Logger().Warning("Could not load 'log' plugin configuration: %s", err)
+		return nil, fmt.Errorf("Could not load 'log' plugin configuration: %s", err)
 	}
+
+	self.mutex.Lock()
+	self.config = loaded
+	self.mutex.Unlock()
+
+	return nil, nil
+}
+
+func (self *pluginStruct) directory() string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return self.config.Directory
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &worker{
-		directory: self.config.Directory,
-		channel:   channel.Name(),
+		plugin:  self,
+		channel: channel.Name(),
 	}
 }
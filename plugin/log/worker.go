@@ -19,7 +19,8 @@ import (
 type worker struct {
 	plugin.NilWorker
 
-	directory string
+	plugin    *pluginStruct
+	directory string // the directory the file has been opened in
 	channel   string
 	file      *os.File
 }
@@ -27,6 +28,7 @@ type worker struct {
 func (self *worker) Enable() {
 	self.Disable() // cleanup
 
+	self.directory = self.plugin.directory()
 	filename := filepath.Join(self.directory, strings.TrimPrefix(self.channel, "#")+".log")
 
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
@@ -42,11 +44,20 @@ func (self *worker) Disable() {
 	}
 }
 
+// followDirectory reopens the log file when the configured directory has changed.
+func (self *worker) followDirectory() {
+	if self.file != nil && self.directory != self.plugin.directory() {
+		self.Enable()
+	}
+}
+
 func (self *worker) Permissions() []string {
 	return []string{}
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
+	self.followDirectory()
+
 	if self.file != nil {
 		now := time.Now().Format("2006-Jan-02 15:04:05")
 		line := fmt.Sprintf("%s%s: %s", self.userPrefix(msg), msg.User.Name, msg.Text)
@@ -58,6 +69,8 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 }
 
 func (self *worker) HandleClearChatMessage(msg *twitch.ClearChatMessage, sender bot.Sender) {
+	self.followDirectory()
+
 	if self.file != nil {
 		var line string
 
@@ -76,6 +89,8 @@ func (self *worker) HandleClearChatMessage(msg *twitch.ClearChatMessage, sender
 }
 
 func (self *worker) HandleSubscriberNotificationMessage(msg *twitch.SubscriberNotificationMessage, sender bot.Sender) {
+	self.followDirectory()
+
 	if self.file != nil {
 		now := time.Now().Format("2006-Jan-02 15:04:05")
 
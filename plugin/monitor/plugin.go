@@ -1,7 +1,10 @@
 package monitor
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
@@ -15,12 +18,35 @@ type monitorConfig struct {
 	Filename   string
 }
 
+func init() {
+	bot.RegisterPluginConfig("monitor", func() bot.PluginConfig { return &monitorConfig{} })
+}
+
+func (self *monitorConfig) Validate() error {
+	// without a channel, the monitor is disabled
+	if len(self.Channel) == 0 {
+		return nil
+	}
+
+	if !strings.HasPrefix(self.Channel, "#") {
+		return errors.New("The channel must start with a '#'.")
+	}
+
+	if len(self.Message) == 0 || len(self.ExpectedBy) == 0 || len(self.Filename) == 0 {
+		return errors.New("The message, the expected user and the status filename are required.")
+	}
+
+	return nil
+}
+
 type pluginStruct struct {
 	plugin.BasePlugin
 
 	config  monitorConfig
+	channel string // the monitored channel, which cannot change while running
 	startup time.Time
 	bot     *bot.Kabukibot
+	mutex   sync.RWMutex
 }
 
 func NewPlugin() *pluginStruct {
@@ -30,31 +56,71 @@ func NewPlugin() *pluginStruct {
 }
 
 func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
-	self.config = monitorConfig{}
 	self.bot = bot
 
-	err := bot.Configuration().PluginConfig("monitor", &self.config)
+	err := self.load(bot.Configuration())
+	if err != nil {
+		bot.Logger().Warning(err.Error())
+	}
+
+	self.channel = self.settings().Channel
+}
+
+// Reconfigure applies the new message, user and file right away; the monitored
+// channel cannot be changed without a restart.
+func (self *pluginStruct) Reconfigure(config *bot.Configuration) ([]string, error) {
+	err := self.load(config)
 	if err != nil {
-		bot.Logger().Warning("Could not load 'monitor' plugin configuration: %s", err)
+		return nil, err
 	}
 
-	self.config.ExpectedBy = strings.ToLower(self.config.ExpectedBy)
+	if self.settings().Channel != self.channel {
+		return []string{"plugins.monitor.channel"}, nil
+	}
+
+	return nil, nil
+}
+
+func (self *pluginStruct) load(config *bot.Configuration) error {
+	loaded := monitorConfig{}
+
+	err := config.PluginConfig("monitor", &loaded)
+	if err != nil {
+		return fmt.Errorf("Could not load 'monitor' plugin configuration: %s", err)
+	}
+
+	loaded.ExpectedBy = strings.ToLower(loaded.ExpectedBy)
+
+	self.mutex.Lock()
+	self.config = loaded
+	self.mutex.Unlock()
+
+	return nil
+}
+
+func (self *pluginStruct) settings() monitorConfig {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return self.config
 }
 
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
-	if channel.Name() == self.config.Channel {
-		return &worker{
-			bot:         self.bot,
-			log:         self.bot.Logger(),
-			startup:     self.startup,
-			config:      self.config,
-			channel:     channel.Name(),
-			sender:      channel.Sender(),
-			playing:     make(chan struct{}),
-			stopPlaying: make(chan struct{}),
-			dumping:     make(chan struct{}),
-			stopDumping: make(chan struct{}),
+	if channel.Name() == self.channel {
+		w := &worker{
+			bot:     self.bot,
+			log:     self.bot.PluginLogger("monitor"),
+			startup: self.startup,
+			plugin:  self,
+			channel: channel.Name(),
+			ctx:     channel.Context(),
+			sender:  channel.Sender(),
 		}
+
+		w.pinger = bot.NewBackgroundTask(time.Minute, w.ping, nil)
+		w.dumper = bot.NewBackgroundTask(time.Minute, w.dump, nil)
+
+		return w
 	} else {
 		return &plugin.NilWorker{}
 	}
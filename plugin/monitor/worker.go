@@ -1,10 +1,10 @@
 package monitor
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"runtime"
-	"strings"
 	"time"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
@@ -14,34 +14,30 @@ import (
 type worker struct {
 	plugin.NilWorker
 
-	log         bot.Logger
-	bot         *bot.Kabukibot
-	startup     time.Time
-	config      monitorConfig
-	channel     string
-	sender      bot.Sender
-	sentPing    time.Time
-	pending     bool
-	delay       time.Duration
-	playing     chan struct{}
-	stopPlaying chan struct{}
-	dumping     chan struct{}
-	stopDumping chan struct{}
-	sent        uint64
-	received    uint64
+	log      bot.Logger
+	bot      *bot.Kabukibot
+	startup  time.Time
+	plugin   *pluginStruct
+	channel  string
+	ctx      context.Context
+	sender   bot.Sender
+	sentPing time.Time
+	pending  bool
+	delay    time.Duration
+	pinger   *bot.BackgroundTask
+	dumper   *bot.BackgroundTask
+	sent     uint64
+	received uint64
 }
 
 func (self *worker) Enable() {
-	go self.pingpong()
-	go self.dumper()
+	self.pinger.Start(self.ctx)
+	self.dumper.Start(self.ctx)
 }
 
 func (self *worker) Disable() {
-	close(self.stopPlaying)
-	<-self.playing
-
-	close(self.stopDumping)
-	<-self.dumping
+	self.pinger.Stop()
+	self.dumper.Stop()
 }
 
 type monitorStatus struct {
@@ -61,73 +57,52 @@ type monitorStatus struct {
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
-	if self.pending && strings.ToLower(msg.User.Name) == self.config.ExpectedBy {
+	if self.pending && msg.User.Login == self.plugin.settings().ExpectedBy {
 		self.pending = false
 		self.delay = time.Since(self.sentPing)
 	}
 }
 
-func (self *worker) pingpong() {
-	defer close(self.playing)
-
-	for {
-		select {
-		case <-time.After(time.Minute):
-			// send ping
-			sent := self.sender.SendText(self.config.Message)
-			self.pending = true
+func (self *worker) ping() {
+	sent := self.sender.SendText(self.plugin.settings().Message)
+	self.pending = true
 
-			// wait for the ping to be sent
-			<-sent
-			self.sentPing = time.Now()
-
-		case <-self.stopPlaying:
-			return
-		}
-	}
+	// wait for the ping to be sent
+	<-sent
+	self.sentPing = time.Now()
 }
 
-func (self *worker) dumper() {
-	defer close(self.dumping)
-
-	for {
-		select {
-		case <-time.After(time.Minute):
-			memStats := runtime.MemStats{}
-			runtime.ReadMemStats(&memStats)
-
-			s := self.bot.MessagesSent()
-			r := self.bot.MessagesReceived()
-
-			status := monitorStatus{}
-			status.Uptime = time.Since(self.startup).String()
-			status.Channels = len(self.bot.Channels())
-			status.Memory.Residential = memStats.Sys
-			status.Memory.HeapTotal = memStats.HeapSys
-			status.Memory.HeapUsed = memStats.HeapInuse
-			status.Messages.Received = r - self.received
-			status.Messages.Sent = s - self.sent
-			status.Queue = self.bot.QueueLen()
-			status.Heartbeat = int(self.delay.Nanoseconds() / int64(time.Millisecond))
-
-			file, err := os.OpenFile(self.config.Filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-			if err != nil {
-				self.log.Error("Could not open monitor status file: %s", err.Error())
-			} else {
-				encoder := json.NewEncoder(file)
-
-				if err := encoder.Encode(&status); err != nil {
-					self.log.Error("Could not dump monitor status: %s", err.Error())
-				}
-
-				file.Close()
-			}
-
-			self.sent = s
-			self.received = r
-
-		case <-self.stopDumping:
-			return
+func (self *worker) dump() {
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+
+	s := self.bot.MessagesSent()
+	r := self.bot.MessagesReceived()
+
+	status := monitorStatus{}
+	status.Uptime = time.Since(self.startup).String()
+	status.Channels = len(self.bot.Channels())
+	status.Memory.Residential = memStats.Sys
+	status.Memory.HeapTotal = memStats.HeapSys
+	status.Memory.HeapUsed = memStats.HeapInuse
+	status.Messages.Received = r - self.received
+	status.Messages.Sent = s - self.sent
+	status.Queue = self.bot.QueueLen()
+	status.Heartbeat = int(self.delay.Nanoseconds() / int64(time.Millisecond))
+
+	file, err := os.OpenFile(self.plugin.settings().Filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		self.log.Error("Could not open monitor status file: %s", err.Error())
+	} else {
+		encoder := json.NewEncoder(file)
+
+		if err := encoder.Encode(&status); err != nil {
+			self.log.Error("Could not dump monitor status: %s", err.Error())
 		}
+
+		file.Close()
 	}
+
+	self.sent = s
+	self.received = r
 }
@@ -72,13 +72,21 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 
 	// enable a plugin
 	if msg.IsGlobalCommand("enable") {
-		if self.channel.EnablePlugin(pluginKey) {
+		enabled, err := self.channel.EnablePlugin(pluginKey)
+
+		if err != nil {
+			message = plugin.StorageFailure
+		} else if enabled {
 			message = "the plugin " + pluginKey + " has been enabled."
 		} else {
 			message = "the plugin " + pluginKey + " is already enabled in this channel."
 		}
 	} else { // disable a plugin
-		if self.channel.DisablePlugin(pluginKey) {
+		disabled, err := self.channel.DisablePlugin(pluginKey)
+
+		if err != nil {
+			message = plugin.StorageFailure
+		} else if disabled {
 			message = "the plugin " + pluginKey + " has been disabled."
 		} else {
 			message = "the plugin " + pluginKey + " is not enabled in this channel."
@@ -0,0 +1,53 @@
+package reload
+
+import (
+	"fmt"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+)
+
+type pluginStruct struct {
+	plugin.BasePlugin
+	plugin.NilWorker
+
+	bot *bot.Kabukibot
+}
+
+func NewPlugin() *pluginStruct {
+	return &pluginStruct{}
+}
+
+func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
+	self.bot = bot
+}
+
+func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
+	return self
+}
+
+func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
+	if msg.IsProcessed() || !msg.IsFromOperator() || !msg.IsGlobalCommand("reload") {
+		return
+	}
+
+	msg.SetProcessed()
+
+	report, err := self.bot.ReloadConfiguration()
+	if err != nil {
+		sender.Respond("the configuration could not be reloaded and has not been changed: " + err.Error())
+		return
+	}
+
+	response := "the configuration has been reloaded."
+
+	if len(report.Errors) > 0 {
+		response += fmt.Sprintf(" %d plugin(s) kept their previous settings because of errors, see the log.", len(report.Errors))
+	}
+
+	if len(report.RestartRequired) > 0 {
+		response += " These changes need a restart: " + bot.HumanJoin(report.RestartRequired, ", ") + "."
+	}
+
+	sender.Respond(response)
+}
@@ -0,0 +1,168 @@
+package settings
+
+import (
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+)
+
+type pluginStruct struct {
+	plugin.BasePlugin
+
+	prefix string
+}
+
+func NewPlugin() *pluginStruct {
+	return &pluginStruct{}
+}
+
+func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
+	self.prefix = bot.Configuration().CommandPrefix
+}
+
+func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
+	return &worker{
+		prefix:   self.prefix,
+		settings: channel.Settings(),
+	}
+}
+
+type worker struct {
+	plugin.NilWorker
+
+	prefix   string
+	settings *bot.ChannelSettings
+}
+
+func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
+	if msg.IsProcessed() {
+		return
+	}
+
+	if !msg.IsGlobalCommand("set") && !msg.IsGlobalCommand("settings") {
+		return
+	}
+
+	if !msg.IsFromBroadcaster() && !msg.IsFromOperator() {
+		return
+	}
+
+	msg.SetProcessed()
+
+	args := msg.Arguments()
+
+	if msg.IsGlobalCommand("settings") {
+		self.respondToListCommand(msg, args, sender)
+	} else {
+		self.respondToSetCommand(msg, args, sender)
+	}
+}
+
+func (self *worker) respondToListCommand(msg *bot.TextMessage, args []string, sender bot.Sender) {
+	if len(args) == 0 {
+		names := self.visiblePlugins(msg)
+
+		if len(names) == 0 {
+			sender.Respond("there are no plugins with settings.")
+		} else {
+			sender.Respond("plugins with settings are: " + strings.Join(names, ", ") + ". Use !" + self.prefix + "settings <plugin> to see them.")
+		}
+
+		return
+	}
+
+	name, found := self.findPlugin(msg, args[0])
+	if !found {
+		sender.Respond("the plugin \"" + args[0] + "\" has no settings.")
+		return
+	}
+
+	values := make([]string, 0)
+
+	for _, setting := range self.settings.Declared(name) {
+		value := self.settings.Get(name, setting.Key)
+		display := value
+
+		if len(display) == 0 {
+			display = "(empty)"
+		}
+
+		if value == setting.Default {
+			display += " (default)"
+		}
+
+		values = append(values, name+"."+setting.Key+" = "+display)
+	}
+
+	sender.Respond(strings.Join(values, ", "))
+}
+
+func (self *worker) respondToSetCommand(msg *bot.TextMessage, args []string, sender bot.Sender) {
+	if len(args) == 0 {
+		sender.Respond("no setting given: !" + self.prefix + "set <plugin>.<key> <value>")
+		return
+	}
+
+	parts := strings.SplitN(args[0], ".", 2)
+	if len(parts) != 2 {
+		sender.Respond("settings are given as <plugin>.<key>, like !" + self.prefix + "set " + args[0] + ".<key> <value>")
+		return
+	}
+
+	key := strings.ToLower(parts[1])
+
+	name, found := self.findPlugin(msg, parts[0])
+	if !found {
+		sender.Respond("the plugin \"" + parts[0] + "\" has no settings.")
+		return
+	}
+
+	value, err := self.settings.Check(name, key, strings.Join(args[1:], " "))
+	if err == bot.ErrUnknownSetting {
+		sender.Respond("the plugin " + name + " has no setting \"" + key + "\".")
+		return
+	}
+
+	if err != nil {
+		sender.Respond(err.Error())
+		return
+	}
+
+	if self.settings.Set(name, key, value) != nil {
+		sender.Respond(plugin.StorageFailure)
+		return
+	}
+
+	if len(value) == 0 {
+		value = "(empty)"
+	}
+
+	sender.Respond(name + "." + key + " is now " + value + ".")
+}
+
+// findPlugin returns the plugin's name, ignoring the case, if the user may see its
+// settings.
+func (self *worker) findPlugin(msg *bot.TextMessage, name string) (string, bool) {
+	for _, visible := range self.visiblePlugins(msg) {
+		if strings.ToLower(visible) == strings.ToLower(name) {
+			return visible, true
+		}
+	}
+
+	return "", false
+}
+
+// visiblePlugins hides op-only plugins (those with an UPPERCASE name) from everyone
+// but the operator.
+func (self *worker) visiblePlugins(msg *bot.TextMessage) []string {
+	names := make([]string, 0)
+
+	for _, name := range self.settings.Plugins() {
+		if msg.IsFromOperator() || strings.ToLower(name) == name {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
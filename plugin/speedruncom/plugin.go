@@ -1,7 +1,11 @@
 package speedruncom
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sgt-kabukiman/kabukibot/bot"
@@ -16,17 +20,56 @@ type categoryConfig struct {
 type gameConfig map[string]categoryConfig
 
 type speedruncomConfig struct {
-	Interval int
+	Interval int // in minutes
 	Mapping  map[string]gameConfig
 }
 
+func init() {
+	bot.RegisterPluginConfig("speedruncom", func() bot.PluginConfig { return newConfig() })
+}
+
+func newConfig() *speedruncomConfig {
+	return &speedruncomConfig{
+		Interval: 15,
+	}
+}
+
+func (self *speedruncomConfig) Validate() error {
+	if self.Interval < 1 {
+		return errors.New("The interval must be at least one minute.")
+	}
+
+	for gameID, categories := range self.Mapping {
+		for categoryID, category := range categories {
+			if len(category.DictKey) == 0 {
+				return fmt.Errorf("No dictionary key configured for %s/%s.", gameID, categoryID)
+			}
+		}
+	}
+
+	return nil
+}
+
 type Plugin struct {
-	config speedruncomConfig
-	dict   *bot.Dictionary
+	config  speedruncomConfig
+	dict    *bot.Dictionary
+	events  *bot.EventBus
+	updates *bot.BackgroundTask
+	updated time.Time // when the records have been fetched the last time
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mutex   sync.RWMutex
 }
 
+// the interval is given in minutes and can be reloaded, so the updater checks every
+// minute whether it is time to fetch the records again
+const updateCheckInterval = time.Minute
+
 func NewPlugin() *Plugin {
-	return &Plugin{}
+	plugin := &Plugin{config: *newConfig()}
+	plugin.updates = bot.NewBackgroundTask(updateCheckInterval, plugin.update, nil)
+
+	return plugin
 }
 
 func (self *Plugin) Name() string {
@@ -34,56 +77,165 @@ func (self *Plugin) Name() string {
 }
 
 func (self *Plugin) Setup(bot *bot.Kabukibot) {
-	self.config = speedruncomConfig{}
 	self.dict = bot.Dictionary()
+	self.events = bot.Events()
 
-	err := bot.Configuration().PluginConfig(self.Name(), &self.config)
+	err := self.load(bot.Configuration())
 	if err != nil {
-		bot.Logger().Warning("Could not load 'speedruncom' plugin configuration: %s", err)
+		bot.Logger().Warning(err.Error())
 	}
 
-	go self.updater()
+	self.subscribe()
+
+	self.ctx, self.cancel = context.WithCancel(bot.Context())
+	self.updates.Start(self.ctx)
 }
 
-func (self *Plugin) updater() {
-	interval := time.Duration(self.config.Interval * int(time.Minute))
+// Stop cancels a running update and stops updating the records.
+func (self *Plugin) Stop() {
+	if self.cancel != nil {
+		self.cancel()
+	}
 
-	for {
-		for gameID, catList := range self.config.Mapping {
-			game, err := srapi.GameByID(gameID, srapi.NoEmbeds)
-			if err != nil {
-				continue
+	self.updates.Stop()
+}
+
+// Reconfigure applies the new mapping with the next update. New and changed WR
+// commands are announced right away, but removed ones stay until a restart.
+func (self *Plugin) Reconfigure(config *bot.Configuration) ([]string, error) {
+	before := self.CollectCommands("")
+
+	err := self.load(config)
+	if err != nil {
+		return nil, err
+	}
+
+	after := self.CollectCommands("")
+	restart := make([]string, 0)
+
+	for cmd, dictKey := range after {
+		if before[cmd] != dictKey {
+			self.events.Publish(bot.WorldRecordCommandEvent{
+				Command: cmd,
+				DictKey: dictKey,
+			})
+		}
+	}
+
+	for cmd := range before {
+		if _, exists := after[cmd]; !exists {
+			restart = append(restart, "plugins.speedruncom.mapping (removed command !"+cmd+")")
+		}
+	}
+
+	return restart, nil
+}
+
+func (self *Plugin) load(config *bot.Configuration) error {
+	loaded := newConfig()
+
+	err := config.PluginConfig(self.Name(), loaded)
+	if err != nil {
+		return fmt.Errorf("Could not load 'speedruncom' plugin configuration: %s", err)
+	}
+
+	self.mutex.Lock()
+	self.config = *loaded
+	self.mutex.Unlock()
+
+	return nil
+}
+
+func (self *Plugin) settings() speedruncomConfig {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	return self.config
+}
+
+func (self *Plugin) subscribe() {
+	// tell other plugins about the configured WR commands once everyone is listening
+	self.events.Subscribe(bot.EventSetupComplete, self.announceCommands)
+}
+
+func (self *Plugin) announceCommands(e bot.Event) {
+	for cmd, dictKey := range self.CollectCommands("") {
+		self.events.Publish(bot.WorldRecordCommandEvent{
+			Command: cmd,
+			DictKey: dictKey,
+		})
+	}
+}
+
+func (self *Plugin) update() {
+	config := self.settings()
+
+	if time.Since(self.updated) < time.Duration(config.Interval)*time.Minute {
+		return
+	}
+
+	self.updated = time.Now()
+
+	for gameID, catList := range config.Mapping {
+		var (
+			game         *srapi.Game
+			leaderboards *srapi.LeaderboardCollection
+			err          *srapi.Error
+		)
+
+		if request(self.ctx, func() { game, err = srapi.GameByID(gameID, srapi.NoEmbeds) }) != nil {
+			return
+		}
+
+		if err != nil {
+			continue
+		}
+
+		filter := srapi.LeaderboardFilter{Top: 1}
+
+		if request(self.ctx, func() { leaderboards, err = game.Records(&filter, leaderboardEmbeds) }) != nil {
+			return
+		}
+
+		if err != nil {
+			continue
+		}
+
+		leaderboards.Walk(func(lb *srapi.Leaderboard) bool {
+			if len(lb.Runs) == 0 {
+				return true
 			}
 
-			filter := srapi.LeaderboardFilter{Top: 1}
-			leaderboards, err := game.Records(&filter, "players,regions,platforms,category,game")
+			cat, err := lb.Category(srapi.NoEmbeds)
 			if err != nil {
-				continue
+				return true
 			}
 
-			leaderboards.Walk(func(lb *srapi.Leaderboard) bool {
-				if len(lb.Runs) == 0 {
-					return true
-				}
+			catConfig, okay := catList[cat.ID]
+			if !okay {
+				return true
+			}
 
-				cat, err := lb.Category(srapi.NoEmbeds)
-				if err != nil {
-					return true
-				}
+			var formatted string
 
-				catConfig, okay := catList[cat.ID]
-				if !okay {
+			if request(self.ctx, func() { formatted = formatWorldRecord(lb, 0) }) != nil {
+				return false
+			}
+
+			if self.dict.Get(catConfig.DictKey) != formatted {
+				// try again with the next update
+				if self.dict.SetBy(catConfig.DictKey, formatted, bot.DictionaryAuthor{Source: "speedruncom"}) != nil {
 					return true
 				}
 
-				formatted := formatWorldRecord(lb, 0)
-				self.dict.Set(catConfig.DictKey, formatted)
-
-				return true
-			})
-		}
+				self.events.Publish(bot.WorldRecordChangedEvent{
+					DictKey: catConfig.DictKey,
+					Record:  formatted,
+				})
+			}
 
-		time.Sleep(interval)
+			return true
+		})
 	}
 }
 
@@ -91,13 +243,14 @@ func (self *Plugin) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &worker{
 		channel: channel.Name(),
 		acl:     channel.ACL(),
+		tasks:   channel.Tasks(),
 	}
 }
 
 func (self *Plugin) CollectCommands(dictKeyPrefix string) map[string]string {
 	result := make(map[string]string)
 
-	for _, catList := range self.config.Mapping {
+	for _, catList := range self.settings().Mapping {
 		for _, catConfig := range catList {
 			if len(dictKeyPrefix) == 0 || strings.HasPrefix(catConfig.DictKey, dictKeyPrefix) {
 				for _, cmd := range catConfig.Commands {
@@ -1,6 +1,7 @@
 package speedruncom
 
 import (
+	"context"
 	"regexp"
 	"strings"
 
@@ -14,10 +15,11 @@ type worker struct {
 
 	channel string
 	acl     *bot.ACL
+	tasks   *bot.TaskPool
 }
 
 func (self *worker) Permissions() []string {
-	return []string{"use_speedruncom_commands"}
+	return []string{"speedruncom.use"}
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -26,20 +28,50 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 	}
 
 	if msg.IsCommand("wr") {
-		self.handleWorldRecordCommand(msg, sender)
 		msg.SetProcessed()
+
+		if !self.acl.IsAllowed(msg.User, "speedruncom.use") {
+			return
+		}
+
+		args := msg.Arguments()
+
+		// talking to speedrun.com can take a while, so do not block the channel
+		queued := self.tasks.GoOrdered(func(ctx context.Context) {
+			self.handleWorldRecordCommand(ctx, args, bot.ContextSender(ctx, sender))
+		})
+
+		if !queued {
+			sender.Respond("I am too busy right now, please try again in a moment.")
+		}
 	}
 }
 
 var cleanerRegexp = regexp.MustCompile(`[^a-zA-Z0-9]`)
 
-func (self *worker) handleWorldRecordCommand(msg *bot.TextMessage, sender bot.Sender) {
-	if !self.acl.IsAllowed(msg.User, "use_speedrun_commands") {
-		return
-	}
+const leaderboardEmbeds = "players,regions,platforms,category,game"
 
-	args := msg.Arguments()
+// request runs a call to speedrun.com until it is done or the task is cancelled or
+// times out. srapi does not take a context, so a cancelled request is not aborted,
+// but the task stops waiting for it and frees its slot in the pool.
+func request(ctx context.Context, call func()) error {
+	done := make(chan struct{})
 
+	go func() {
+		defer close(done)
+		call()
+	}()
+
+	select {
+	case <-done:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (self *worker) handleWorldRecordCommand(ctx context.Context, args []string, sender bot.Sender) {
 	if len(args) == 0 {
 		sender.Respond("you have to give me a game abbreviation.")
 		return
@@ -47,10 +79,17 @@ func (self *worker) handleWorldRecordCommand(msg *bot.TextMessage, sender bot.Se
 
 	gameIdentifier := args[0]
 
-	var category *srapi.Category
+	var (
+		category *srapi.Category
+		game     *srapi.Game
+		err      *srapi.Error
+	)
 
 	// try to find the game
-	game, err := srapi.GameByAbbreviation(gameIdentifier, "categories")
+	if request(ctx, func() { game, err = srapi.GameByAbbreviation(gameIdentifier, "categories") }) != nil {
+		return
+	}
+
 	if err != nil {
 		sender.Respond("I could not find a game with the abbreviation \"" + gameIdentifier + "\".")
 		return
@@ -62,7 +101,12 @@ func (self *worker) handleWorldRecordCommand(msg *bot.TextMessage, sender bot.Se
 	if len(args) > 1 {
 		catIdentifier := cleanerRegexp.ReplaceAllString(strings.ToLower(strings.Join(args[1:], "")), "")
 
-		categories, err := game.Categories(nil, nil, srapi.NoEmbeds)
+		var categories *srapi.CategoryCollection
+
+		if request(ctx, func() { categories, err = game.Categories(nil, nil, srapi.NoEmbeds) }) != nil {
+			return
+		}
+
 		catNames := []string{}
 
 		if err == nil {
@@ -92,21 +136,32 @@ func (self *worker) handleWorldRecordCommand(msg *bot.TextMessage, sender bot.Se
 
 	var lb *srapi.Leaderboard
 
+	top := &srapi.LeaderboardOptions{Top: 1}
+
 	// fetch the leaderboard, if possible (only available for games with full-game categories by default)
 	if category == nil {
-		lb, err = game.PrimaryLeaderboard(&srapi.LeaderboardOptions{Top: 1}, "players,regions,platforms,category,game")
+		if request(ctx, func() { lb, err = game.PrimaryLeaderboard(top, leaderboardEmbeds) }) != nil {
+			return
+		}
+
 		if err != nil || lb == nil {
 			sender.Respond(game.Names.International + " does not use full-game categories by default, so I don't know what category or level you are referring to.")
 			return
 		}
 
-		category, err = lb.Category(srapi.NoEmbeds)
+		if request(ctx, func() { category, err = lb.Category(srapi.NoEmbeds) }) != nil {
+			return
+		}
+
 		if err != nil {
 			sender.Respond("the data from speedrun.com is invalid, cannot procede. Sorry. Try again later or a with different game.")
 			return
 		}
 	} else {
-		lb, err = category.PrimaryLeaderboard(&srapi.LeaderboardOptions{Top: 1}, "players,regions,platforms,category,game")
+		if request(ctx, func() { lb, err = category.PrimaryLeaderboard(top, leaderboardEmbeds) }) != nil {
+			return
+		}
+
 		if err != nil || lb == nil {
 			sender.Respond(game.Names.International + " does not have runs for its \"" + category.Name + "\" category.")
 			return
@@ -119,8 +174,12 @@ func (self *worker) handleWorldRecordCommand(msg *bot.TextMessage, sender bot.Se
 		return
 	}
 
-	// show only the first WR
-	formatted := formatWorldRecord(lb, 0)
+	// show only the first WR; formatting can need further requests for the players
+	var formatted string
+
+	if request(ctx, func() { formatted = formatWorldRecord(lb, 0) }) != nil {
+		return
+	}
 
 	sender.SendText(formatted)
 }
@@ -0,0 +1,5 @@
+package plugin
+
+// StorageFailure is the response for when a change could not be written to the
+// database. The bot has already logged the actual error by then.
+const StorageFailure = "sorry, the change could not be saved. Please try again later."
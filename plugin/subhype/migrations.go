@@ -0,0 +1,88 @@
+package subhype
+
+import (
+	"strings"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+// the channel dictionary entry the message was stored in before there were settings
+const legacyKey = "subhype_message"
+
+func (self *pluginStruct) Migrations() (string, []bot.Migration) {
+	return "subhype", []bot.Migration{
+		{
+			Version:     1,
+			Description: "move the messages from the dictionary into the channel settings",
+			Up:          migrateMessages,
+			Down:        bot.SQL(),
+		},
+	}
+}
+
+type legacyMessage struct {
+	Channel string
+	Keyname string
+	Value   string
+}
+
+// migrateMessages moves messages from the times when they were stored in the global
+// and later in the channel's dictionary into the channel's settings. Messages that
+// have already been set in the settings are kept.
+func migrateMessages(db bot.Database) error {
+	rows := make([]legacyMessage, 0)
+
+	err := db.Select(&rows, "SELECT channel, keyname, value FROM dictionary WHERE (channel = '' AND keyname LIKE 'subhype%') OR (channel <> '' AND keyname = ?)", legacyKey)
+	if err != nil {
+		return err
+	}
+
+	messages := make(map[string]string)
+
+	// the global entries are the older ones, the channel's dictionary takes precedence
+	for _, row := range rows {
+		if row.Channel == "" && strings.HasPrefix(row.Keyname, "subhype_") && strings.HasSuffix(row.Keyname, "_message") {
+			name := strings.TrimSuffix(strings.TrimPrefix(row.Keyname, "subhype_"), "_message")
+
+			if _, exists := messages["#"+name]; len(name) > 0 && !exists {
+				messages["#"+name] = row.Value
+			}
+		}
+	}
+
+	for _, row := range rows {
+		if row.Channel != "" {
+			messages[row.Channel] = row.Value
+		}
+	}
+
+	upsert := db.Dialect().Upsert("channel_setting", []string{"channel", "plugin", "keyname"}, []string{"value"})
+
+	for channel, message := range messages {
+		existing := make([]string, 0)
+
+		err := db.Select(&existing, "SELECT value FROM channel_setting WHERE channel = ? AND plugin = ? AND keyname = ?", channel, "subhype", "message")
+		if err != nil {
+			return err
+		}
+
+		if len(existing) == 0 || len(existing[0]) == 0 {
+			_, err = db.Exec(upsert, channel, "subhype", "message", message)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = db.Exec("DELETE FROM dictionary WHERE channel = ? AND keyname = ?", channel, legacyKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("DELETE FROM dictionary WHERE channel = '' AND keyname = ?", "subhype_"+strings.TrimPrefix(channel, "#")+"_message")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
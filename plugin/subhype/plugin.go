@@ -18,9 +18,14 @@ func (self *pluginStruct) Setup(bot *bot.Kabukibot) {
 	self.dict = bot.Dictionary()
 }
 
+func (self *pluginStruct) Settings() []bot.Setting {
+	return []bot.Setting{
+		{Key: "message", Type: bot.StringSetting, Default: ""},
+	}
+}
+
 func (self *pluginStruct) CreateWorker(channel bot.Channel) bot.PluginWorker {
 	return &worker{
-		dict:    self.dict,
-		message: self.dict.Get(subhypeKey(channel.Name())),
+		settings: channel.Settings(),
 	}
 }
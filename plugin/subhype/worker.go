@@ -11,8 +11,7 @@ import (
 type worker struct {
 	plugin.NilWorker
 
-	dict    *bot.Dictionary
-	message string
+	settings *bot.ChannelSettings
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -36,17 +35,19 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 	}
 
 	text := strings.Join(args, " ")
-	key := "subhype_" + strings.TrimPrefix(msg.ChannelName(), "#") + "_message"
 
-	self.message = text
-	self.dict.Set(key, text)
+	err := self.settings.Set("subhype", "message", text)
+	if err != nil {
+		sender.Respond(plugin.StorageFailure)
+		return
+	}
 
 	sender.Respond("the subscriber notification has been updated.")
 }
 
 func (self *worker) HandleSubscriberNotificationMessage(msg *twitch.SubscriberNotificationMessage, sender bot.Sender) {
 	uname := msg.User
-	message := self.message
+	message := self.settings.Get("subhype", "message")
 
 	if len(message) == 0 {
 		return
@@ -58,7 +59,3 @@ func (self *worker) HandleSubscriberNotificationMessage(msg *twitch.SubscriberNo
 
 	sender.SendText(message)
 }
-
-func subhypeKey(channel string) string {
-	return "subhype_" + strings.TrimPrefix(channel, "#") + "_message"
-}
@@ -3,6 +3,7 @@ package sysinfo
 import (
 	"fmt"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -23,16 +24,44 @@ func (self *pluginStruct) HandleTextMessage(msg *bot.TextMessage, sender bot.Sen
 			var mem runtime.MemStats
 			runtime.ReadMemStats(&mem)
 
+			db := self.bot.Database().Stats()
+
 			infoString := fmt.Sprintf(
-				"System Info: %s uptime, %d channels, %s messages processed, %s res. size",
-				self.uptime(), len(self.bot.Channels()), humanize.FormatInteger("#,###.", self.messages), humanize.IBytes(mem.Sys),
+				"System Info: %s uptime, %d channels, %s messages processed, %s res. size, %d database errors (%d retries)",
+				self.uptime(), len(self.bot.Channels()), humanize.FormatInteger("#,###.", self.messages), humanize.IBytes(mem.Sys), db.Errors, db.Retries,
 			)
 
 			sender.Respond(infoString)
+			return
+		}
+
+		if msg.IsGlobalCommand("channelinfo") {
+			self.respondChannelInfo(msg, sender)
 		}
 	}
 }
 
+func (self *pluginStruct) respondChannelInfo(msg *bot.TextMessage, sender bot.Sender) {
+	channel := msg.Channel
+
+	args := msg.Arguments()
+	if len(args) > 0 {
+		channel = "#" + strings.TrimPrefix(strings.ToLower(args[0]), "#")
+	}
+
+	stats, okay := self.bot.ChannelStats()[channel]
+	if !okay {
+		sender.Respond("I am not in " + channel + ".")
+		return
+	}
+
+	sender.Respond(fmt.Sprintf(
+		"%s: %d queued, %s received, %s dropped, %s coalesced, %s lag (max %s)",
+		channel, stats.Queued, humanize.FormatInteger("#,###.", int(stats.Received)), humanize.FormatInteger("#,###.", int(stats.Dropped)),
+		humanize.FormatInteger("#,###.", int(stats.Coalesced)), stats.Lag.String(), stats.MaxLag.String(),
+	))
+}
+
 func (self *pluginStruct) HandleClearChatMessage(msg *twitch.ClearChatMessage, sender bot.Sender) {
 	self.countMessage()
 }
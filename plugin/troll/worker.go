@@ -14,7 +14,7 @@ type worker struct {
 }
 
 func (self *worker) Permissions() []string {
-	return []string{"trolling"}
+	return []string{"troll.use"}
 }
 
 func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
@@ -32,7 +32,7 @@ func (self *worker) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
 		return
 	}
 
-	if self.acl.IsAllowed(msg.User, "trolling") {
+	if self.acl.IsAllowed(msg.User, "troll.use") {
 		pos := rand.Intn(len(responses))
 		sender.Respond(responses[pos])
 	}
@@ -8,13 +8,12 @@ import (
 	"os"
 	"testing"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/test"
 )
 
 var config *bot.Configuration
-var db *sqlx.DB
+var db bot.Database
 
 func init() {
 	var err error
@@ -26,7 +25,7 @@ func init() {
 	}
 
 	// connect to database
-	db, err = sqlx.Connect("mysql", config.Database.DSN)
+	db, err = bot.OpenDatabase(config.Database.Driver, config.Database.DSN)
 	if err != nil {
 		panic(err)
 	}
@@ -41,6 +40,7 @@ func runScript(t *testing.T, filename string) {
 
 	tester := test.NewTester(file, config, db)
 	initTester(tester)
+	tester.Migrate()
 	tester.WipeDatabase()
 	tester.Run(t)
 }
@@ -57,10 +57,30 @@ func TestAclDeny(t *testing.T) {
 	runScript(t, "plugin/acl/deny.test")
 }
 
+func TestAclExpiry(t *testing.T) {
+	runScript(t, "plugin/acl/expiry.test")
+}
+
+func TestAclGroups(t *testing.T) {
+	runScript(t, "plugin/acl/groups.test")
+}
+
 func TestAclPermissions(t *testing.T) {
 	runScript(t, "plugin/acl/permissions.test")
 }
 
+func TestAclRevoke(t *testing.T) {
+	runScript(t, "plugin/acl/revoke.test")
+}
+
+func TestAclUsers(t *testing.T) {
+	runScript(t, "plugin/acl/users.test")
+}
+
+func TestAdminAdmins(t *testing.T) {
+	runScript(t, "plugin/admin/admins.test")
+}
+
 func TestBlacklistBasicCommands(t *testing.T) {
 	runScript(t, "plugin/blacklist/basic-commands.test")
 }
@@ -69,6 +89,10 @@ func TestBlacklistBasicFunctionality(t *testing.T) {
 	runScript(t, "plugin/blacklist/basic-functionality.test")
 }
 
+func TestBlacklistRenames(t *testing.T) {
+	runScript(t, "plugin/blacklist/renames.test")
+}
+
 func TestContentDefine(t *testing.T) {
 	runScript(t, "plugin/content/define.test")
 }
@@ -101,14 +125,30 @@ func TestCustomCommandsUpdate(t *testing.T) {
 	runScript(t, "plugin/custom_commands/update.test")
 }
 
+func TestDictionaryChannel(t *testing.T) {
+	runScript(t, "plugin/dictionary/channel.test")
+}
+
+func TestDictionaryDelete(t *testing.T) {
+	runScript(t, "plugin/dictionary/delete.test")
+}
+
 func TestDictionaryGet(t *testing.T) {
 	runScript(t, "plugin/dictionary/get.test")
 }
 
+func TestDictionaryHistory(t *testing.T) {
+	runScript(t, "plugin/dictionary/history.test")
+}
+
 func TestDictionaryKeys(t *testing.T) {
 	runScript(t, "plugin/dictionary/keys.test")
 }
 
+func TestDictionarySearch(t *testing.T) {
+	runScript(t, "plugin/dictionary/search.test")
+}
+
 func TestDictionarySet(t *testing.T) {
 	runScript(t, "plugin/dictionary/set.test")
 }
@@ -125,6 +165,10 @@ func TestDomainBanKickAss(t *testing.T) {
 	runScript(t, "plugin/domain_ban/kick-ass.test")
 }
 
+func TestDomainBanRestart(t *testing.T) {
+	runScript(t, "plugin/domain_ban/restart.test")
+}
+
 func TestDomainBanUnban(t *testing.T) {
 	runScript(t, "plugin/domain_ban/unban.test")
 }
@@ -133,6 +177,10 @@ func TestEchoEcho(t *testing.T) {
 	runScript(t, "plugin/echo/echo.test")
 }
 
+func TestEmoteCounterRestart(t *testing.T) {
+	runScript(t, "plugin/emote_counter/restart.test")
+}
+
 func TestJoinJoin(t *testing.T) {
 	runScript(t, "plugin/join/join.test")
 }
@@ -145,6 +193,22 @@ func TestPingPing(t *testing.T) {
 	runScript(t, "plugin/ping/ping.test")
 }
 
+func TestPluginControlCrash(t *testing.T) {
+	runScript(t, "plugin/plugin_control/crash.test")
+}
+
+func TestReloadReload(t *testing.T) {
+	runScript(t, "plugin/reload/reload.test")
+}
+
+func TestSettingsSettings(t *testing.T) {
+	runScript(t, "plugin/settings/settings.test")
+}
+
+func TestSysinfoChannelinfo(t *testing.T) {
+	runScript(t, "plugin/sysinfo/channelinfo.test")
+}
+
 func TestTrollCommands(t *testing.T) {
 	runScript(t, "plugin/troll/commands.test")
 }
@@ -0,0 +1,51 @@
+package test
+
+import (
+	"time"
+
+	"github.com/sgt-kabukiman/kabukibot/bot"
+)
+
+// StartBot connects a bot with the given plugins to a fake Twitch client, for tests
+// that use the bot without a chat script. Everything the bot says is discarded.
+func StartBot(config *bot.Configuration, db bot.Database, plugins ...bot.Plugin) (*bot.Kabukibot, error) {
+	tc := newFakeClient()
+
+	testBot, err := bot.NewKabukibot(tc, &fakeLog{}, db, config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, plugin := range plugins {
+		testBot.AddPlugin(plugin)
+	}
+
+	_, err = bot.NewMigrator(db, plugins).Up()
+	if err != nil {
+		return nil, err
+	}
+
+	go discard(tc)
+
+	err = testBot.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	go testBot.Work()
+
+	// wait for the bot to join its own channel
+	<-time.After(50 * time.Millisecond)
+
+	return testBot, nil
+}
+
+func discard(tc *fakeClient) {
+	for {
+		select {
+		case <-tc.outgoing:
+		case <-tc.closed:
+			return
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"sync"
 	"time"
 
 	"github.com/sgt-kabukiman/kabukibot/twitch"
@@ -10,6 +11,17 @@ type fakeClient struct {
 	incoming chan twitch.IncomingMessage
 	outgoing chan twitch.OutgoingMessage
 	ready    chan struct{}
+	closed   chan struct{}
+	mutex    sync.Mutex // guards sending delayed messages against closing incoming
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		incoming: make(chan twitch.IncomingMessage),
+		outgoing: make(chan twitch.OutgoingMessage, 10),
+		ready:    make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
 }
 
 func (c *fakeClient) Connect() error {
@@ -18,7 +30,12 @@ func (c *fakeClient) Connect() error {
 }
 
 func (c *fakeClient) Disconnect() error {
+	close(c.closed)
+
+	c.mutex.Lock()
 	close(c.incoming)
+	c.mutex.Unlock()
+
 	return nil
 }
 
@@ -30,6 +47,10 @@ func (c *fakeClient) Ready() <-chan struct{} {
 	return c.ready
 }
 
+func (c *fakeClient) Alive() <-chan struct{} {
+	return c.closed
+}
+
 func (c *fakeClient) QueueLen() int {
 	return 0
 }
@@ -42,6 +63,10 @@ func (c *fakeClient) MessagesReceived() uint64 {
 	return 0
 }
 
+func (c *fakeClient) MessagesDropped() uint64 {
+	return 0
+}
+
 func (c *fakeClient) Send(msg twitch.OutgoingMessage) <-chan bool {
 	asserted, okay := msg.(twitch.JoinMessage)
 	if okay {
@@ -53,9 +78,24 @@ func (c *fakeClient) Send(msg twitch.OutgoingMessage) <-chan bool {
 			// respond to a PART with a PART, but wait a bit because Kabukibot doesn't
 			// like it if the signal for "i left the channel" comes before it even had
 			// a chance to process the "i sent the PART request" event.
+			// When shutting down, the client might be disconnected by then.
 			go func() {
 				<-time.After(100 * time.Millisecond)
-				c.incoming <- asserted2
+
+				c.mutex.Lock()
+				defer c.mutex.Unlock()
+
+				// a select would pick randomly between both cases if both are ready
+				select {
+				case <-c.closed:
+					return
+				default:
+				}
+
+				select {
+				case c.incoming <- asserted2:
+				case <-c.closed:
+				}
 			}()
 		} else {
 			// send all other messages
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"github.com/sgt-kabukiman/kabukibot/bot"
+	"github.com/sgt-kabukiman/kabukibot/plugin"
+)
+
+// The crash plugin panics whenever someone says !crash, so that chat scripts can
+// test how the bot deals with broken plugins.
+type crashPlugin struct {
+	plugin.NilWorker
+}
+
+func NewCrashPlugin() *crashPlugin {
+	return &crashPlugin{}
+}
+
+func (self *crashPlugin) Name() string {
+	return "crash"
+}
+
+func (self *crashPlugin) Setup(bot *bot.Kabukibot) {
+}
+
+func (self *crashPlugin) CreateWorker(channel bot.Channel) bot.PluginWorker {
+	return self
+}
+
+func (self *crashPlugin) HandleTextMessage(msg *bot.TextMessage, sender bot.Sender) {
+	if msg.IsCommand("crash") {
+		panic("crashing as requested")
+	}
+
+	if msg.IsCommand("alive") {
+		sender.Respond("still here.")
+	}
+}
@@ -1,8 +1,12 @@
 package test
 
+import "github.com/sgt-kabukiman/kabukibot/bot"
+
 type fakeLog struct{}
 
 func (f *fakeLog) SetLevel(int)                   {}
+func (f *fakeLog) With(bot.Fields) bot.Logger     { return f }
+func (f *fakeLog) Subsystem(string) bot.Logger    { return f }
 func (f *fakeLog) Debug(string, ...interface{})   {}
 func (f *fakeLog) Info(string, ...interface{})    {}
 func (f *fakeLog) Warning(string, ...interface{}) {}
@@ -3,12 +3,13 @@ package test
 import (
 	"bufio"
 	"io"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sgt-kabukiman/kabukibot/bot"
 	"github.com/sgt-kabukiman/kabukibot/twitch"
 )
@@ -18,11 +19,13 @@ type pluginBuilder func() bot.Plugin
 type Tester struct {
 	file           io.Reader
 	config         *bot.Configuration
-	db             *sqlx.DB
+	db             bot.Database
 	pluginBuilders map[string]pluginBuilder
+	plugins        []string // the plugins added so far, to restore them on restart
+	environment    []string // the variables set so far, to unset them when done
 }
 
-func NewTester(file io.Reader, config *bot.Configuration, db *sqlx.DB) *Tester {
+func NewTester(file io.Reader, config *bot.Configuration, db bot.Database) *Tester {
 	return &Tester{
 		file:           file,
 		config:         config,
@@ -35,28 +38,53 @@ func (test *Tester) AddPlugin(name string, builder pluginBuilder) {
 	test.pluginBuilders[name] = builder
 }
 
-var injectedMessage = regexp.MustCompile(`< \[(#[a-z0-9_]+)\] ([$%&@!~+]*[a-z0-9_]+): (.+)$`)
+// users can optionally have a numeric ID, like "bob[42]", and messages can contain
+// emotes, given like Twitch's emotes tag: "bob{25:0-4,6-10}"
+var injectedMessage = regexp.MustCompile(`< \[(#[a-z0-9_]+)\] ([$%&@!~+]*[a-z0-9_]+)(?:\[([0-9]+)\])?(?:\{([0-9:,/-]+)\})?: (.+)$`)
 var expectedMessage = regexp.MustCompile(`> \[(#[a-z0-9_]+)\] ([$%&@!~+]*[a-z0-9_]+): (.+)$`)
 
-func (test *Tester) WipeDatabase() {
-	rows, _ := test.db.Queryx("SHOW TABLES")
-	for rows.Next() {
-		var table string
-		rows.Scan(&table)
+// Migrate brings the database schema up to date for all plugins that can be used in
+// the tests.
+func (test *Tester) Migrate() {
+	plugins := make([]bot.Plugin, 0, len(test.pluginBuilders))
+
+	for _, builder := range test.pluginBuilders {
+		plugins = append(plugins, builder())
+	}
 
-		test.db.MustExec("DELETE FROM `" + table + "` WHERE 1")
+	_, err := bot.NewMigrator(test.db, plugins).Up()
+	if err != nil {
+		panic(err)
 	}
 }
 
-func (test *Tester) Run(t *testing.T) {
-	log := &fakeLog{}
-	tc := &fakeClient{
-		incoming: make(chan twitch.IncomingMessage),
-		outgoing: make(chan twitch.OutgoingMessage, 10),
-		ready:    make(chan struct{}),
+func (test *Tester) WipeDatabase() {
+	tables, err := test.db.Dialect().Tables(test.db)
+	if err != nil {
+		panic(err)
 	}
 
-	testBot, _ := bot.NewKabukibot(tc, log, test.db, test.config)
+	for _, table := range tables {
+		if table == "schema_migration" {
+			continue
+		}
+
+		_, err = test.db.Exec("DELETE FROM `" + table + "` WHERE 1")
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (test *Tester) newBot() (*bot.Kabukibot, *fakeClient) {
+	tc := newFakeClient()
+	testBot, _ := bot.NewKabukibot(tc, &fakeLog{}, test.db, test.config)
+
+	return testBot, tc
+}
+
+func (test *Tester) Run(t *testing.T) {
+	testBot, tc := test.newBot()
 
 	lineNr := 0
 	lastLine := ""
@@ -81,12 +109,16 @@ func (test *Tester) Run(t *testing.T) {
 			test.joinCommand(t, testBot, lineNr, parts[1:])
 		case "wait":
 			test.waitCommand(t, testBot, lineNr, parts[1:])
+		case "restart":
+			testBot, tc = test.restartCommand(t, testBot, lineNr)
 		case "<":
 			test.sendCommand(t, testBot, lineNr, line, tc)
 		case ">":
 			test.receiveCommand(t, testBot, lineNr, line, tc)
 		case "silence":
 			test.silenceCommand(t, testBot, lineNr, lastLine, tc)
+		case "env":
+			test.envCommand(t, lineNr, parts[1:])
 		}
 
 		lastLine = line
@@ -94,6 +126,10 @@ func (test *Tester) Run(t *testing.T) {
 
 	// shutdown
 	testBot.Shutdown()
+
+	for _, name := range test.environment {
+		os.Unsetenv(name)
+	}
 }
 
 func (test *Tester) pluginCommand(t *testing.T, bot *bot.Kabukibot, lineNr int, args []string) {
@@ -105,6 +141,26 @@ func (test *Tester) pluginCommand(t *testing.T, bot *bot.Kabukibot, lineNr int,
 	}
 
 	bot.AddPlugin(builder())
+	test.plugins = append(test.plugins, plugin)
+}
+
+// restartCommand shuts the bot down and starts a fresh one with the same plugins
+// and database, which rejoins the previously joined channels.
+func (test *Tester) restartCommand(t *testing.T, oldBot *bot.Kabukibot, lineNr int) (*bot.Kabukibot, *fakeClient) {
+	oldBot.Shutdown()
+
+	newBot, tc := test.newBot()
+
+	for _, plugin := range test.plugins {
+		newBot.AddPlugin(test.pluginBuilders[plugin]())
+	}
+
+	test.connectCommand(t, newBot, lineNr)
+
+	// give the bot some time to rejoin all channels
+	<-time.After(50 * time.Millisecond)
+
+	return newBot, tc
 }
 
 func (test *Tester) connectCommand(t *testing.T, bot *bot.Kabukibot, lineNr int) {
@@ -124,6 +180,25 @@ func (test *Tester) joinCommand(t *testing.T, bot *bot.Kabukibot, lineNr int, ar
 	<-time.After(50 * time.Millisecond)
 }
 
+// envCommand sets an environment variable, like "env KABUKIBOT_IRC_PORT 6697", which
+// is picked up when the configuration is reloaded. Without a value, it is unset.
+func (test *Tester) envCommand(t *testing.T, lineNr int, args []string) {
+	if len(args) == 0 {
+		t.Errorf("[line %d] env needs a variable name", lineNr)
+		return
+	}
+
+	parts := strings.SplitN(args[0], " ", 2)
+
+	if len(parts) == 1 {
+		os.Unsetenv(parts[0])
+	} else {
+		os.Setenv(parts[0], parts[1])
+	}
+
+	test.environment = append(test.environment, parts[0])
+}
+
 func (test *Tester) waitCommand(t *testing.T, bot *bot.Kabukibot, lineNr int, args []string) {
 	duration := 50 * time.Millisecond
 
@@ -141,14 +216,21 @@ func (test *Tester) waitCommand(t *testing.T, bot *bot.Kabukibot, lineNr int, ar
 
 func (test *Tester) sendCommand(t *testing.T, bot *bot.Kabukibot, lineNr int, line string, client *fakeClient) {
 	matched := injectedMessage.FindStringSubmatch(line)
-	if len(matched) != 4 {
+	if len(matched) != 6 {
 		t.Errorf("[line %d] invalid line: '%s'", lineNr, line)
 	}
 
+	id, _ := strconv.Atoi(matched[3])
+	user := twitch.User{Name: matched[2], Login: strings.ToLower(matched[2]), ID: id}
+
+	if len(matched[4]) > 0 {
+		user.Emotes = twitch.ParseEmotesTag(matched[4])
+	}
+
 	client.incoming <- twitch.TextMessage{
 		Channel: matched[1],
-		User:    twitch.User{Name: matched[2]},
-		Text:    matched[3],
+		User:    user,
+		Text:    matched[5],
 	}
 }
 
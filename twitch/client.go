@@ -5,6 +5,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sorcix/irc"
@@ -20,12 +21,13 @@ type queueItem struct {
 	signal  chan bool
 }
 
+// the client only needs a part of the bot's logger; messages are printf formats
+// when they are given arguments
 type logger interface {
 	Debug(string, ...interface{})
 	Info(string, ...interface{})
 	Warning(string, ...interface{})
 	Error(string, ...interface{})
-	Fatal(string, ...interface{})
 }
 
 type TwitchClient struct {
@@ -58,6 +60,10 @@ type TwitchClient struct {
 	stoppedSending   chan struct{}
 	stoppedReceiving chan struct{}
 
+	// both we and a dying connection can disconnect, but only the first one counts
+	disconnect    sync.Once
+	disconnectErr error
+
 	// on this channel incoming messages from the network are sent
 	incoming chan IncomingMessage
 
@@ -67,8 +73,10 @@ type TwitchClient struct {
 	queueSize  int
 	queueMutex sync.Mutex
 
+	// counted atomically, as they are read from other goroutines
 	msgSent     uint64
 	msgReceived uint64
+	msgDropped  uint64
 
 	logger logger
 }
@@ -119,7 +127,7 @@ func (client *TwitchClient) Connect() error {
 
 	conn, err := net.Dial("tcp", client.server)
 	if err != nil {
-		client.logger.Error("Connection failed: " + err.Error())
+		client.logger.Error("Connection to %s failed: %s", client.server, err.Error())
 		return err
 	}
 
@@ -153,34 +161,47 @@ func (client *TwitchClient) Connect() error {
 }
 
 func (client *TwitchClient) Disconnect() error {
-	// stop the sender/receiver and wait for them to stop (maybe it will drain the
-	// outgoing queue, maybe it won't, but let's give it time)
-	close(client.stopReceiving)
-	<-client.stoppedReceiving
+	client.disconnect.Do(func() {
+		// stop the sender/receiver and wait for them to stop (maybe it will drain the
+		// outgoing queue, maybe it won't, but let's give it time)
+		close(client.stopReceiving)
+		<-client.stoppedReceiving
+
+		close(client.stopSending)
+		<-client.stoppedSending
 
-	close(client.stopSending)
-	<-client.stoppedSending
+		// close the incoming queue
+		close(client.incoming)
 
-	// close the incoming queue
-	close(client.incoming)
+		// for all intents and purposes, we are not alive anymore
+		close(client.alive)
 
-	// for all intents and purposes, we are not alive anymore
-	close(client.alive)
+		// close the IRC connection
+		client.disconnectErr = client.conn.Close()
+	})
 
-	// close the IRC connection
-	return client.conn.Close()
+	return client.disconnectErr
 }
 
 func (client *TwitchClient) QueueLen() int {
+	client.queueMutex.Lock()
+	defer client.queueMutex.Unlock()
+
 	return client.queueLen
 }
 
 func (client *TwitchClient) MessagesSent() uint64 {
-	return client.msgSent
+	return atomic.LoadUint64(&client.msgSent)
 }
 
 func (client *TwitchClient) MessagesReceived() uint64 {
-	return client.msgReceived
+	return atomic.LoadUint64(&client.msgReceived)
+}
+
+// MessagesDropped counts the outgoing messages that were thrown away because the
+// queue was full.
+func (client *TwitchClient) MessagesDropped() uint64 {
+	return atomic.LoadUint64(&client.msgDropped)
 }
 
 func (client *TwitchClient) Send(msg OutgoingMessage) <-chan bool {
@@ -190,6 +211,7 @@ func (client *TwitchClient) Send(msg OutgoingMessage) <-chan bool {
 
 	// silenty drop the message so our queue doesn't grow infinitely
 	if client.queueLen >= client.queueSize {
+		atomic.AddUint64(&client.msgDropped, 1)
 		signal <- false
 		close(signal)
 	} else {
@@ -208,12 +230,13 @@ func (client *TwitchClient) sender() {
 		case msg := <-client.outgoing:
 			ircMsg := msg.message.IrcMessage()
 			// fmt.Println("< " + ircMsg.String())
-			client.writer.Encode(ircMsg)
-
-			client.msgSent++
+			err := client.writer.Encode(ircMsg)
+			if err == nil {
+				atomic.AddUint64(&client.msgSent, 1)
+			}
 
-			// signal to the one who sent the message that it was in fact sent
-			msg.signal <- true
+			// signal to the one who sent the message whether it was in fact sent
+			msg.signal <- err == nil
 			close(msg.signal)
 
 			client.queueMutex.Lock()
@@ -252,7 +275,7 @@ func (client *TwitchClient) receiver() {
 
 				line, err := client.reader.ReadString('\n')
 				if err != nil {
-					client.logger.Error("Connection died: " + err.Error())
+					client.logger.Error("Connection died: %s", err.Error())
 					client.Disconnect()
 					return
 				}
@@ -285,7 +308,7 @@ func (client *TwitchClient) receiver() {
 				msg = irc.ParseMessage(rawLine)
 			}
 
-			client.msgReceived++
+			atomic.AddUint64(&client.msgReceived, 1)
 
 			// hand it over to the message handler;
 			// this could be done in goroutines by simply doing "go handler(...)",
@@ -1,7 +1,6 @@
 package twitch
 
 import (
-	"log"
 	"strconv"
 	"strings"
 
@@ -37,8 +36,12 @@ func (client *TwitchClient) onWelcome(msg *irc.Message, tags irc.Tags) {
 	// wait for the message being sent
 	okay := <-sent
 
+	// without the capabilities, there are neither tags nor JOINs, so give up; this
+	// runs in the receiving goroutine, which the disconnect waits for
 	if !okay {
-		log.Fatal("Could not sent capabilities. Cannot procede.")
+		client.logger.Error("Could not send the capabilities, disconnecting.")
+		go client.Disconnect()
+		return
 	}
 
 	// signal to the outside world that now everything is set up
@@ -98,6 +101,7 @@ func (client *TwitchClient) onPrivmsg(msg *irc.Message, tags irc.Tags) {
 	// parse user information from tags
 	user := User{
 		Name:   nickname,
+		Login:  strings.ToLower(nickname),
 		Type:   Plebs,
 		Myself: strings.ToLower(nickname) == strings.ToLower(client.username),
 	}
@@ -120,7 +124,7 @@ func (client *TwitchClient) onPrivmsg(msg *irc.Message, tags irc.Tags) {
 	value, okay := tags["user-id"]
 	if okay {
 		id, err := strconv.Atoi(value)
-		if err != nil {
+		if err == nil {
 			user.ID = id
 		}
 	}
@@ -132,7 +136,7 @@ func (client *TwitchClient) onPrivmsg(msg *irc.Message, tags irc.Tags) {
 
 	value, okay = tags["emotes"]
 	if okay {
-		user.Emotes = parseEmotesTag(value)
+		user.Emotes = ParseEmotesTag(value)
 	}
 
 	value, okay = tags["user-type"]
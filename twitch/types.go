@@ -15,9 +15,11 @@ type Client interface {
 	Disconnect() error
 	Incoming() <-chan IncomingMessage
 	Ready() <-chan struct{}
+	Alive() <-chan struct{}
 	QueueLen() int
 	MessagesSent() uint64
 	MessagesReceived() uint64
+	MessagesDropped() uint64
 	Send(msg OutgoingMessage) <-chan bool
 }
 
@@ -159,7 +161,7 @@ func parseSubNotification(msg *irc.Message) SubscriberNotificationMessage {
 			out.User = match[1]
 
 			months, err := strconv.Atoi(match[2])
-			if err != nil {
+			if err == nil {
 				out.Months = months
 			}
 		}
@@ -200,7 +202,8 @@ type EmoticonMarker struct {
 type EmoticonMarkers map[int][]EmoticonMarker
 
 type User struct {
-	Name       string
+	Name       string // the display name, which can change at any time
+	Login      string // the lowercase account name
 	Myself     bool
 	Subscriber bool
 	Turbo      bool
@@ -213,7 +216,7 @@ type User struct {
 // Parses emoticon marker tags
 //
 // encoded is a string like "34:67-70,100-103/14:56-61"
-func parseEmotesTag(encoded string) EmoticonMarkers {
+func ParseEmotesTag(encoded string) EmoticonMarkers {
 	parts := strings.Split(encoded, "/")
 	result := make(EmoticonMarkers)
 